@@ -0,0 +1,39 @@
+// mock-notion 模拟 Notion 的 api/v3 接口，配合 NOTION_BASE_URL 在没有 Notion 账号和网络的情况下运行代理：
+//
+//	go run ./cmd/mock-notion -port 8788 -scenarios mock-scenarios.example.yaml
+//	NOTION_BASE_URL=http://localhost:8788 NOTION_COOKIE=mock NOTION_SPACE_ID=mock NOTION_USER_ID=mock go run .
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/mocknotion"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	port := flag.Int("port", 8788, "监听端口")
+	scenarios := flag.String("scenarios", "", "场景脚本文件（YAML 或 JSON），不指定时所有请求都回显用户消息")
+	flag.Parse()
+
+	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	gin.SetMode(gin.ReleaseMode)
+
+	var script *mocknotion.Script
+	if *scenarios != "" {
+		var err error
+		if script, err = mocknotion.LoadScript(*scenarios); err != nil {
+			log.Fatalf("加载场景失败: %v", err)
+		}
+		log.Infof("已加载 %d 个场景", len(script.Scenarios))
+	}
+
+	addr := fmt.Sprintf(":%d", *port)
+	log.Infof("模拟 Notion 服务启动在 http://localhost%s，代理设置 NOTION_BASE_URL=http://localhost%s 即可使用", addr, addr)
+	if err := http.ListenAndServe(addr, mocknotion.New(script).Handler()); err != nil {
+		log.Fatalf("启动模拟服务失败: %v", err)
+	}
+}
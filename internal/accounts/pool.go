@@ -0,0 +1,328 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/metrics"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 账号选择策略
+const (
+	// StrategyRoundRobin 依次轮换账号
+	StrategyRoundRobin = "round-robin"
+	// StrategyLeastUsed 优先选择进行中请求最少、累计请求最少的账号
+	StrategyLeastUsed = "least-used"
+	// StrategySticky 同一个 API Key 固定使用同一个账号，账号不可用时重新分配
+	StrategySticky = "sticky"
+)
+
+// UnhealthyAfter 连续失败达到该次数的账号视为不健康，偶发的网络错误不影响就绪状态
+const UnhealthyAfter = 3
+
+// ErrNoAvailableAccount 所有账号都已用尽额度或已在本次请求中尝试过
+var ErrNoAvailableAccount = errors.New("没有可用的 Notion 账号，所有账号的额度均已用尽")
+
+// ErrCircuitOpen 其余账号都因连续失败被熔断，请求直接失败而不再等待 Notion 超时
+var ErrCircuitOpen = errors.New("Notion 暂时不可用（连续请求失败，已熔断），请稍后重试")
+
+// Account 账号池中的一个 Notion 账号
+type Account struct {
+	config.NotionAccount
+
+	// 以下状态由 Pool 加锁维护
+	inFlight       int
+	requests       int64
+	exhaustedUntil time.Time
+	limitCurrent   int
+	limitTotal     int
+
+	// 健康状态，用于就绪检查
+	warmupAt     time.Time
+	warmupErr    string
+	lastSuccess  time.Time
+	lastFailure  time.Time
+	lastErr      string
+	failureCount int
+
+	// 熔断状态：breakerUntil 之前不选择该账号，之后放行一个试探请求（breakerProbe），成功即恢复
+	breakerUntil time.Time
+	breakerProbe bool
+}
+
+// Status 账号的当前状态快照
+type Status struct {
+	Name      string
+	Available bool
+	InFlight  int
+	Requests  int64
+	// Cooldown 额度用尽的账号距离重新启用的剩余时间
+	Cooldown time.Duration
+	// LimitCurrent/LimitTotal 最近一次额度用尽时 Notion 返回的用量
+	LimitCurrent int
+	LimitTotal   int
+
+	// WarmupAt 最近一次会话预热时间，WarmupError 为空表示预热成功
+	WarmupAt    time.Time
+	WarmupError string
+	// LastSuccess 最近一次成功发起推理的时间
+	LastSuccess time.Time
+	// LastFailure/LastError 最近一次发起推理失败的时间和原因，ConsecutiveFailures 为此后的连续失败次数
+	LastFailure         time.Time
+	LastError           string
+	ConsecutiveFailures int
+	// CircuitOpen 账号因连续失败被熔断
+	CircuitOpen bool
+	// Healthy 有额度、已预热或成功推理过，并且没有连续失败
+	Healthy bool
+}
+
+// Pool 多个 Notion 账号组成的账号池，按策略选择账号并跳过额度用尽的账号
+type Pool struct {
+	mu       sync.Mutex
+	accounts []*Account
+	strategy string
+	cooldown time.Duration
+	next     int
+	sticky   map[string]*Account
+
+	// 连续失败 breakerThreshold 次后熔断 breakerCooldown，threshold 为 0 表示不熔断
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+// NewPool 创建账号池，cooldown 为账号被标记为额度用尽后重新启用前的等待时间
+func NewPool(list []config.NotionAccount, strategy string, cooldown time.Duration) (*Pool, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("至少需要配置一个 Notion 账号")
+	}
+	switch strategy {
+	case StrategyRoundRobin, StrategyLeastUsed, StrategySticky:
+	default:
+		return nil, fmt.Errorf("未知的账号选择策略: %s", strategy)
+	}
+
+	pool := &Pool{
+		strategy: strategy,
+		cooldown: cooldown,
+		sticky:   make(map[string]*Account),
+	}
+	for _, account := range list {
+		pool.accounts = append(pool.accounts, &Account{NotionAccount: account})
+	}
+	return pool, nil
+}
+
+// SetCircuitBreaker 设置熔断：账号连续失败 threshold 次后的 cooldown 时间内不再被选中，
+// 之后放行一个试探请求，成功则恢复，失败则再次熔断。threshold 为 0 表示不熔断。
+func (p *Pool) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.breakerThreshold = threshold
+	p.breakerCooldown = cooldown
+}
+
+// Accounts 返回池中的所有账号
+func (p *Pool) Accounts() []*Account {
+	return p.accounts
+}
+
+// Acquire 按策略选择一个可用账号。key 为客户端的 API Key，仅 sticky 策略使用；
+// exclude 中的账号（本次请求已尝试过的）会被跳过。使用完毕后必须调用 Release。
+func (p *Pool) Acquire(key string, exclude map[string]bool) (*Account, error) {
+	return p.AcquirePreferred("", key, exclude)
+}
+
+// AcquirePreferred 与 Acquire 相同，但 preferred 账号可用时优先使用它（例如继续该账号下的对话线程）
+func (p *Pool) AcquirePreferred(preferred, key string, exclude map[string]bool) (*Account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	usable := func(a *Account) bool {
+		return !exclude[a.Name] && p.available(a, now)
+	}
+
+	var chosen *Account
+	for _, a := range p.accounts {
+		if preferred != "" && a.Name == preferred && usable(a) {
+			chosen = a
+			break
+		}
+	}
+
+	switch {
+	case chosen != nil:
+	case p.strategy == StrategyLeastUsed:
+		for _, a := range p.accounts {
+			if !usable(a) {
+				continue
+			}
+			if chosen == nil || a.inFlight < chosen.inFlight ||
+				(a.inFlight == chosen.inFlight && a.requests < chosen.requests) {
+				chosen = a
+			}
+		}
+	case p.strategy == StrategySticky:
+		if a, ok := p.sticky[key]; ok && usable(a) {
+			chosen = a
+		} else if chosen = p.nextRoundRobin(usable); chosen != nil && key != "" {
+			p.sticky[key] = chosen
+		}
+	default:
+		chosen = p.nextRoundRobin(usable)
+	}
+
+	if chosen == nil {
+		for _, a := range p.accounts {
+			if !exclude[a.Name] && !a.breakerUntil.IsZero() {
+				return nil, ErrCircuitOpen
+			}
+		}
+		return nil, ErrNoAvailableAccount
+	}
+	if !chosen.breakerUntil.IsZero() {
+		chosen.breakerProbe = true
+		log.Infof("Notion 账号 %s 熔断时间已过，放行试探请求", chosen.Name)
+	}
+	chosen.inFlight++
+	chosen.requests++
+	return chosen, nil
+}
+
+// Release 归还 Acquire 得到的账号
+func (p *Pool) Release(a *Account) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a.inFlight > 0 {
+		a.inFlight--
+	}
+	a.breakerProbe = false
+}
+
+// MarkExhausted 将账号标记为额度用尽，在冷却时间内不再被选中
+func (p *Pool) MarkExhausted(a *Account, current, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a.exhaustedUntil = time.Now().Add(p.cooldown)
+	a.limitCurrent = current
+	a.limitTotal = total
+	metrics.QuotaExhausted.WithLabelValues(a.Name).Inc()
+	log.Warnf("Notion 账号 %s 额度已用尽 (%d/%d)，%s 后重新启用", a.Name, current, total, p.cooldown)
+}
+
+// Status 返回所有账号的状态快照
+func (p *Pool) Status() []Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Status, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		status := Status{
+			Name:         a.Name,
+			Available:    !now.Before(a.exhaustedUntil),
+			InFlight:     a.inFlight,
+			Requests:     a.requests,
+			LimitCurrent: a.limitCurrent,
+			LimitTotal:   a.limitTotal,
+
+			WarmupAt:            a.warmupAt,
+			WarmupError:         a.warmupErr,
+			LastSuccess:         a.lastSuccess,
+			LastFailure:         a.lastFailure,
+			LastError:           a.lastErr,
+			ConsecutiveFailures: a.failureCount,
+			CircuitOpen:         !a.breakerUntil.IsZero(),
+		}
+		if !status.Available {
+			status.Cooldown = a.exhaustedUntil.Sub(now)
+		}
+		verified := (!a.warmupAt.IsZero() && a.warmupErr == "") || !a.lastSuccess.IsZero()
+		status.Healthy = status.Available && verified && a.failureCount < UnhealthyAfter && !status.CircuitOpen
+		list = append(list, status)
+	}
+	return list
+}
+
+// RecordWarmup 记录会话预热结果，err 为 nil 表示成功
+func (p *Pool) RecordWarmup(a *Account, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a.warmupAt = time.Now()
+	a.warmupErr = ""
+	if err != nil {
+		a.warmupErr = err.Error()
+	}
+}
+
+// NeedsWarmup 预热失败且之后没有成功推理过的账号需要重新预热
+func (p *Pool) NeedsWarmup(a *Account) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return a.warmupErr != "" && a.lastSuccess.Before(a.warmupAt)
+}
+
+// RecordSuccess 记录一次成功发起的推理，清零连续失败次数
+func (p *Pool) RecordSuccess(a *Account) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a.lastSuccess = time.Now()
+	a.failureCount = 0
+	if !a.breakerUntil.IsZero() {
+		log.Infof("Notion 账号 %s 试探请求成功，解除熔断", a.Name)
+		a.breakerUntil = time.Time{}
+	}
+}
+
+// RecordFailure 记录一次发起推理失败（不含额度用尽，额度由 MarkExhausted 记录）
+func (p *Pool) RecordFailure(a *Account, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a.lastFailure = time.Now()
+	a.lastErr = err.Error()
+	a.failureCount++
+	if p.breakerThreshold > 0 && a.failureCount >= p.breakerThreshold {
+		a.breakerUntil = a.lastFailure.Add(p.breakerCooldown)
+		metrics.CircuitOpened.WithLabelValues(a.Name).Inc()
+		log.Warnf("Notion 账号 %s 连续失败 %d 次，熔断 %s", a.Name, a.failureCount, p.breakerCooldown)
+	}
+}
+
+// nextRoundRobin 从上次选中的位置开始查找下一个可用账号，调用方需持有锁
+func (p *Pool) nextRoundRobin(usable func(*Account) bool) *Account {
+	for i := 0; i < len(p.accounts); i++ {
+		idx := (p.next + i) % len(p.accounts)
+		if usable(p.accounts[idx]) {
+			p.next = idx + 1
+			return p.accounts[idx]
+		}
+	}
+	return nil
+}
+
+// available 判断账号是否可用，冷却结束的账号会被重新启用；熔断中或正在试探的账号不可用。调用方需持有锁
+func (p *Pool) available(a *Account, now time.Time) bool {
+	if !a.breakerUntil.IsZero() && (now.Before(a.breakerUntil) || a.breakerProbe) {
+		return false
+	}
+	if a.exhaustedUntil.IsZero() {
+		return true
+	}
+	if now.Before(a.exhaustedUntil) {
+		return false
+	}
+	log.Infof("Notion 账号 %s 冷却结束，重新启用", a.Name)
+	a.exhaustedUntil = time.Time{}
+	a.limitCurrent = 0
+	a.limitTotal = 0
+	return true
+}
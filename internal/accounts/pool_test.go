@@ -0,0 +1,216 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"notion-2api-go/internal/config"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, n int, strategy string, cooldown time.Duration) *Pool {
+	t.Helper()
+	var list []config.NotionAccount
+	for i := 0; i < n; i++ {
+		list = append(list, config.NotionAccount{Name: fmt.Sprintf("a%d", i)})
+	}
+	pool, err := NewPool(list, strategy, cooldown)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	return pool
+}
+
+// acquire 选择一个账号并立即归还，返回账号名
+func acquire(t *testing.T, pool *Pool, key string, exclude map[string]bool) string {
+	t.Helper()
+	a, err := pool.Acquire(key, exclude)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	pool.Release(a)
+	return a.Name
+}
+
+func TestNewPoolRejectsInvalidConfig(t *testing.T) {
+	if _, err := NewPool(nil, StrategyRoundRobin, time.Minute); err == nil {
+		t.Error("NewPool() without accounts succeeded")
+	}
+	if _, err := NewPool([]config.NotionAccount{{Name: "a"}}, "random", time.Minute); err == nil {
+		t.Error("NewPool() with unknown strategy succeeded")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := newTestPool(t, 3, StrategyRoundRobin, time.Minute)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, acquire(t, pool, "", nil))
+	}
+	if want := "[a0 a1 a2 a0]"; fmt.Sprint(got) != want {
+		t.Errorf("round-robin order = %v, want %s", got, want)
+	}
+
+	// 本次请求已尝试过的账号被跳过
+	if name := acquire(t, pool, "", map[string]bool{"a1": true}); name != "a2" {
+		t.Errorf("Acquire() excluding a1 = %s, want a2", name)
+	}
+}
+
+func TestLeastUsed(t *testing.T) {
+	pool := newTestPool(t, 2, StrategyLeastUsed, time.Minute)
+
+	// a0 有进行中的请求时选择 a1
+	busy, err := pool.Acquire("", nil)
+	if err != nil || busy.Name != "a0" {
+		t.Fatalf("first Acquire() = %v, %v, want a0", busy, err)
+	}
+	if name := acquire(t, pool, "", nil); name != "a1" {
+		t.Errorf("Acquire() while a0 is busy = %s, want a1", name)
+	}
+	pool.Release(busy)
+
+	// 都空闲时选择累计请求较少的账号，相同时选择靠前的
+	if name := acquire(t, pool, "", nil); name != "a0" {
+		t.Errorf("Acquire() with equal requests = %s, want a0", name)
+	}
+	pool.Acquire("", nil) // a1，不归还
+	if name := acquire(t, pool, "", nil); name != "a0" {
+		t.Errorf("Acquire() while a1 is busy = %s, want a0", name)
+	}
+}
+
+func TestSticky(t *testing.T) {
+	pool := newTestPool(t, 3, StrategySticky, time.Minute)
+
+	first := acquire(t, pool, "key-1", nil)
+	second := acquire(t, pool, "key-2", nil)
+	if first == second {
+		t.Fatalf("different keys share account %s", first)
+	}
+	for i := 0; i < 3; i++ {
+		if name := acquire(t, pool, "key-1", nil); name != first {
+			t.Fatalf("key-1 moved from %s to %s", first, name)
+		}
+	}
+
+	// 固定的账号额度用尽后重新分配，并固定到新账号
+	for _, a := range pool.Accounts() {
+		if a.Name == first {
+			pool.MarkExhausted(a, 20, 20)
+		}
+	}
+	moved := acquire(t, pool, "key-1", nil)
+	if moved == first {
+		t.Fatalf("key-1 still uses exhausted account %s", first)
+	}
+	if name := acquire(t, pool, "key-1", nil); name != moved {
+		t.Errorf("key-1 moved again from %s to %s", moved, name)
+	}
+}
+
+func TestMarkExhausted(t *testing.T) {
+	pool := newTestPool(t, 2, StrategyRoundRobin, 50*time.Millisecond)
+	a0 := pool.Accounts()[0]
+	pool.MarkExhausted(a0, 20, 20)
+
+	for i := 0; i < 3; i++ {
+		if name := acquire(t, pool, "", nil); name != "a1" {
+			t.Fatalf("Acquire() = %s while a0 is exhausted, want a1", name)
+		}
+	}
+	if _, err := pool.Acquire("", map[string]bool{"a1": true}); !errors.Is(err, ErrNoAvailableAccount) {
+		t.Fatalf("Acquire() with every account unusable: error = %v, want ErrNoAvailableAccount", err)
+	}
+
+	// 冷却结束后重新启用
+	time.Sleep(60 * time.Millisecond)
+	if name := acquire(t, pool, "", map[string]bool{"a1": true}); name != "a0" {
+		t.Errorf("Acquire() after cooldown = %s, want a0", name)
+	}
+}
+
+// circuitOpen 返回账号当前是否处于熔断状态
+func circuitOpen(pool *Pool, name string) bool {
+	for _, s := range pool.Status() {
+		if s.Name == name {
+			return s.CircuitOpen
+		}
+	}
+	return false
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	pool := newTestPool(t, 2, StrategyRoundRobin, time.Minute)
+	pool.SetCircuitBreaker(2, 50*time.Millisecond)
+	a0 := pool.Accounts()[0]
+	failure := errors.New("connection reset")
+
+	// 未达到阈值时仍可选中，成功会清零连续失败次数
+	pool.RecordFailure(a0, failure)
+	pool.RecordSuccess(a0)
+	pool.RecordFailure(a0, failure)
+	if circuitOpen(pool, "a0") {
+		t.Fatal("circuit opened before reaching the threshold")
+	}
+
+	pool.RecordFailure(a0, failure)
+	if !circuitOpen(pool, "a0") {
+		t.Fatal("circuit still closed after reaching the threshold")
+	}
+	for i := 0; i < 3; i++ {
+		if name := acquire(t, pool, "", nil); name != "a1" {
+			t.Fatalf("Acquire() = %s while a0 is open, want a1", name)
+		}
+	}
+	// 其余账号都被排除时直接报告熔断，而不是额度用尽
+	if _, err := pool.Acquire("", map[string]bool{"a1": true}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Acquire() with only an open account: error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 熔断时间过后只放行一个试探请求
+	time.Sleep(60 * time.Millisecond)
+	probe, err := pool.Acquire("", map[string]bool{"a1": true})
+	if err != nil || probe != a0 {
+		t.Fatalf("probe Acquire() = %v, %v, want a0", probe, err)
+	}
+	if _, err := pool.Acquire("", map[string]bool{"a1": true}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second Acquire() during the probe: error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 试探失败重新熔断
+	pool.RecordFailure(a0, failure)
+	pool.Release(a0)
+	if _, err := pool.Acquire("", map[string]bool{"a1": true}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Acquire() after a failed probe: error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 试探成功解除熔断
+	time.Sleep(60 * time.Millisecond)
+	probe, err = pool.Acquire("", map[string]bool{"a1": true})
+	if err != nil {
+		t.Fatalf("second probe Acquire() error = %v", err)
+	}
+	pool.RecordSuccess(probe)
+	pool.Release(probe)
+	if circuitOpen(pool, "a0") {
+		t.Fatal("circuit still open after a successful probe")
+	}
+	if name := acquire(t, pool, "", map[string]bool{"a1": true}); name != "a0" {
+		t.Errorf("Acquire() after recovery = %s, want a0", name)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	pool := newTestPool(t, 1, StrategyRoundRobin, time.Minute)
+	a0 := pool.Accounts()[0]
+	for i := 0; i < 10; i++ {
+		pool.RecordFailure(a0, errors.New("timeout"))
+	}
+	if circuitOpen(pool, "a0") {
+		t.Fatal("circuit opened with threshold 0")
+	}
+	if name := acquire(t, pool, "", nil); name != "a0" {
+		t.Errorf("Acquire() = %s, want a0", name)
+	}
+}
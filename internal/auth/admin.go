@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyView 管理接口返回的 Key 信息，不包含密钥摘要
+type keyView struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hint      string     `json:"hint"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Limits
+	Usage Usage `json:"usage"`
+	// Key 明文密钥，只在创建和轮换时返回
+	Key string `json:"key,omitempty"`
+}
+
+func newKeyView(k *Key, secret string) keyView {
+	return keyView{
+		ID:        k.ID,
+		Name:      k.Name,
+		Hint:      k.Hint,
+		CreatedAt: k.CreatedAt,
+		RevokedAt: k.RevokedAt,
+		Limits:    k.Limits,
+		Usage:     k.Usage,
+		Key:       secret,
+	}
+}
+
+// createKeyRequest 创建 Key 的请求体
+type createKeyRequest struct {
+	Name string `json:"name"`
+	Limits
+	// ExpiresInDays 与 expires_at 二选一，按天数设置有效期
+	ExpiresInDays int `json:"expires_in_days,omitempty"`
+}
+
+// RegisterAdminRoutes 在 group 下注册 Key 管理接口，调用方负责管理员认证：
+//
+//	POST   /keys             创建 Key
+//	GET    /keys             列出所有 Key
+//	POST   /keys/:id/rotate  轮换密钥
+//	DELETE /keys/:id         吊销 Key
+func RegisterAdminRoutes(group *gin.RouterGroup, store *Store) {
+	group.POST("/keys", func(c *gin.Context) {
+		var req createKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			adminError(c, http.StatusBadRequest, fmt.Sprintf("无效的请求数据: %v", err))
+			return
+		}
+		if req.Name == "" {
+			adminError(c, http.StatusBadRequest, "name 不能为空")
+			return
+		}
+		for _, endpoint := range req.Endpoints {
+			switch endpoint {
+			case EndpointChatCompletions, EndpointMessages, EndpointModels:
+			default:
+				adminError(c, http.StatusBadRequest, fmt.Sprintf("未知的接口: %s，可选 %s / %s / %s", endpoint, EndpointChatCompletions, EndpointMessages, EndpointModels))
+				return
+			}
+		}
+		if req.ThreadRetention != nil && *req.ThreadRetention < -1 {
+			adminError(c, http.StatusBadRequest, "thread_retention 必须大于等于 -1")
+			return
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays)
+			req.ExpiresAt = &expiresAt
+		}
+
+		k, secret, err := store.Create(req.Name, req.Limits)
+		if err != nil {
+			adminError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logging.FromContext(c.Request.Context()).Infof("已创建 API Key %s (%s)", k.ID, k.Name)
+		c.JSON(http.StatusCreated, newKeyView(k, secret))
+	})
+
+	group.GET("/keys", func(c *gin.Context) {
+		keys := store.List()
+		views := make([]keyView, 0, len(keys))
+		for i := range keys {
+			views = append(views, newKeyView(&keys[i], ""))
+		}
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": views})
+	})
+
+	group.POST("/keys/:id/rotate", func(c *gin.Context) {
+		k, secret, err := store.Rotate(c.Param("id"))
+		if err != nil {
+			adminStoreError(c, err)
+			return
+		}
+		logging.FromContext(c.Request.Context()).Infof("已轮换 API Key %s (%s)", k.ID, k.Name)
+		c.JSON(http.StatusOK, newKeyView(k, secret))
+	})
+
+	group.DELETE("/keys/:id", func(c *gin.Context) {
+		k, err := store.Revoke(c.Param("id"))
+		if err != nil {
+			adminStoreError(c, err)
+			return
+		}
+		logging.FromContext(c.Request.Context()).Infof("已吊销 API Key %s (%s)", k.ID, k.Name)
+		c.JSON(http.StatusOK, newKeyView(k, ""))
+	})
+}
+
+func adminStoreError(c *gin.Context, err error) {
+	if errors.Is(err, ErrKeyNotFound) {
+		adminError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	adminError(c, http.StatusInternalServerError, err.Error())
+}
+
+func adminError(c *gin.Context, status int, message string) {
+	errType := "invalid_request_error"
+	switch status {
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusInternalServerError:
+		errType = "server_error"
+	}
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": errType}})
+}
@@ -0,0 +1,413 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"notion-2api-go/internal/jsonstore"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 客户端可以访问的接口，用于 Key.Endpoints
+const (
+	EndpointChatCompletions = "chat.completions"
+	EndpointMessages        = "messages"
+	EndpointModels          = "models"
+)
+
+// keyPrefix 生成的 API Key 前缀
+const keyPrefix = "sk-n2a-"
+
+// flushInterval 用量数据写回文件的间隔
+const flushInterval = 10 * time.Second
+
+var (
+	// ErrInvalidKey API Key 不存在或已吊销
+	ErrInvalidKey = errors.New("无效的 API Key")
+	// ErrKeyExpired API Key 已过期
+	ErrKeyExpired = errors.New("API Key 已过期")
+	// ErrKeyNotFound 管理接口指定的 Key 不存在
+	ErrKeyNotFound = errors.New("API Key 不存在")
+)
+
+// QuotaError Key 的请求数或 token 配额已用尽
+type QuotaError struct {
+	Message string
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// Usage Key 在当前日、当前月的用量
+type Usage struct {
+	Day           string `json:"day"`
+	DayRequests   int64  `json:"day_requests"`
+	DayTokens     int64  `json:"day_tokens"`
+	Month         string `json:"month"`
+	MonthRequests int64  `json:"month_requests"`
+	MonthTokens   int64  `json:"month_tokens"`
+}
+
+// Limits Key 的访问范围和配额，数值为 0 表示不限制
+type Limits struct {
+	// Models 允许使用的模型，支持 * 通配符，为空表示不限制
+	Models []string `json:"models,omitempty"`
+	// Endpoints 允许访问的接口，为空表示不限制
+	Endpoints       []string   `json:"endpoints,omitempty"`
+	DailyRequests   int64      `json:"daily_requests,omitempty"`
+	MonthlyRequests int64      `json:"monthly_requests,omitempty"`
+	DailyTokens     int64      `json:"daily_tokens,omitempty"`
+	MonthlyTokens   int64      `json:"monthly_tokens,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	// RequestsPerMinute / MaxConcurrent 覆盖全局配置中按 Key 限流的默认值
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	MaxConcurrent     int `json:"max_concurrent,omitempty"`
+	// ThreadRetention 覆盖 THREAD_RETENTION：该 Key 的请求创建的 Notion 线程保留多久后归档（秒），
+	// 0 表示请求结束后立即归档，-1 表示不清理
+	ThreadRetention *int `json:"thread_retention,omitempty"`
+}
+
+// Key 一个客户端 API Key。文件中只保存密钥的 SHA-256 摘要。
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Hint      string     `json:"hint"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Limits
+	Usage Usage `json:"usage"`
+}
+
+// AllowsEndpoint 判断 Key 是否可以访问指定接口
+func (k *Key) AllowsEndpoint(endpoint string) bool {
+	if len(k.Endpoints) == 0 {
+		return true
+	}
+	for _, allowed := range k.Endpoints {
+		if allowed == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsModel 判断 Key 是否可以使用指定模型
+func (k *Key) AllowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, pattern := range k.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Store 保存在 JSON 文件中的 API Key 存储
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	keys  []*Key
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open 打开 Key 存储文件，文件不存在时从空存储开始
+func Open(file string) (*Store, error) {
+	s := &Store{
+		path: file,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if err := jsonstore.Load(file, &s.keys); err != nil {
+		return nil, fmt.Errorf("加载 API Key 文件失败: %v", err)
+	}
+
+	go s.flushLoop()
+	return s, nil
+}
+
+// Close 停止后台写回并保存未写入的用量
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// Len 返回未吊销的 Key 数量
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, k := range s.keys {
+		if k.RevokedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Authenticate 校验客户端提供的密钥，返回对应 Key 的副本。
+// 逐个比较所有 Key 的摘要，比较耗时与匹配位置无关。
+func (s *Store) Authenticate(secret string) (*Key, error) {
+	sum := sha256.Sum256([]byte(secret))
+	hash := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched *Key
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+			matched = k
+		}
+	}
+	if matched == nil || matched.RevokedAt != nil {
+		return nil, ErrInvalidKey
+	}
+	if matched.ExpiresAt != nil && time.Now().After(*matched.ExpiresAt) {
+		return nil, ErrKeyExpired
+	}
+	copied := *matched
+	return &copied, nil
+}
+
+// Admit 检查配额并计入一次请求，配额用尽时返回 *QuotaError
+func (s *Store) Admit(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := s.findLocked(id)
+	if k == nil {
+		return ErrInvalidKey
+	}
+	k.rollover(time.Now())
+
+	switch {
+	case k.DailyRequests > 0 && k.Usage.DayRequests >= k.DailyRequests:
+		return &QuotaError{Message: fmt.Sprintf("API Key 今日请求数已达上限 (%d)", k.DailyRequests)}
+	case k.MonthlyRequests > 0 && k.Usage.MonthRequests >= k.MonthlyRequests:
+		return &QuotaError{Message: fmt.Sprintf("API Key 本月请求数已达上限 (%d)", k.MonthlyRequests)}
+	case k.DailyTokens > 0 && k.Usage.DayTokens >= k.DailyTokens:
+		return &QuotaError{Message: fmt.Sprintf("API Key 今日 token 用量已达上限 (%d)", k.DailyTokens)}
+	case k.MonthlyTokens > 0 && k.Usage.MonthTokens >= k.MonthlyTokens:
+		return &QuotaError{Message: fmt.Sprintf("API Key 本月 token 用量已达上限 (%d)", k.MonthlyTokens)}
+	}
+
+	k.Usage.DayRequests++
+	k.Usage.MonthRequests++
+	s.dirty = true
+	return nil
+}
+
+// RecordTokens 在请求结束后计入 token 用量
+func (s *Store) RecordTokens(id string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := s.findLocked(id)
+	if k == nil {
+		return
+	}
+	k.rollover(time.Now())
+	k.Usage.DayTokens += tokens
+	k.Usage.MonthTokens += tokens
+	s.dirty = true
+}
+
+// Create 创建新的 Key，返回 Key 和只会出现这一次的明文密钥
+func (s *Store) Create(name string, limits Limits) (*Key, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &Key{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		Limits:    limits,
+	}
+	k.setSecret(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, k)
+	if err := s.saveLocked(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return nil, "", err
+	}
+
+	copied := *k
+	return &copied, secret, nil
+}
+
+// List 返回所有 Key（包括已吊销的），按创建时间排序
+func (s *Store) List() []Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		k.rollover(now)
+		keys = append(keys, *k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Rotate 为 Key 生成新的密钥，旧密钥立即失效
+func (s *Store) Rotate(id string) (*Key, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := s.findLocked(id)
+	if k == nil || k.RevokedAt != nil {
+		return nil, "", ErrKeyNotFound
+	}
+	oldHash, oldHint := k.Hash, k.Hint
+	k.setSecret(secret)
+	if err := s.saveLocked(); err != nil {
+		k.Hash, k.Hint = oldHash, oldHint
+		return nil, "", err
+	}
+
+	copied := *k
+	return &copied, secret, nil
+}
+
+// Revoke 吊销 Key，记录保留在文件中
+func (s *Store) Revoke(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := s.findLocked(id)
+	if k == nil {
+		return nil, ErrKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now().UTC()
+		k.RevokedAt = &now
+		if err := s.saveLocked(); err != nil {
+			k.RevokedAt = nil
+			return nil, err
+		}
+	}
+
+	copied := *k
+	return &copied, nil
+}
+
+// flushLoop 定期把用量写回文件
+func (s *Store) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.saveLocked(); err != nil {
+					log.Errorf("保存 API Key 用量失败: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// saveLocked 写回文件，调用方需持有锁
+func (s *Store) saveLocked() error {
+	if err := jsonstore.Save(s.path, s.keys); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// findLocked 按 ID 查找 Key，调用方需持有锁
+func (s *Store) findLocked(id string) *Key {
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// rollover 进入新的一天或新的一月时清零对应用量
+func (k *Key) rollover(now time.Time) {
+	now = now.UTC()
+	if day := now.Format("2006-01-02"); k.Usage.Day != day {
+		k.Usage.Day = day
+		k.Usage.DayRequests = 0
+		k.Usage.DayTokens = 0
+	}
+	if month := now.Format("2006-01"); k.Usage.Month != month {
+		k.Usage.Month = month
+		k.Usage.MonthRequests = 0
+		k.Usage.MonthTokens = 0
+	}
+}
+
+// setSecret 保存密钥摘要和用于辨认的末尾字符
+func (k *Key) setSecret(secret string) {
+	sum := sha256.Sum256([]byte(secret))
+	k.Hash = hex.EncodeToString(sum[:])
+	k.Hint = keyPrefix + "..." + secret[len(secret)-4:]
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成 API Key 失败: %v", err)
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成 API Key ID 失败: %v", err)
+	}
+	return "key_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, file string) *Store {
+	t.Helper()
+	s, err := Open(file)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	s := openTestStore(t, file)
+
+	key, secret, err := s.Create("ci", Limits{})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) {
+		t.Errorf("secret %q has no %s prefix", secret, keyPrefix)
+	}
+	if key.Hint != keyPrefix+"..."+secret[len(secret)-4:] {
+		t.Errorf("Hint = %q does not end with the secret's last four characters", key.Hint)
+	}
+
+	// 文件中只有摘要，没有明文密钥
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Error("key file contains the plaintext secret")
+	}
+	if !strings.Contains(string(data), key.Hash) {
+		t.Error("key file does not contain the secret hash")
+	}
+
+	if got, err := s.Authenticate(secret); err != nil || got.ID != key.ID {
+		t.Fatalf("Authenticate() = %v, %v, want key %s", got, err, key.ID)
+	}
+	if _, err := s.Authenticate(secret + "x"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Authenticate() with a wrong secret: error = %v, want ErrInvalidKey", err)
+	}
+
+	// 轮换后旧密钥立即失效
+	_, rotated, err := s.Rotate(key.ID)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if _, err := s.Authenticate(secret); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Authenticate() with the rotated-out secret: error = %v, want ErrInvalidKey", err)
+	}
+
+	// 重新打开文件后新密钥仍然有效
+	reopened := openTestStore(t, file)
+	if _, err := reopened.Authenticate(rotated); err != nil {
+		t.Fatalf("Authenticate() after reopening: error = %v", err)
+	}
+
+	if _, err := s.Revoke(key.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := s.Authenticate(rotated); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Authenticate() after Revoke(): error = %v, want ErrInvalidKey", err)
+	}
+	if _, _, err := s.Rotate(key.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Rotate() of a revoked key: error = %v, want ErrKeyNotFound", err)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len() = %d after revoking the only key, want 0", n)
+	}
+}
+
+func TestExpiredKey(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "keys.json"))
+	past := time.Now().Add(-time.Minute)
+	_, secret, err := s.Create("old", Limits{ExpiresAt: &past})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(secret); !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("Authenticate() = %v, want ErrKeyExpired", err)
+	}
+}
+
+func TestAdmitRequestQuota(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "keys.json"))
+	key, _, err := s.Create("limited", Limits{DailyRequests: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Admit(key.ID); err != nil {
+			t.Fatalf("Admit() #%d error = %v", i+1, err)
+		}
+	}
+	var quotaErr *QuotaError
+	if err := s.Admit(key.ID); !errors.As(err, &quotaErr) {
+		t.Fatalf("Admit() over the daily limit: error = %v, want *QuotaError", err)
+	}
+
+	usage := s.List()[0].Usage
+	if usage.DayRequests != 2 || usage.MonthRequests != 2 {
+		t.Errorf("usage = %+v, want 2 requests counted (rejected request excluded)", usage)
+	}
+	if err := s.Admit("key_missing"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Admit() of an unknown key: error = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestAdmitTokenQuota(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "keys.json"))
+	key, _, err := s.Create("tokens", Limits{MonthlyTokens: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Admit(key.ID); err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	// 配额在请求结束后计入，超出部分不回退，下一个请求被拒绝
+	s.RecordTokens(key.ID, 1200)
+	var quotaErr *QuotaError
+	if err := s.Admit(key.ID); !errors.As(err, &quotaErr) {
+		t.Fatalf("Admit() after exceeding the token quota: error = %v, want *QuotaError", err)
+	}
+
+	s.RecordTokens(key.ID, -5)
+	if got := s.List()[0].Usage.MonthTokens; got != 1200 {
+		t.Errorf("MonthTokens = %d, want 1200", got)
+	}
+}
+
+func TestUsageRollover(t *testing.T) {
+	k := &Key{Usage: Usage{
+		Day: "2026-01-31", DayRequests: 5, DayTokens: 50,
+		Month: "2026-01", MonthRequests: 9, MonthTokens: 90,
+	}}
+
+	k.rollover(time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC))
+	if k.Usage.DayRequests != 5 || k.Usage.MonthRequests != 9 {
+		t.Fatalf("usage reset within the same day: %+v", k.Usage)
+	}
+
+	k.rollover(time.Date(2026, 2, 1, 0, 30, 0, 0, time.UTC))
+	want := Usage{Day: "2026-02-01", Month: "2026-02"}
+	if k.Usage != want {
+		t.Errorf("usage after month change = %+v, want %+v", k.Usage, want)
+	}
+}
+
+func TestAllowsModel(t *testing.T) {
+	k := &Key{Limits: Limits{Models: []string{"claude-*", "gpt-4o"}}}
+	for model, want := range map[string]bool{
+		"claude-sonnet-4": true,
+		"gpt-4o":          true,
+		"gpt-4o-mini":     false,
+		"gemini-2.5-pro":  false,
+	} {
+		if got := k.AllowsModel(model); got != want {
+			t.Errorf("AllowsModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+	if !(&Key{}).AllowsModel("anything") {
+		t.Error("key without model restrictions rejects a model")
+	}
+}
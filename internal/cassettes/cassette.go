@@ -0,0 +1,201 @@
+package cassettes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// version 录制文件格式的版本
+const version = 1
+
+// inferencePath 录制和回放的 Notion 接口
+const inferencePath = "/api/v3/runInferenceTranscript"
+
+// isInference 请求是否为推理请求，NOTION_BASE_URL 带路径前缀时按后缀匹配
+func isInference(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, inferencePath)
+}
+
+// Cassette 一次 Notion 推理的录制：请求载荷和原始 NDJSON 响应
+type Cassette struct {
+	Version    int       `json:"version"`
+	RecordedAt time.Time `json:"recorded_at"`
+	// Model 请求的模型代号，Message 最后一条用户消息，回放时按它们查找录制文件
+	Model   string `json:"model"`
+	Message string `json:"message"`
+	// Status Notion 返回的 HTTP 状态码
+	Status int `json:"status"`
+	// Truncated 响应没有读完（例如客户端中途断开或连接中断），回放时在末尾返回 unexpected EOF
+	Truncated bool            `json:"truncated,omitempty"`
+	Request   json.RawMessage `json:"request"`
+	// Response 原始响应，每行一个元素
+	Response []string `json:"response"`
+
+	file string
+}
+
+// Load 读取录制文件
+func Load(file string) (*Cassette, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取录制文件 %s 失败: %v", file, err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("解析录制文件 %s 失败: %v", file, err)
+	}
+	if c.Version != version {
+		return nil, fmt.Errorf("录制文件 %s 的版本 %d 不受支持", file, c.Version)
+	}
+	if c.Status == 0 {
+		c.Status = 200
+	}
+	c.file = file
+	return &c, nil
+}
+
+// Save 将录制写入 dir 下的新文件，返回文件路径。写入临时文件后重命名，避免回放时读到不完整的文件
+func (c *Cassette) Save(dir string) (string, error) {
+	c.Version = version
+	// 不转义 < > &，录制文件中的思考标签等内容保持可读
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return "", err
+	}
+	data := buf.Bytes()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("创建录制目录失败: %v", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", c.RecordedAt.Format("20060102-150405"), fileSafe(c.Model), uuid.New().String()[:8])
+	file := filepath.Join(dir, name)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", fmt.Errorf("写入录制文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return "", fmt.Errorf("写入录制文件失败: %v", err)
+	}
+	c.file = file
+	return file, nil
+}
+
+// body 还原原始响应
+func (c *Cassette) body() string {
+	if len(c.Response) == 0 {
+		return ""
+	}
+	return strings.Join(c.Response, "\n") + "\n"
+}
+
+// describe 从推理请求载荷中取出模型代号和最后一条用户消息
+func describe(payload []byte) (model, message string) {
+	var request struct {
+		Transcript []struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"transcript"`
+	}
+	if json.Unmarshal(payload, &request) != nil {
+		return "", ""
+	}
+
+	for _, step := range request.Transcript {
+		switch step.Type {
+		case "config":
+			var cfg struct {
+				Model string `json:"model"`
+			}
+			json.Unmarshal(step.Value, &cfg)
+			model = cfg.Model
+		case "user":
+			// 用户消息的 value 为 [[文本], [附件...]]
+			var segments [][]interface{}
+			json.Unmarshal(step.Value, &segments)
+			var text strings.Builder
+			for _, segment := range segments {
+				if len(segment) > 0 {
+					if s, ok := segment[0].(string); ok {
+						text.WriteString(s)
+					}
+				}
+			}
+			message = text.String()
+		}
+	}
+	return model, message
+}
+
+// Scrubber 把录制内容中的账号凭据和用户 ID 替换为占位符。
+// 请求头（包括 Cookie）不会被录制；载荷和响应中的账号 ID、邮箱等按值替换，
+// 其他用户 ID 字段（如 record-map 中的 created_by_id）按字段名替换。
+// 少于 minSecretLength 个字节的值不替换，避免较短的用户名误伤正常内容。
+type Scrubber struct {
+	replacer *strings.Replacer
+}
+
+// minSecretLength 按值替换的最短长度，与日志脱敏一致
+const minSecretLength = 8
+
+// idFields 值为用户或空间 ID 的字段
+var idFields = regexp.MustCompile(`"(userId|user_id|created_by_id|last_edited_by_id|spaceId|space_id)"(\s*:\s*)"[^"]*"`)
+
+// NewScrubber secrets 为需要隐藏的字符串及其占位符
+func NewScrubber(secrets map[string]string) *Scrubber {
+	values := make([]string, 0, len(secrets))
+	for value := range secrets {
+		if len(value) >= minSecretLength {
+			values = append(values, value)
+		}
+	}
+	// 较长的值优先替换，避免其中包含的较短的值先被替换
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, secrets[value])
+	}
+	return &Scrubber{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Scrub 返回替换后的内容
+func (s *Scrubber) Scrub(text string) string {
+	if s == nil {
+		return text
+	}
+	text = s.replacer.Replace(text)
+	return idFields.ReplaceAllStringFunc(text, func(field string) string {
+		m := idFields.FindStringSubmatch(field)
+		placeholder := "<user-id>"
+		if strings.HasPrefix(m[1], "space") {
+			placeholder = "<space-id>"
+		}
+		return `"` + m[1] + `"` + m[2] + `"` + placeholder + `"`
+	})
+}
+
+// fileSafe 将模型代号转换为可以用作文件名的形式
+func fileSafe(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,146 @@
+package cassettes
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrubber(t *testing.T) {
+	scrub := NewScrubber(map[string]string{
+		"token-v2-secret-value": "<cookie>",
+		"alice@example.com":     "<user-email>",
+		"Alice Example":         "<user-name>",
+		"Bob":                   "<user-name>",
+		"":                      "<user-id>",
+	})
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"Cookie", `cookie=token-v2-secret-value;`, `cookie=<cookie>;`},
+		{"邮箱和名称", "Alice Example <alice@example.com>", "<user-name> <<user-email>>"},
+		{"过短的值不替换", "Bob likes Bobsleigh", "Bob likes Bobsleigh"},
+		{"用户 ID 字段", `{"created_by_id": "1234", "userId":"abcd"}`, `{"created_by_id": "<user-id>", "userId":"<user-id>"}`},
+		{"空间 ID 字段", `{"spaceId":"s-1","space_id": "s-2"}`, `{"spaceId":"<space-id>","space_id": "<space-id>"}`},
+		{"没有敏感内容", "普通文本", "普通文本"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scrub.Scrub(tt.in); got != tt.want {
+				t.Errorf("Scrub(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilScrubber(t *testing.T) {
+	var scrub *Scrubber
+	if got := scrub.Scrub("text"); got != "text" {
+		t.Errorf("nil Scrub() = %q, want %q", got, "text")
+	}
+}
+
+// inferenceRequest 构造只有一条用户消息的推理请求
+func inferenceRequest(base, model, message string) *http.Request {
+	payload := `{"transcript":[{"type":"config","value":{"model":"` + model + `"}},{"type":"user","value":[["` + message + `"]]}]}`
+	return httptest.NewRequest(http.MethodPost, base+inferencePath, strings.NewReader(payload))
+}
+
+func TestPlayer(t *testing.T) {
+	dir := t.TempDir()
+	scrub := NewScrubber(map[string]string{"alice@example.com": "<user-email>"})
+	recorded := []*Cassette{
+		{RecordedAt: time.Unix(1, 0), Model: "m1", Message: "写给 <user-email> 的邮件", Status: 200, Response: []string{`{"n":1}`}},
+		{RecordedAt: time.Unix(2, 0), Model: "m1", Message: "你好", Status: 500, Response: []string{`{"n":2}`}},
+		{RecordedAt: time.Unix(3, 0), Model: "m1", Message: "你好", Status: 200, Response: []string{`{"n":3}`}},
+	}
+	for _, c := range recorded {
+		if _, err := c.Save(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	player, err := NewPlayer(dir, scrub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		status  int
+		body    string
+		wantErr error
+	}{
+		{"脱敏后匹配", inferenceRequest("http://notion.test", "m1", "写给 alice@example.com 的邮件"), 200, `{"n":1}` + "\n", nil},
+		{"按录制时间依次回放", inferenceRequest("http://notion.test", "m1", "你好"), 500, `{"n":2}` + "\n", nil},
+		{"第二次使用下一个录制", inferenceRequest("http://notion.test", "m1", "你好"), 200, `{"n":3}` + "\n", nil},
+		{"其他模型按消息匹配", inferenceRequest("http://notion.test", "m2", "你好"), 500, `{"n":2}` + "\n", nil},
+		{"带路径前缀", inferenceRequest("http://notion.test/proxy", "m1", "写给 alice@example.com 的邮件"), 200, `{"n":1}` + "\n", nil},
+		{"没有匹配的消息", inferenceRequest("http://notion.test", "m1", "未录制"), http.StatusNotFound, "", nil},
+		{"非推理请求", httptest.NewRequest(http.MethodPost, "http://notion.test/api/v3/loadUserContent", strings.NewReader("{}")), 0, "", ErrNoCassette},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := player.RoundTrip(tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RoundTrip() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RoundTrip() error = %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if tt.body != "" && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestRecorderWithPathPrefix(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"markdown-chat","value":"alice@example.com 你好"}` + "\n"))
+	})
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder := NewRecorder(http.DefaultTransport, dir, NewScrubber(map[string]string{"alice@example.com": "<user-email>"}))
+	client := &http.Client{Transport: recorder}
+
+	req := inferenceRequest(server.URL+"/notion", "m1", "问候 alice@example.com")
+	req.RequestURI = ""
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	player, err := NewPlayer(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.Len() != 1 {
+		t.Fatalf("recorded %d cassettes, want 1", player.Len())
+	}
+	c := player.cassettes[0]
+	if c.Message != "问候 <user-email>" || c.Model != "m1" {
+		t.Errorf("cassette model %q message %q", c.Model, c.Message)
+	}
+	if len(c.Response) != 1 || strings.Contains(c.Response[0], "alice@example.com") {
+		t.Errorf("response not scrubbed: %v", c.Response)
+	}
+}
@@ -0,0 +1,146 @@
+package cassettes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"notion-2api-go/internal/logging"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Player 从录制文件回放 Notion 推理响应的 http.RoundTripper，不访问网络。
+// 推理请求按模型和最后一条用户消息查找录制文件，没有同一模型的录制时只按消息查找；
+// 同一请求有多个录制文件时按录制时间依次使用（例如先失败后重试成功），用完后从头开始。
+// 录制的消息已经过脱敏，查找前请求中的消息按同样的规则脱敏。
+// 其他请求（会话预热、线程归档、模型发现等）没有录制，返回 ErrNoCassette。
+type Player struct {
+	cassettes []*Cassette
+	scrub     *Scrubber
+
+	mu   sync.Mutex
+	next map[string]int
+}
+
+// ErrNoCassette 回放模式下请求没有对应的录制文件
+var ErrNoCassette = errors.New("回放模式下没有匹配的录制文件")
+
+// NewPlayer 加载 dir 下的所有录制文件，scrub 为录制时使用的脱敏规则
+func NewPlayer(dir string, scrub *Scrubber) (*Player, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取录制目录 %s 失败: %v", dir, err)
+	}
+
+	p := &Player{scrub: scrub, next: make(map[string]int)}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		c, err := Load(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p.cassettes = append(p.cassettes, c)
+	}
+	sort.SliceStable(p.cassettes, func(i, j int) bool { return p.cassettes[i].RecordedAt.Before(p.cassettes[j].RecordedAt) })
+	return p, nil
+}
+
+// Len 已加载的录制文件数
+func (p *Player) Len() int {
+	return len(p.cassettes)
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isInference(req) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %s %s", ErrNoCassette, req.Method, req.URL.Path)
+	}
+
+	var payload []byte
+	if req.Body != nil {
+		var err error
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	model, message := describe(payload)
+	message = p.scrub.Scrub(message)
+	logger := logging.FromContext(req.Context())
+
+	c := p.find(model, message)
+	if c == nil {
+		logger.Warnf("没有与请求匹配的录制文件: 模型 %s, 消息 %s", model, logging.Content(message))
+		body, _ := json.Marshal(map[string]string{
+			"name":    "CassetteNotFound",
+			"message": fmt.Sprintf("没有与请求匹配的录制文件 (模型 %s)", model),
+		})
+		return response(req, http.StatusNotFound, "application/json", bytes.NewReader(body)), nil
+	}
+	logger.Infof("回放录制文件 %s", c.file)
+
+	var body io.Reader = strings.NewReader(c.body())
+	if c.Truncated {
+		body = io.MultiReader(body, truncatedReader{})
+	}
+	return response(req, c.Status, "application/x-ndjson", body), nil
+}
+
+// find 按模型和消息查找下一个要回放的录制文件
+func (p *Player) find(model, message string) *Cassette {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, byModel := range []bool{true, false} {
+		key := "\x00" + message
+		if byModel {
+			key = model + key
+		}
+		var matched []*Cassette
+		for _, c := range p.cassettes {
+			if c.Message == message && (!byModel || c.Model == model) {
+				matched = append(matched, c)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		i := p.next[key] % len(matched)
+		p.next[key] = i + 1
+		return matched[i]
+	}
+	return nil
+}
+
+// response 构造回放的响应
+func response(req *http.Request, status int, contentType string, body io.Reader) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       io.NopCloser(body),
+		Request:    req,
+	}
+}
+
+// truncatedReader 模拟录制时中断的响应
+type truncatedReader struct{}
+
+func (truncatedReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,102 @@
+package cassettes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Recorder 录制 Notion 推理请求和原始响应的 http.RoundTripper，其他请求直接转发。
+// 响应在被读完或关闭时写入 dir 下的录制文件，连接错误没有响应，不会被录制。
+type Recorder struct {
+	next  http.RoundTripper
+	dir   string
+	scrub *Scrubber
+}
+
+// NewRecorder 创建录制器，next 为实际发送请求的 RoundTripper
+func NewRecorder(next http.RoundTripper, dir string, scrub *Scrubber) *Recorder {
+	return &Recorder{next: next, dir: dir, scrub: scrub}
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isInference(req) || req.Body == nil {
+		return r.next.RoundTrip(req)
+	}
+
+	payload, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// RoundTripper 不能修改原请求，换上新的请求体后转发副本
+	forward := req.Clone(req.Context())
+	forward.Body = io.NopCloser(bytes.NewReader(payload))
+
+	resp, err := r.next.RoundTrip(forward)
+	if err != nil {
+		return nil, err
+	}
+
+	model, message := describe(payload)
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		recorder:   r,
+		cassette: Cassette{
+			RecordedAt: time.Now(),
+			Model:      model,
+			Message:    r.scrub.Scrub(message),
+			Status:     resp.StatusCode,
+			Request:    []byte(r.scrub.Scrub(string(payload))),
+		},
+	}
+	return resp, nil
+}
+
+// recordingBody 在读取响应的同时保存原始内容，关闭时写入录制文件
+type recordingBody struct {
+	io.ReadCloser
+	recorder *Recorder
+	cassette Cassette
+	raw      bytes.Buffer
+	complete bool
+	once     sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.raw.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.save)
+	return err
+}
+
+func (b *recordingBody) save() {
+	c := &b.cassette
+	c.Truncated = !b.complete
+	raw := strings.TrimSuffix(b.recorder.scrub.Scrub(b.raw.String()), "\n")
+	if raw != "" {
+		c.Response = strings.Split(raw, "\n")
+	}
+
+	file, err := c.Save(b.recorder.dir)
+	if err != nil {
+		log.Errorf("保存 Notion 推理录制失败: %v", err)
+		return
+	}
+	log.Infof("已录制 Notion 推理响应: %s (状态码 %d, %d 行)", file, c.Status, len(c.Response))
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -11,22 +14,81 @@ import (
 
 // Settings 应用配置结构
 type Settings struct {
-	AppName          string
-	AppVersion       string
-	Description      string
-	APIMasterKey     string
-	NotionCookie     string
-	NotionSpaceID    string
-	NotionUserID     string
-	NotionUserName   string
-	NotionUserEmail  string
-	NotionBlockID    string
+	AppName             string
+	AppVersion          string
+	Description         string
+	APIMasterKey        string
+	APIKeysFile         string
+	NotionCookie        string
+	NotionSpaceID       string
+	NotionUserID        string
+	NotionUserName      string
+	NotionUserEmail     string
+	NotionBlockID       string
 	NotionClientVersion string
+	// NotionBaseURL Notion 的地址，所有接口都在其下的 /api/v3，可指向本地的 mock-notion
+	NotionBaseURL     string
 	APIRequestTimeout int
-	NginxPort        int
-	DefaultModel     string
-	KnownModels      []string
-	ModelMap         map[string]string
+	NginxPort         int
+	DefaultModel      string
+	ExposeThinking    bool
+	SystemPromptMode  string
+	Accounts          []NotionAccount
+	AccountStrategy   string
+	AccountCooldown   int
+	// 限流配置，0 表示不限制
+	RateLimitGlobalRPM         int
+	RateLimitGlobalConcurrency int
+	RateLimitKeyRPM            int
+	RateLimitKeyConcurrency    int
+	RateLimitIPRPM             int
+	RateLimitIPConcurrency     int
+	// ShutdownTimeout 停机时等待进行中请求完成的最长时间（秒）
+	ShutdownTimeout int
+	// 日志配置，LogDebugCapture 开启后日志中保留提示词、响应内容和 Cookie
+	LogFormat       string
+	LogLevel        string
+	LogDebugCapture bool
+	// MetricsEnabled 是否开放 /metrics，MetricsToken 非空时需要以 Bearer 方式携带
+	MetricsEnabled bool
+	MetricsToken   string
+	// ModelsFile 模型配置文件，为空时使用内置模型表；ModelsReloadInterval 为检查文件变化的间隔（秒），0 表示只在 SIGHUP 时重新加载
+	ModelsFile           string
+	ModelsReloadInterval int
+	// UnknownModelPolicy 请求的模型不在模型表中且没有匹配的别名规则时的处理方式：fallback 使用默认模型 / reject 返回 404
+	UnknownModelPolicy string
+	// ModelDiscoveryInterval 向 Notion 查询工作区可用模型的间隔（秒），0 表示不查询
+	ModelDiscoveryInterval int
+	// 多轮对话复用 Notion 线程：ConversationMode 为 off / header / user / auto，
+	// ConversationTTL 为对话多久没有新消息后过期（秒），ConversationsFile 为对话与线程对应关系的存储文件
+	ConversationMode  string
+	ConversationTTL   int
+	ConversationsFile string
+	// ThreadRetention 代理创建的 Notion 线程保留多久后归档（秒），0 表示请求结束后立即归档，-1 表示不清理；
+	// ThreadsFile 为待归档线程的记录文件
+	ThreadRetention int
+	ThreadsFile     string
+	// 发起推理失败时的重试：最多重试 UpstreamMaxRetries 次，间隔从 UpstreamRetryBaseDelay 开始指数增长（带随机抖动），
+	// 不超过 UpstreamRetryMaxDelay（毫秒）
+	UpstreamMaxRetries     int
+	UpstreamRetryBaseDelay int
+	UpstreamRetryMaxDelay  int
+	// 账号连续失败 CircuitBreakerThreshold 次后熔断 CircuitBreakerCooldown 秒，0 表示不熔断
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  int
+	// 录制与回放 Notion 的推理流量：CassetteMode 为 off / record / replay，CassetteDir 为录制文件所在目录
+	CassetteMode string
+	CassetteDir  string
+}
+
+// NotionAccount 一个 Notion 账号的凭据
+type NotionAccount struct {
+	Name      string `json:"name"`
+	Cookie    string `json:"cookie"`
+	SpaceID   string `json:"space_id"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
 }
 
 var Config *Settings
@@ -39,68 +101,194 @@ func LoadConfig() *Settings {
 	}
 
 	config := &Settings{
-		AppName:    getEnv("APP_NAME", "notion-2api-go"),
-		AppVersion: getEnv("APP_VERSION", "1.0.0"),
+		AppName:     getEnv("APP_NAME", "notion-2api-go"),
+		AppVersion:  getEnv("APP_VERSION", "1.0.0"),
 		Description: getEnv("DESCRIPTION", "一个将 Notion AI 转换为兼容 OpenAI 格式 API 的高性能代理 (Go 版本)。"),
 
-		APIMasterKey:    getEnv("API_MASTER_KEY", ""),
-		NotionCookie:    getEnv("NOTION_COOKIE", ""),
-		NotionSpaceID:   getEnv("NOTION_SPACE_ID", ""),
-		NotionUserID:    getEnv("NOTION_USER_ID", ""),
-		NotionUserName:  getEnv("NOTION_USER_NAME", ""),
-		NotionUserEmail: getEnv("NOTION_USER_EMAIL", ""),
-		NotionBlockID:   getEnv("NOTION_BLOCK_ID", ""),
+		APIMasterKey:        getEnv("API_MASTER_KEY", ""),
+		APIKeysFile:         getEnv("API_KEYS_FILE", "data/api_keys.json"),
+		NotionCookie:        getEnv("NOTION_COOKIE", ""),
+		NotionSpaceID:       getEnv("NOTION_SPACE_ID", ""),
+		NotionUserID:        getEnv("NOTION_USER_ID", ""),
+		NotionUserName:      getEnv("NOTION_USER_NAME", ""),
+		NotionUserEmail:     getEnv("NOTION_USER_EMAIL", ""),
+		NotionBlockID:       getEnv("NOTION_BLOCK_ID", ""),
 		NotionClientVersion: getEnv("NOTION_CLIENT_VERSION", "23.13.20251224"),
+		NotionBaseURL:       strings.TrimRight(getEnv("NOTION_BASE_URL", "https://www.notion.so"), "/"),
 
 		APIRequestTimeout: getEnvAsInt("API_REQUEST_TIMEOUT", 180),
-		NginxPort:        getEnvAsInt("NGINX_PORT", 8004),
-		DefaultModel:     getEnv("DEFAULT_MODEL", "claude-sonnet-4.5"),
-
-		// Notion AI 最新模型列表 (2024年12月)
-		KnownModels: []string{
-			"claude-sonnet-4.5",
-			"claude-opus-4.5",
-			"gemini-3-pro",
-			"gpt-5.2",
-			"gpt-4o",
-			"gpt-4o-mini",
-			"o1",
-			"o1-mini",
-			"gemini-2.0-flash",
-			"gemini-1.5-pro",
-		},
-
-		// 模型映射到 Notion 内部代号
-		ModelMap: map[string]string{
-			// 新测试版模型
-			"claude-sonnet-4.5":         "anthropic-sonnet-alt-thinking",
-			"claude-opus-4.5":           "apple-danish",
-			"gemini-3-pro":              "gateau-roule",
-			"gpt-5.2":                   "oatmeal-cookie",
-			// 标准模型
-			"gpt-4o":                    "openai-gpt-4o",
-			"gpt-4o-mini":               "openai-gpt-4o-mini",
-			"o1":                        "openai-o1",
-			"o1-mini":                   "openai-o1-mini",
-			"gemini-2.0-flash":          "vertex-gemini-2.0-flash",
-			"gemini-1.5-pro":            "vertex-gemini-1.5-pro",
-			// Claude CLI 兼容别名
-			"claude-opus-4-5-20251101":   "apple-danish",
-			"claude-sonnet-4-5-20241022": "anthropic-sonnet-alt-thinking",
-			"opus":                       "apple-danish",
-			"sonnet":                     "anthropic-sonnet-alt-thinking",
-		},
-	}
-
-	// 验证必需的配置
-	if config.NotionCookie == "" || config.NotionSpaceID == "" || config.NotionUserID == "" {
-		log.Fatal("配置错误: NOTION_COOKIE, NOTION_SPACE_ID 和 NOTION_USER_ID 必须在 .env 文件中全部设置。")
+		NginxPort:         getEnvAsInt("NGINX_PORT", 8004),
+		DefaultModel:      getEnv("DEFAULT_MODEL", "claude-sonnet-4.5"),
+		ExposeThinking:    getEnvAsBool("EXPOSE_THINKING", false),
+		SystemPromptMode:  strings.ToLower(getEnv("SYSTEM_PROMPT_MODE", "user")),
+		AccountStrategy:   strings.ToLower(getEnv("ACCOUNT_STRATEGY", "round-robin")),
+		AccountCooldown:   getEnvAsInt("ACCOUNT_COOLDOWN", 3600),
+
+		RateLimitGlobalRPM:         getEnvAsInt("RATE_LIMIT_GLOBAL_RPM", 0),
+		RateLimitGlobalConcurrency: getEnvAsInt("RATE_LIMIT_GLOBAL_CONCURRENCY", 0),
+		RateLimitKeyRPM:            getEnvAsInt("RATE_LIMIT_KEY_RPM", 0),
+		RateLimitKeyConcurrency:    getEnvAsInt("RATE_LIMIT_KEY_CONCURRENCY", 0),
+		RateLimitIPRPM:             getEnvAsInt("RATE_LIMIT_IP_RPM", 0),
+		RateLimitIPConcurrency:     getEnvAsInt("RATE_LIMIT_IP_CONCURRENCY", 0),
+
+		ShutdownTimeout: getEnvAsInt("SHUTDOWN_TIMEOUT", 30),
+
+		LogFormat:       strings.ToLower(getEnv("LOG_FORMAT", "text")),
+		LogLevel:        strings.ToLower(getEnv("LOG_LEVEL", "info")),
+		LogDebugCapture: getEnvAsBool("LOG_DEBUG_CAPTURE", false),
+
+		MetricsEnabled: getEnvAsBool("METRICS_ENABLED", true),
+		MetricsToken:   getEnv("METRICS_TOKEN", ""),
+
+		ModelsFile:           getEnv("MODELS_FILE", ""),
+		ModelsReloadInterval: getEnvAsInt("MODELS_RELOAD_INTERVAL", 5),
+		UnknownModelPolicy:   strings.ToLower(getEnv("UNKNOWN_MODEL_POLICY", "fallback")),
+
+		ModelDiscoveryInterval: getEnvAsInt("MODEL_DISCOVERY_INTERVAL", 600),
+
+		ConversationMode:  strings.ToLower(getEnv("CONVERSATION_MODE", "off")),
+		ConversationTTL:   getEnvAsInt("CONVERSATION_TTL", 86400),
+		ConversationsFile: getEnv("CONVERSATIONS_FILE", "data/conversations.json"),
+
+		ThreadRetention: getEnvAsInt("THREAD_RETENTION", -1),
+		ThreadsFile:     getEnv("THREADS_FILE", "data/threads.json"),
+
+		UpstreamMaxRetries:     getEnvAsInt("UPSTREAM_MAX_RETRIES", 2),
+		UpstreamRetryBaseDelay: getEnvAsInt("UPSTREAM_RETRY_BASE_DELAY", 500),
+		UpstreamRetryMaxDelay:  getEnvAsInt("UPSTREAM_RETRY_MAX_DELAY", 8000),
+
+		CircuitBreakerThreshold: getEnvAsInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldown:  getEnvAsInt("CIRCUIT_BREAKER_COOLDOWN", 30),
+
+		CassetteMode: strings.ToLower(getEnv("CASSETTE_MODE", "off")),
+		CassetteDir:  getEnv("CASSETTE_DIR", "data/cassettes"),
+	}
+
+	// 加载模型表
+	catalog, err := NewModelCatalog(defaultModels, defaultAliasRules, "")
+	if config.ModelsFile != "" {
+		catalog, err = LoadModelsFile(config.ModelsFile)
+	}
+	if err == nil {
+		err = checkDefaultModel(catalog, config.DefaultModel)
+	}
+	if err != nil {
+		log.Fatalf("配置错误: %v", err)
+	}
+	SetModels(catalog)
+
+	// 加载账号池并验证必需的配置
+	accounts, err := loadAccounts(config)
+	if err != nil {
+		log.Fatalf("配置错误: %v", err)
+	}
+	if len(accounts) == 0 {
+		log.Fatal("配置错误: NOTION_COOKIE, NOTION_SPACE_ID 和 NOTION_USER_ID 必须在 .env 文件中全部设置，或通过 NOTION_ACCOUNTS_FILE / NOTION_COOKIE_1 等配置多个账号。")
+	}
+	config.Accounts = accounts
+
+	if u, err := url.Parse(config.NotionBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalf("配置错误: NOTION_BASE_URL 必须是 http(s) 地址: %s", config.NotionBaseURL)
+	}
+
+	switch config.AccountStrategy {
+	case "round-robin", "least-used", "sticky":
+	default:
+		log.Printf("未知的 ACCOUNT_STRATEGY: %s，将使用 round-robin", config.AccountStrategy)
+		config.AccountStrategy = "round-robin"
+	}
+
+	switch config.UnknownModelPolicy {
+	case "fallback", "reject":
+	default:
+		log.Printf("未知的 UNKNOWN_MODEL_POLICY: %s，将使用 fallback", config.UnknownModelPolicy)
+		config.UnknownModelPolicy = "fallback"
+	}
+
+	switch config.ConversationMode {
+	case "off", "header", "user", "auto":
+	default:
+		log.Printf("未知的 CONVERSATION_MODE: %s，将使用 off", config.ConversationMode)
+		config.ConversationMode = "off"
+	}
+
+	switch config.CassetteMode {
+	case "off", "record", "replay":
+	default:
+		log.Printf("未知的 CASSETTE_MODE: %s，将使用 off", config.CassetteMode)
+		config.CassetteMode = "off"
+	}
+
+	switch config.SystemPromptMode {
+	case "user", "merge", "drop":
+	default:
+		log.Printf("未知的 SYSTEM_PROMPT_MODE: %s，将使用 user", config.SystemPromptMode)
+		config.SystemPromptMode = "user"
 	}
 
 	Config = config
 	return config
 }
 
+// loadAccounts 加载 Notion 账号池。
+// 设置了 NOTION_ACCOUNTS_FILE 时从该 JSON 文件读取账号数组；
+// 否则使用 NOTION_COOKIE 等单账号配置，再追加 NOTION_COOKIE_1、NOTION_SPACE_ID_1、NOTION_USER_ID_1 ... 等带序号的账号。
+func loadAccounts(s *Settings) ([]NotionAccount, error) {
+	var accounts []NotionAccount
+
+	if file := getEnv("NOTION_ACCOUNTS_FILE", ""); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取账号文件 %s 失败: %v", file, err)
+		}
+		if err := json.Unmarshal(data, &accounts); err != nil {
+			return nil, fmt.Errorf("解析账号文件 %s 失败: %v", file, err)
+		}
+		for i := range accounts {
+			if accounts[i].Name == "" {
+				accounts[i].Name = fmt.Sprintf("account-%d", i+1)
+			}
+		}
+	} else {
+		if s.NotionCookie != "" {
+			accounts = append(accounts, NotionAccount{
+				Name:      "default",
+				Cookie:    s.NotionCookie,
+				SpaceID:   s.NotionSpaceID,
+				UserID:    s.NotionUserID,
+				UserName:  s.NotionUserName,
+				UserEmail: s.NotionUserEmail,
+			})
+		}
+		for i := 1; ; i++ {
+			cookie := getEnv(fmt.Sprintf("NOTION_COOKIE_%d", i), "")
+			if cookie == "" {
+				break
+			}
+			accounts = append(accounts, NotionAccount{
+				Name:      getEnv(fmt.Sprintf("NOTION_ACCOUNT_NAME_%d", i), fmt.Sprintf("account-%d", i)),
+				Cookie:    cookie,
+				SpaceID:   getEnv(fmt.Sprintf("NOTION_SPACE_ID_%d", i), ""),
+				UserID:    getEnv(fmt.Sprintf("NOTION_USER_ID_%d", i), ""),
+				UserName:  getEnv(fmt.Sprintf("NOTION_USER_NAME_%d", i), ""),
+				UserEmail: getEnv(fmt.Sprintf("NOTION_USER_EMAIL_%d", i), ""),
+			})
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, account := range accounts {
+		if account.Cookie == "" || account.SpaceID == "" || account.UserID == "" {
+			return nil, fmt.Errorf("账号 %s 缺少 cookie、space_id 或 user_id", account.Name)
+		}
+		if names[account.Name] {
+			return nil, fmt.Errorf("账号名称 %s 重复", account.Name)
+		}
+		names[account.Name] = true
+	}
+	return accounts, nil
+}
+
 // getEnv 获取环境变量，如果不存在则返回默认值
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -123,11 +311,34 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return value
 }
 
+// getEnvAsBool 获取环境变量作为布尔值，如果不存在或解析失败则返回默认值
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // GetCookieHeader 获取格式化的 Cookie 头
 func (s *Settings) GetCookieHeader() string {
-	cookie := strings.TrimSpace(s.NotionCookie)
+	return cookieHeader(s.NotionCookie)
+}
+
+// GetCookieHeader 获取该账号格式化的 Cookie 头
+func (a NotionAccount) GetCookieHeader() string {
+	return cookieHeader(a.Cookie)
+}
+
+// cookieHeader 只提供了 token_v2 的值时补全为 Cookie 格式
+func cookieHeader(cookie string) string {
+	cookie = strings.TrimSpace(cookie)
 	if strings.Contains(cookie, "=") {
 		return cookie
 	}
 	return "token_v2=" + cookie
-}
\ No newline at end of file
+}
@@ -0,0 +1,425 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Notion 线程类型
+const (
+	ThreadTypeWorkflow     = "workflow"
+	ThreadTypeMarkdownChat = "markdown-chat"
+)
+
+// ModelSpec 一个对外提供的模型及其在 Notion 中的配置
+type ModelSpec struct {
+	// ID 客户端请求时使用的模型名
+	ID string `json:"id" yaml:"id" toml:"id"`
+	// Codename Notion 内部的模型代号，如 anthropic-sonnet-alt-thinking
+	Codename string `json:"codename" yaml:"codename" toml:"codename"`
+	// ThreadType 线程类型，为空时 vertex- 开头的代号使用 markdown-chat，其余使用 workflow
+	ThreadType string `json:"thread_type,omitempty" yaml:"thread_type,omitempty" toml:"thread_type,omitempty"`
+	// Aliases 同样指向该模型的其他名称，不在模型列表中单独列出
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty" toml:"aliases,omitempty"`
+	// Hidden 为 true 时不在 /v1/models 中列出，但仍可以请求
+	Hidden bool `json:"hidden,omitempty" yaml:"hidden,omitempty" toml:"hidden,omitempty"`
+	// ExposeThinking 覆盖该模型的 EXPOSE_THINKING 默认值，请求参数仍然优先
+	ExposeThinking *bool `json:"expose_thinking,omitempty" yaml:"expose_thinking,omitempty" toml:"expose_thinking,omitempty"`
+	// WebSearch 是否允许 Notion 在回答时搜索网页，默认开启
+	WebSearch *bool `json:"web_search,omitempty" yaml:"web_search,omitempty" toml:"web_search,omitempty"`
+
+	// 以下为模型描述，只用于 /v1/models 返回给客户端，不影响请求 Notion
+	// DisplayName 展示名称
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty" toml:"display_name,omitempty"`
+	// Created 模型发布日期，格式 2006-01-02
+	Created string `json:"created,omitempty" yaml:"created,omitempty" toml:"created,omitempty"`
+	// ContextWindow 上下文窗口（token）
+	ContextWindow int `json:"context_window,omitempty" yaml:"context_window,omitempty" toml:"context_window,omitempty"`
+	// MaxOutputTokens 最大输出长度（token）
+	MaxOutputTokens int `json:"max_output_tokens,omitempty" yaml:"max_output_tokens,omitempty" toml:"max_output_tokens,omitempty"`
+	// Vision 是否支持图片输入
+	Vision bool `json:"vision,omitempty" yaml:"vision,omitempty" toml:"vision,omitempty"`
+	// Tools 是否支持工具调用
+	Tools bool `json:"tools,omitempty" yaml:"tools,omitempty" toml:"tools,omitempty"`
+	// Thinking 模型是否会输出思考过程，是否返回给客户端由 expose_thinking 决定
+	Thinking bool `json:"thinking,omitempty" yaml:"thinking,omitempty" toml:"thinking,omitempty"`
+
+	createdAt time.Time
+}
+
+// CreatedAt 模型发布日期，未配置时为零值
+func (m *ModelSpec) CreatedAt() time.Time {
+	return m.createdAt
+}
+
+// DefaultThreadType 未指定 thread_type 时按代号推断线程类型：vertex- 开头的使用 markdown-chat，其余使用 workflow
+func DefaultThreadType(codename string) string {
+	if strings.HasPrefix(codename, "vertex-") {
+		return ThreadTypeMarkdownChat
+	}
+	return ThreadTypeWorkflow
+}
+
+// UsesWebSearch 是否开启网页搜索
+func (m *ModelSpec) UsesWebSearch() bool {
+	return m.WebSearch == nil || *m.WebSearch
+}
+
+// AliasRule 按模式匹配模型名的别名规则，用于 claude-sonnet-4-5-2025xxxx 这类不断新增的带日期模型名。
+// 模型名和 aliases 的精确匹配优先于规则。
+type AliasRule struct {
+	// Match 通配符模式，* 匹配任意个字符，? 匹配单个字符，如 claude-sonnet-4-5-*
+	Match string `json:"match,omitempty" yaml:"match,omitempty" toml:"match,omitempty"`
+	// Regex 正则表达式，与 Match 二选一，需要匹配完整的模型名
+	Regex string `json:"regex,omitempty" yaml:"regex,omitempty" toml:"regex,omitempty"`
+	// Model 匹配后使用的模型 ID
+	Model string `json:"model" yaml:"model" toml:"model"`
+	// Priority 优先级，数值大的先匹配，相同时按定义顺序
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty" toml:"priority,omitempty"`
+}
+
+// aliasRule 编译后的别名规则
+type aliasRule struct {
+	pattern  string
+	re       *regexp.Regexp
+	model    *ModelSpec
+	priority int
+}
+
+// modelsFile 模型配置文件的结构
+type modelsFile struct {
+	Models     []ModelSpec `json:"models" yaml:"models" toml:"models"`
+	AliasRules []AliasRule `json:"alias_rules,omitempty" yaml:"alias_rules,omitempty" toml:"alias_rules,omitempty"`
+}
+
+// ModelCatalog 校验后的模型表。加载后只读，热加载时整体替换。
+type ModelCatalog struct {
+	models []ModelSpec
+	byName map[string]*ModelSpec
+	rules  []aliasRule
+	// Source 模型表来源，内置默认值时为空
+	Source string
+	// LoadedAt 加载时间，作为未配置 created 的模型的创建时间
+	LoadedAt time.Time
+}
+
+// NewModelCatalog 校验模型定义和别名规则，建立按模型名和别名的索引
+func NewModelCatalog(models []ModelSpec, rules []AliasRule, source string) (*ModelCatalog, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("至少需要定义一个模型")
+	}
+	catalog := &ModelCatalog{
+		models:   make([]ModelSpec, len(models)),
+		byName:   make(map[string]*ModelSpec),
+		Source:   source,
+		LoadedAt: time.Now(),
+	}
+	copy(catalog.models, models)
+
+	for i := range catalog.models {
+		m := &catalog.models[i]
+		m.ID = strings.TrimSpace(m.ID)
+		m.Codename = strings.TrimSpace(m.Codename)
+		if m.ID == "" {
+			return nil, fmt.Errorf("models[%d]: id 不能为空", i)
+		}
+		if m.Codename == "" {
+			return nil, fmt.Errorf("模型 %s: codename 不能为空", m.ID)
+		}
+		switch m.ThreadType {
+		case "":
+			m.ThreadType = DefaultThreadType(m.Codename)
+		case ThreadTypeWorkflow, ThreadTypeMarkdownChat:
+		default:
+			return nil, fmt.Errorf("模型 %s: 未知的 thread_type %q，可选 %s / %s", m.ID, m.ThreadType, ThreadTypeWorkflow, ThreadTypeMarkdownChat)
+		}
+		if m.Created != "" {
+			created, err := time.Parse("2006-01-02", m.Created)
+			if err != nil {
+				return nil, fmt.Errorf("模型 %s: created 格式应为 2006-01-02: %q", m.ID, m.Created)
+			}
+			m.createdAt = created
+		}
+		if m.ContextWindow < 0 || m.MaxOutputTokens < 0 {
+			return nil, fmt.Errorf("模型 %s: context_window 和 max_output_tokens 不能为负数", m.ID)
+		}
+
+		for _, name := range append([]string{m.ID}, m.Aliases...) {
+			if name == "" {
+				return nil, fmt.Errorf("模型 %s: 别名不能为空", m.ID)
+			}
+			if existing, ok := catalog.byName[name]; ok {
+				return nil, fmt.Errorf("模型名 %s 重复定义 (%s 和 %s)", name, existing.ID, m.ID)
+			}
+			catalog.byName[name] = m
+		}
+	}
+
+	for i, rule := range rules {
+		compiled, err := compileAliasRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("alias_rules[%d]: %v", i, err)
+		}
+		target, ok := catalog.byName[strings.TrimSpace(rule.Model)]
+		if !ok {
+			return nil, fmt.Errorf("alias_rules[%d]: 目标模型 %s 不在模型表中", i, rule.Model)
+		}
+		compiled.model = target
+		catalog.rules = append(catalog.rules, compiled)
+	}
+	sort.SliceStable(catalog.rules, func(i, j int) bool {
+		return catalog.rules[i].priority > catalog.rules[j].priority
+	})
+	return catalog, nil
+}
+
+// compileAliasRule 把通配符或正则表达式编译为完整匹配的正则
+func compileAliasRule(rule AliasRule) (aliasRule, error) {
+	var expr string
+	switch {
+	case rule.Match != "" && rule.Regex != "":
+		return aliasRule{}, fmt.Errorf("match 和 regex 只能设置一个")
+	case rule.Match != "":
+		expr = regexp.QuoteMeta(rule.Match)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+	case rule.Regex != "":
+		expr = rule.Regex
+	default:
+		return aliasRule{}, fmt.Errorf("需要设置 match 或 regex")
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return aliasRule{}, fmt.Errorf("无效的正则表达式 %q: %v", rule.Regex, err)
+	}
+	pattern := rule.Match
+	if pattern == "" {
+		pattern = "/" + rule.Regex + "/"
+	}
+	return aliasRule{pattern: pattern, re: re, priority: rule.Priority}, nil
+}
+
+// Lookup 按模型名或别名精确查找模型
+func (c *ModelCatalog) Lookup(name string) (*ModelSpec, bool) {
+	m, ok := c.byName[name]
+	return m, ok
+}
+
+// Resolve 查找请求的模型：先精确匹配模型名和别名，再按优先级匹配别名规则。
+// 返回值 rule 为命中的规则（精确匹配时为空），便于排查模型被解析到了哪里。
+func (c *ModelCatalog) Resolve(name string) (model *ModelSpec, rule string, ok bool) {
+	if m, ok := c.byName[name]; ok {
+		return m, "", true
+	}
+	for _, r := range c.rules {
+		if r.re.MatchString(name) {
+			return r.model, r.pattern, true
+		}
+	}
+	return nil, "", false
+}
+
+// HasCodename 是否有模型（包括隐藏的模型）使用该 Notion 代号
+func (c *ModelCatalog) HasCodename(codename string) bool {
+	for _, m := range c.models {
+		if m.Codename == codename {
+			return true
+		}
+	}
+	return false
+}
+
+// Listed 返回在模型列表中展示的模型
+func (c *ModelCatalog) Listed() []ModelSpec {
+	list := make([]ModelSpec, 0, len(c.models))
+	for _, m := range c.models {
+		if !m.Hidden {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// Len 模型数量（不含别名）
+func (c *ModelCatalog) Len() int {
+	return len(c.models)
+}
+
+// currentModels 当前生效的模型表，热加载时原子替换，进行中的请求继续使用已解析出的模型
+var currentModels atomic.Pointer[ModelCatalog]
+
+// Models 返回当前生效的模型表
+func Models() *ModelCatalog {
+	return currentModels.Load()
+}
+
+// SetModels 替换当前生效的模型表
+func SetModels(catalog *ModelCatalog) {
+	currentModels.Store(catalog)
+}
+
+// BuiltinModels 返回内置模型表的副本
+func BuiltinModels() []ModelSpec {
+	models := make([]ModelSpec, len(defaultModels))
+	copy(models, defaultModels)
+	return models
+}
+
+// LoadModelsFile 读取并校验模型配置文件，按扩展名支持 YAML、TOML 和 JSON，不允许未知字段
+func LoadModelsFile(path string) (*ModelCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取模型配置文件失败: %v", err)
+	}
+
+	var file modelsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	default:
+		return nil, fmt.Errorf("不支持的模型配置文件格式: %s，可选 .yaml / .toml / .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析模型配置文件 %s 失败: %v", path, err)
+	}
+
+	catalog, err := NewModelCatalog(file.Models, file.AliasRules, path)
+	if err != nil {
+		return nil, fmt.Errorf("模型配置文件 %s 无效: %v", path, err)
+	}
+	return catalog, nil
+}
+
+// ModelsWatcher 在文件变化或收到 SIGHUP 时重新加载模型配置文件
+type ModelsWatcher struct {
+	path         string
+	defaultModel string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// NewModelsWatcher 创建模型配置文件的监视器，defaultModel 必须在新的模型表中存在
+func NewModelsWatcher(path, defaultModel string) *ModelsWatcher {
+	w := &ModelsWatcher{path: path, defaultModel: defaultModel}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime, w.size = info.ModTime(), info.Size()
+	}
+	return w
+}
+
+// Reload 重新加载模型配置文件，失败时保留当前模型表
+func (w *ModelsWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if info, err := os.Stat(w.path); err == nil {
+		w.modTime, w.size = info.ModTime(), info.Size()
+	}
+	catalog, err := LoadModelsFile(w.path)
+	if err == nil {
+		err = checkDefaultModel(catalog, w.defaultModel)
+	}
+	if err != nil {
+		log.Errorf("重新加载模型配置失败，继续使用当前配置: %v", err)
+		return err
+	}
+	SetModels(catalog)
+	log.Infof("已重新加载模型配置 %s，共 %d 个模型", w.path, catalog.Len())
+	return nil
+}
+
+// Watch 每隔 interval 检查文件的修改时间和大小，变化时重新加载，stop 关闭后退出
+func (w *ModelsWatcher) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(w.path)
+		if err != nil {
+			continue
+		}
+		w.mu.Lock()
+		changed := !info.ModTime().Equal(w.modTime) || info.Size() != w.size
+		w.mu.Unlock()
+		if changed {
+			log.Infof("检测到模型配置文件 %s 已修改", w.path)
+			w.Reload()
+		}
+	}
+}
+
+// checkDefaultModel 默认模型必须在模型表中，UNKNOWN_MODEL_POLICY=fallback 时未知模型会回退到它
+func checkDefaultModel(catalog *ModelCatalog, defaultModel string) error {
+	if _, ok := catalog.Lookup(defaultModel); !ok {
+		return fmt.Errorf("默认模型 %s (DEFAULT_MODEL) 不在模型表中", defaultModel)
+	}
+	return nil
+}
+
+// defaultModels 未指定 MODELS_FILE 时使用的内置模型表 (2024年12月)
+// 工具调用由本服务通过提示词实现，所有模型都支持
+var defaultModels = []ModelSpec{
+	// 新测试版模型
+	{ID: "claude-sonnet-4.5", Codename: "anthropic-sonnet-alt-thinking", Aliases: []string{"claude-sonnet-4-5-20241022", "sonnet"},
+		DisplayName: "Claude Sonnet 4.5", Created: "2025-09-29", ContextWindow: 200000, MaxOutputTokens: 64000, Vision: true, Tools: true, Thinking: true},
+	{ID: "claude-opus-4.5", Codename: "apple-danish", Aliases: []string{"claude-opus-4-5-20251101", "opus"},
+		DisplayName: "Claude Opus 4.5", Created: "2025-11-24", ContextWindow: 200000, MaxOutputTokens: 64000, Vision: true, Tools: true, Thinking: true},
+	{ID: "gemini-3-pro", Codename: "gateau-roule",
+		DisplayName: "Gemini 3 Pro", Created: "2025-11-18", ContextWindow: 1048576, MaxOutputTokens: 65536, Vision: true, Tools: true, Thinking: true},
+	{ID: "gpt-5.2", Codename: "oatmeal-cookie",
+		DisplayName: "GPT-5.2", Created: "2025-12-11", ContextWindow: 400000, MaxOutputTokens: 128000, Vision: true, Tools: true, Thinking: true},
+	// 标准模型
+	{ID: "gpt-4o", Codename: "openai-gpt-4o",
+		DisplayName: "GPT-4o", Created: "2024-05-13", ContextWindow: 128000, MaxOutputTokens: 16384, Vision: true, Tools: true},
+	{ID: "gpt-4o-mini", Codename: "openai-gpt-4o-mini",
+		DisplayName: "GPT-4o mini", Created: "2024-07-18", ContextWindow: 128000, MaxOutputTokens: 16384, Vision: true, Tools: true},
+	{ID: "o1", Codename: "openai-o1",
+		DisplayName: "OpenAI o1", Created: "2024-12-17", ContextWindow: 200000, MaxOutputTokens: 100000, Vision: true, Tools: true, Thinking: true},
+	{ID: "o1-mini", Codename: "openai-o1-mini",
+		DisplayName: "OpenAI o1-mini", Created: "2024-09-12", ContextWindow: 128000, MaxOutputTokens: 65536, Tools: true, Thinking: true},
+	{ID: "gemini-2.0-flash", Codename: "vertex-gemini-2.0-flash",
+		DisplayName: "Gemini 2.0 Flash", Created: "2025-02-05", ContextWindow: 1048576, MaxOutputTokens: 8192, Vision: true, Tools: true},
+	{ID: "gemini-1.5-pro", Codename: "vertex-gemini-1.5-pro",
+		DisplayName: "Gemini 1.5 Pro", Created: "2024-05-24", ContextWindow: 2097152, MaxOutputTokens: 8192, Vision: true, Tools: true},
+}
+
+// defaultAliasRules 内置的别名规则，Claude CLI 等客户端使用的带日期模型名按系列归到对应模型
+var defaultAliasRules = []AliasRule{
+	{Match: "claude-sonnet-4-5*", Model: "claude-sonnet-4.5", Priority: 10},
+	{Match: "claude-opus-4-5*", Model: "claude-opus-4.5", Priority: 10},
+	{Match: "claude-*opus*", Model: "claude-opus-4.5"},
+	{Match: "claude-*sonnet*", Model: "claude-sonnet-4.5"},
+	{Match: "claude-*haiku*", Model: "claude-sonnet-4.5"},
+}
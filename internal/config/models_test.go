@@ -0,0 +1,72 @@
+package config
+
+import "testing"
+
+func TestModelCatalogResolve(t *testing.T) {
+	models := []ModelSpec{
+		{ID: "claude-sonnet-4.5", Codename: "anthropic-sonnet-alt", Aliases: []string{"sonnet"}},
+		{ID: "claude-opus-4.1", Codename: "anthropic-opus-4.1"},
+		{ID: "gpt-5", Codename: "openai-turbo"},
+	}
+	rules := []AliasRule{
+		{Match: "claude-sonnet-*", Model: "claude-sonnet-4.5"},
+		{Match: "claude-*", Model: "claude-opus-4.1", Priority: -1},
+		{Regex: `gpt-5(-mini|-nano)?(-\d{4}-\d{2}-\d{2})?`, Model: "gpt-5"},
+		{Match: "gpt-?o", Model: "gpt-5", Priority: 10},
+	}
+	catalog, err := NewModelCatalog(models, rules, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		model string
+		rule  string
+		ok    bool
+	}{
+		{"claude-sonnet-4.5", "claude-sonnet-4.5", "", true},
+		{"sonnet", "claude-sonnet-4.5", "", true},
+		{"claude-sonnet-4-5-20250929", "claude-sonnet-4.5", "claude-sonnet-*", true},
+		{"claude-3-haiku", "claude-opus-4.1", "claude-*", true},
+		{"gpt-5-mini-2025-08-07", "gpt-5", `/gpt-5(-mini|-nano)?(-\d{4}-\d{2}-\d{2})?/`, true},
+		{"gpt-4o", "gpt-5", "gpt-?o", true},
+		{"gpt-5-pro", "", "", false},
+		{"my-claude-sonnet", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, rule, ok := catalog.Resolve(tt.name)
+			if ok != tt.ok {
+				t.Fatalf("Resolve(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if m.ID != tt.model || rule != tt.rule {
+				t.Errorf("Resolve(%q) = %s via %q, want %s via %q", tt.name, m.ID, rule, tt.model, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNewModelCatalogRejectsInvalidRules(t *testing.T) {
+	models := []ModelSpec{{ID: "m", Codename: "c"}}
+	tests := []struct {
+		name string
+		rule AliasRule
+	}{
+		{"同时设置 match 和 regex", AliasRule{Match: "a*", Regex: "a.*", Model: "m"}},
+		{"没有模式", AliasRule{Model: "m"}},
+		{"无效的正则", AliasRule{Regex: "(", Model: "m"}},
+		{"目标模型不存在", AliasRule{Match: "a*", Model: "missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewModelCatalog(models, []AliasRule{tt.rule}, "test"); err == nil {
+				t.Errorf("NewModelCatalog() accepted rule %+v", tt.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package conversations
+
+import (
+	"fmt"
+	"notion-2api-go/internal/jsonstore"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// flushInterval 对应关系写回文件并清理过期对话的间隔
+const flushInterval = 10 * time.Second
+
+// Conversation 一个多轮对话与 Notion 线程的对应关系
+type Conversation struct {
+	Key      string `json:"key"`
+	ThreadID string `json:"thread_id"`
+	// Account 线程所属的账号名，线程只能用创建它的账号继续
+	Account string `json:"account"`
+	// Turns 线程中已有的消息数（包括模型的最后一条回复）
+	Turns int `json:"turns"`
+	// Fingerprint 线程中已有消息的指纹，客户端发来的历史与之不一致时（例如编辑或重新生成）改用新线程
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Store 对话与 Notion 线程的对应关系，超过 ttl 没有新消息的对话会被清理。
+// 设置了文件路径时定期写回文件，重启后可以继续之前的对话。
+type Store struct {
+	mu            sync.Mutex
+	path          string
+	ttl           time.Duration
+	conversations map[string]*Conversation
+	dirty         bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open 打开对话存储文件，文件不存在时从空存储开始，file 为空时只保存在内存中
+func Open(file string, ttl time.Duration) (*Store, error) {
+	s := &Store{
+		path:          file,
+		ttl:           ttl,
+		conversations: make(map[string]*Conversation),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if file != "" {
+		var list []*Conversation
+		if err := jsonstore.Load(file, &list); err != nil {
+			return nil, fmt.Errorf("加载对话文件失败: %v", err)
+		}
+		for _, c := range list {
+			s.conversations[c.Key] = c
+		}
+		s.expireLocked(time.Now())
+	}
+
+	go s.flushLoop()
+	return s, nil
+}
+
+// Close 停止后台任务并保存未写入的对应关系
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || s.path == "" {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// Len 当前保存的对话数
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conversations)
+}
+
+// Take 取出并删除对话，用于继续该对话的请求独占线程：
+// 请求成功后再用 Put 写回，失败时对话不再存在，下一次请求会改用新线程，避免线程中留下不完整的轮次
+func (s *Store) Take(key string) (Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[key]
+	if !ok {
+		return Conversation{}, false
+	}
+	delete(s.conversations, key)
+	s.dirty = true
+	if s.expired(c, time.Now()) {
+		return Conversation{}, false
+	}
+	return *c, true
+}
+
+// Peek 查看对话但不取出
+func (s *Store) Peek(key string) (Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[key]
+	if !ok || s.expired(c, time.Now()) {
+		return Conversation{}, false
+	}
+	return *c, true
+}
+
+// Put 保存对话
+func (s *Store) Put(c Conversation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := s.conversations[c.Key]; ok && c.CreatedAt.IsZero() {
+		c.CreatedAt = existing.CreatedAt
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = now
+	}
+	c.UpdatedAt = now
+	s.conversations[c.Key] = &c
+	s.dirty = true
+}
+
+// Delete 删除对话
+func (s *Store) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conversations[key]; ok {
+		delete(s.conversations, key)
+		s.dirty = true
+	}
+}
+
+// HasThread 线程是否仍属于未过期的对话
+func (s *Store) HasThread(threadID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range s.conversations {
+		if c.ThreadID == threadID && !s.expired(c, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) expired(c *Conversation, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(c.UpdatedAt) > s.ttl
+}
+
+// expireLocked 删除过期的对话，调用方需持有锁
+func (s *Store) expireLocked(now time.Time) int {
+	n := 0
+	for key, c := range s.conversations {
+		if s.expired(c, now) {
+			delete(s.conversations, key)
+			n++
+		}
+	}
+	if n > 0 {
+		s.dirty = true
+	}
+	return n
+}
+
+// flushLoop 定期清理过期对话并写回文件
+func (s *Store) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if n := s.expireLocked(time.Now()); n > 0 {
+				log.Debugf("清理了 %d 个过期对话", n)
+			}
+			if s.dirty && s.path != "" {
+				if err := s.saveLocked(); err != nil {
+					log.Errorf("保存对话文件失败: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// saveLocked 按 Key 排序写回文件，调用方需持有锁
+func (s *Store) saveLocked() error {
+	list := make([]*Conversation, 0, len(s.conversations))
+	for _, c := range s.conversations {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	if err := jsonstore.Save(s.path, list); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
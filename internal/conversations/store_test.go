@@ -0,0 +1,138 @@
+package conversations
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, file string, ttl time.Duration) *Store {
+	t.Helper()
+	s, err := Open(file, ttl)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return s
+}
+
+func TestTakeAndPut(t *testing.T) {
+	s := openTestStore(t, "", time.Hour)
+	defer s.Close()
+
+	s.Put(Conversation{Key: "c1", ThreadID: "t1", Account: "a", Turns: 2})
+	first, ok := s.Peek("c1")
+	if !ok {
+		t.Fatal("Peek() found nothing after Put()")
+	}
+
+	// Take 独占对话：取出后同一对话的其他请求看不到它
+	c, ok := s.Take("c1")
+	if !ok || c.ThreadID != "t1" || c.Turns != 2 {
+		t.Fatalf("Take() = %+v, %v", c, ok)
+	}
+	if _, ok := s.Take("c1"); ok {
+		t.Fatal("second Take() returned the conversation again")
+	}
+	if _, ok := s.Peek("c1"); ok {
+		t.Fatal("Peek() sees a taken conversation")
+	}
+
+	// 写回时保留创建时间，更新修改时间
+	c.Turns = 4
+	s.Put(c)
+	got, ok := s.Peek("c1")
+	if !ok || got.Turns != 4 {
+		t.Fatalf("Peek() after Put() = %+v, %v", got, ok)
+	}
+	if !got.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("CreatedAt changed from %s to %s", first.CreatedAt, got.CreatedAt)
+	}
+	if got.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("UpdatedAt went back from %s to %s", first.UpdatedAt, got.UpdatedAt)
+	}
+
+	s.Delete("c1")
+	if s.Len() != 0 {
+		t.Errorf("Len() = %d after Delete()", s.Len())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := openTestStore(t, "", 30*time.Millisecond)
+	defer s.Close()
+
+	s.Put(Conversation{Key: "old", ThreadID: "t1"})
+	time.Sleep(50 * time.Millisecond)
+	s.Put(Conversation{Key: "new", ThreadID: "t2"})
+
+	if _, ok := s.Peek("old"); ok {
+		t.Error("Peek() returned an expired conversation")
+	}
+	// 过期的对话被取出后不再使用，也不会留在存储中
+	if _, ok := s.Take("old"); ok {
+		t.Error("Take() returned an expired conversation")
+	}
+	if _, ok := s.Take("new"); !ok {
+		t.Error("Take() lost a fresh conversation")
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", s.Len())
+	}
+
+	s.Put(Conversation{Key: "a"})
+	s.mu.Lock()
+	n := s.expireLocked(time.Now().Add(time.Second))
+	s.mu.Unlock()
+	if n != 1 || s.Len() != 0 {
+		t.Errorf("expireLocked() removed %d, %d left", n, s.Len())
+	}
+}
+
+func TestNoTTL(t *testing.T) {
+	s := openTestStore(t, "", 0)
+	defer s.Close()
+
+	s.Put(Conversation{Key: "c1"})
+	s.mu.Lock()
+	s.conversations["c1"].UpdatedAt = time.Now().Add(-365 * 24 * time.Hour)
+	s.mu.Unlock()
+	if _, ok := s.Take("c1"); !ok {
+		t.Error("conversation expired with ttl 0")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "conversations.json")
+	s := openTestStore(t, file, time.Hour)
+	s.Put(Conversation{Key: "c1", ThreadID: "t1", Account: "a", Turns: 2, Fingerprint: "f"})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// 重新打开时读取未过期的对话，丢弃过期的对话
+	var list []Conversation
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	list = append(list, Conversation{Key: "stale", ThreadID: "t0", UpdatedAt: time.Now().Add(-2 * time.Hour)})
+	data, _ = json.Marshal(list)
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestStore(t, file, time.Hour)
+	defer reopened.Close()
+	if reopened.Len() != 1 {
+		t.Fatalf("Len() after reopening = %d, want 1", reopened.Len())
+	}
+	c, ok := reopened.Peek("c1")
+	if !ok || c.ThreadID != "t1" || c.Account != "a" || c.Turns != 2 || c.Fingerprint != "f" {
+		t.Errorf("reloaded conversation = %+v, %v", c, ok)
+	}
+}
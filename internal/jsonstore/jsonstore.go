@@ -0,0 +1,48 @@
+// Package jsonstore 读写保存在 JSON 文件中的状态，供 API Key、对话和线程记录等存储共用
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Load 把文件内容解析到 v，文件不存在时不修改 v 并返回 nil
+func Load(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("读取 %s 失败: %v", file, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", file, err)
+	}
+	return nil
+}
+
+// Save 把 v 写入文件：先写入同目录的临时文件再重命名，避免写入中断损坏文件
+func Save(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+		}
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入 %s 失败: %v", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package jsonstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type record struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nested", "records.json")
+	want := []record{{"a", 1}, {"b", 2}}
+	if err := Save(file, want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	var got []record
+	if err := Load(file, &got); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content *string
+		wantErr bool
+		want    []record
+	}{
+		{"文件不存在", nil, false, nil},
+		{"有效内容", strPtr(`[{"id":"a","count":3}]`), false, []record{{"a", 3}}},
+		{"无效 JSON", strPtr(`[{"id":`), true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name+".json")
+			if tt.content != nil {
+				if err := os.WriteFile(file, []byte(*tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []record
+			err := Load(file, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string { return &s }
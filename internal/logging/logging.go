@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// capture 为 true 时日志中保留提示词、响应正文和 Cookie，仅用于本地排查问题
+var capture atomic.Bool
+
+// Options 日志配置
+type Options struct {
+	// Format 输出格式，text 或 json
+	Format string
+	// Level 日志级别，如 debug、info、warn、error
+	Level string
+	// Capture 调试捕获模式，开启后不再隐藏请求和响应内容
+	Capture bool
+	// Secrets 需要从日志中隐藏的敏感字符串，如各账号的 Notion Cookie
+	Secrets []string
+}
+
+// Setup 按配置设置全局 logrus：输出格式、级别和脱敏钩子
+func Setup(opts Options) error {
+	switch strings.ToLower(opts.Format) {
+	case "", FormatText:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("未知的日志格式: %s，可选 %s / %s", opts.Format, FormatText, FormatJSON)
+	}
+
+	level := log.InfoLevel
+	if opts.Level != "" {
+		parsed, err := log.ParseLevel(opts.Level)
+		if err != nil {
+			return fmt.Errorf("未知的日志级别: %s", opts.Level)
+		}
+		level = parsed
+	}
+	log.SetLevel(level)
+	log.SetOutput(os.Stdout)
+
+	capture.Store(opts.Capture)
+	log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	log.AddHook(newRedactHook(opts.Secrets))
+	if opts.Capture {
+		log.Warn("已开启调试捕获模式 (LOG_DEBUG_CAPTURE)，日志中将包含提示词、响应内容和 Cookie，请勿在生产环境使用。")
+	}
+	return nil
+}
+
+// Capture 是否处于调试捕获模式
+func Capture() bool {
+	return capture.Load()
+}
+
+// Content 返回可以写入日志的请求或响应内容：调试捕获模式下原样返回，否则只保留长度
+func Content(s string) string {
+	if Capture() {
+		return s
+	}
+	return fmt.Sprintf("[已隐藏 %d 字节]", len(s))
+}
+
+// requestIDKey 请求 ID 在 context 中的键
+type requestIDKey struct{}
+
+// WithRequestID 返回携带请求 ID 的 context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 返回 context 中的请求 ID，没有时为空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext 返回带有请求 ID 字段的日志记录器，请求处理过程中的日志都应通过它输出
+func FromContext(ctx context.Context) *log.Entry {
+	entry := log.NewEntry(log.StandardLogger())
+	if id := RequestID(ctx); id != "" {
+		entry = entry.WithField("request_id", id)
+	}
+	return entry
+}
+
+// cookiePattern 匹配 Notion 登录 Cookie，作为配置之外的兜底
+var cookiePattern = regexp.MustCompile(`(token_v2|notion_user_id|notion_browser_id|p_sync_session)=[^;\s"']+`)
+
+// redactHook 在输出前隐藏日志消息和字段中的 Cookie
+type redactHook struct {
+	secrets []string
+}
+
+func newRedactHook(secrets []string) *redactHook {
+	hook := &redactHook{}
+	for _, secret := range secrets {
+		// 过短的值替换会误伤正常内容
+		if len(secret) >= 8 {
+			hook.secrets = append(hook.secrets, secret)
+		}
+	}
+	return hook
+}
+
+func (h *redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *redactHook) Fire(entry *log.Entry) error {
+	if Capture() {
+		return nil
+	}
+	entry.Message = h.redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = h.redact(v)
+		case error:
+			entry.Data[key] = h.redact(v.Error())
+		}
+	}
+	return nil
+}
+
+func (h *redactHook) redact(s string) string {
+	for _, secret := range h.secrets {
+		s = strings.ReplaceAll(s, secret, "[已隐藏]")
+	}
+	return cookiePattern.ReplaceAllString(s, "$1=[已隐藏]")
+}
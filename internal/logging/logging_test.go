@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// setCapture 在测试期间切换调试捕获模式
+func setCapture(t *testing.T, on bool) {
+	t.Helper()
+	old := capture.Load()
+	capture.Store(on)
+	t.Cleanup(func() { capture.Store(old) })
+}
+
+// newTestLogger 返回挂载脱敏钩子、输出到 buf 的独立日志记录器
+func newTestLogger(buf *bytes.Buffer, secrets ...string) *log.Logger {
+	logger := log.New()
+	logger.SetOutput(buf)
+	logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	logger.AddHook(newRedactHook(secrets))
+	return logger
+}
+
+func TestRedactHook(t *testing.T) {
+	setCapture(t, false)
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, "secret-cookie-value", "short")
+
+	logger.WithFields(log.Fields{
+		"cookie": "a=1; token_v2=v02%3Auser_token; b=2",
+		"err":    errors.New("请求失败: secret-cookie-value 已过期"),
+	}).Info("使用 secret-cookie-value 发起请求, short 不应被替换")
+
+	out := buf.String()
+	for _, leaked := range []string{"secret-cookie-value", "v02%3Auser_token"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("log output leaks %q: %s", leaked, out)
+		}
+	}
+	for _, kept := range []string{"token_v2=[已隐藏]", "short 不应被替换"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("log output lacks %q: %s", kept, out)
+		}
+	}
+}
+
+func TestRedactHookCapture(t *testing.T) {
+	setCapture(t, true)
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, "secret-cookie-value")
+
+	logger.Info("token_v2=abc secret-cookie-value")
+	if out := buf.String(); !strings.Contains(out, "token_v2=abc secret-cookie-value") {
+		t.Errorf("capture mode redacted the message: %s", out)
+	}
+}
+
+func TestContent(t *testing.T) {
+	setCapture(t, false)
+	if got := Content("你好"); got != "[已隐藏 6 字节]" {
+		t.Errorf("Content() = %q, want length only", got)
+	}
+	setCapture(t, true)
+	if got := Content("你好"); got != "你好" {
+		t.Errorf("Content() in capture mode = %q, want the original", got)
+	}
+}
+
+func TestRequestIDContext(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("RequestID() without an ID = %q", id)
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	if id := RequestID(ctx); id != "req-1" {
+		t.Errorf("RequestID() = %q, want req-1", id)
+	}
+	if got := FromContext(ctx).Data["request_id"]; got != "req-1" {
+		t.Errorf("FromContext() request_id = %v, want req-1", got)
+	}
+	if _, ok := FromContext(context.Background()).Data["request_id"]; ok {
+		t.Error("FromContext() without an ID sets request_id")
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, RequestID(c.Request.Context()))
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"使用客户端的 ID", "client-id-123", true},
+		{"没有 ID", "", false},
+		{"包含空格", "bad id", false},
+		{"包含换行", "bad\nid", false},
+		{"过长", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"最大长度", strings.Repeat("a", maxRequestIDLength), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(HeaderRequestID, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(HeaderRequestID)
+			if id != w.Body.String() {
+				t.Errorf("response header %q differs from the context ID %q", id, w.Body.String())
+			}
+			if tt.keep && id != tt.header {
+				t.Errorf("request ID = %q, want the client's %q", id, tt.header)
+			}
+			if !tt.keep && (id == tt.header || len(id) != 36) {
+				t.Errorf("request ID = %q, want a generated UUID", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// HeaderRequestID 请求 ID 的请求头和响应头
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLength 客户端传入的请求 ID 最大长度，超出或包含不可见字符时重新生成
+const maxRequestIDLength = 128
+
+// Middleware 为每个请求分配请求 ID（优先使用客户端的 X-Request-ID），写入响应头和请求 context，
+// 并在请求结束后输出一行访问日志，替代 gin.Logger()
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(HeaderRequestID)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		c.Header(HeaderRequestID, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+
+		c.Next()
+
+		entry := FromContext(c.Request.Context()).WithFields(log.Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"status":     c.Writer.Status(),
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+		entry.Info("请求完成")
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ModelContextKey 处理函数解析出的请求模型在 gin 上下文中的键，用于请求指标的 model 标签
+const ModelContextKey = "metrics_model"
+
+// latencyBuckets 请求和上游推理耗时的直方图桶（秒），覆盖从快速失败到长时间流式输出
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	// HTTPRequests 按接口、模型和状态码统计的请求数
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_http_requests_total",
+		Help: "按接口、模型和状态码统计的 HTTP 请求数",
+	}, []string{"endpoint", "model", "status"})
+	// HTTPRequestDuration 按接口、模型和状态码统计的请求耗时，流式请求包含整个输出过程
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notion2api_http_request_duration_seconds",
+		Help:    "按接口、模型和状态码统计的 HTTP 请求耗时（秒）",
+		Buckets: latencyBuckets,
+	}, []string{"endpoint", "model", "status"})
+
+	// UpstreamDuration runInferenceTranscript 从发起请求到响应读取完毕的耗时
+	UpstreamDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notion2api_upstream_request_duration_seconds",
+		Help:    "runInferenceTranscript 从发起请求到响应结束的耗时（秒）",
+		Buckets: latencyBuckets,
+	}, []string{"model", "account"})
+	// UpstreamTTFB runInferenceTranscript 从发起请求到收到第一个内容事件的耗时
+	UpstreamTTFB = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notion2api_upstream_time_to_first_byte_seconds",
+		Help:    "runInferenceTranscript 从发起请求到收到第一个内容事件的耗时（秒）",
+		Buckets: latencyBuckets,
+	}, []string{"model", "account"})
+	// UpstreamErrors 发起推理失败的次数，reason 为 quota、status、network、empty 或 other
+	UpstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_upstream_errors_total",
+		Help: "runInferenceTranscript 发起失败的次数",
+	}, []string{"account", "reason"})
+
+	// UpstreamRetries 发起推理失败后重试的次数，reason 与 UpstreamErrors 相同
+	UpstreamRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_upstream_retries_total",
+		Help: "runInferenceTranscript 失败后重试的次数",
+	}, []string{"reason"})
+	// CircuitOpened 账号因连续失败被熔断的次数
+	CircuitOpened = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_circuit_opened_total",
+		Help: "Notion 账号因连续失败被熔断的次数",
+	}, []string{"account"})
+
+	// InferenceCancelled 因客户端断开而取消的推理数，stage 为 connect、retry_wait 或 stream
+	InferenceCancelled = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_inference_cancelled_total",
+		Help: "因客户端断开而取消的 Notion 推理数",
+	}, []string{"model", "stage"})
+
+	// NDJSONEvents 按类型统计的 Notion NDJSON 事件数
+	NDJSONEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_ndjson_events_total",
+		Help: "按类型统计的 Notion NDJSON 事件数",
+	}, []string{"type"})
+	// QuotaExhausted 账号被标记为额度用尽的次数
+	QuotaExhausted = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "notion2api_quota_exhausted_total",
+		Help: "Notion 账号被标记为额度用尽的次数",
+	}, []string{"account"})
+	// ActiveStreams 正在进行的流式响应数
+	ActiveStreams = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "notion2api_active_streams",
+		Help: "正在进行的流式响应数",
+	}, []string{"protocol"})
+
+	// AccountAvailable 账号当前是否可用（1 可用，0 额度用尽冷却中），由账号池在抓取时提供
+	AccountAvailable = NewGaugeFunc("notion2api_account_available",
+		"Notion 账号当前是否可用（1 可用，0 额度用尽冷却中）", "account")
+	// AccountHealthy 账号是否健康（1 健康），与 /readyz 中账号的 ready 一致，由账号池在抓取时提供
+	AccountHealthy = NewGaugeFunc("notion2api_account_healthy",
+		"Notion 账号是否健康（1 可用、会话已验证、没有连续失败且未被熔断）", "account")
+	// AccountCircuitOpen 账号是否因连续失败被熔断（1 熔断中）
+	AccountCircuitOpen = NewGaugeFunc("notion2api_account_circuit_open",
+		"Notion 账号是否因连续失败被熔断（1 熔断中）", "account")
+	// AccountInFlight 账号正在进行的推理数
+	AccountInFlight = NewGaugeFunc("notion2api_account_in_flight",
+		"Notion 账号正在进行的推理数", "account")
+	// AccountRequests 账号累计被选中的次数
+	AccountRequests = NewCounterFunc("notion2api_account_requests_total",
+		"Notion 账号累计被选中发起推理的次数", "account")
+	// AccountCooldownSeconds 额度用尽的账号距离重新启用的剩余秒数
+	AccountCooldownSeconds = NewGaugeFunc("notion2api_account_cooldown_seconds",
+		"额度用尽的 Notion 账号距离重新启用的剩余秒数", "account")
+)
+
+// Middleware 记录请求数和耗时。endpoint 取路由模板，未匹配的路由记为 unmatched 以限制标签数量。
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		model := c.GetString(ModelContextKey)
+		status := strconv.Itoa(c.Writer.Status())
+		HTTPRequests.WithLabelValues(endpoint, model, status).Inc()
+		HTTPRequestDuration.WithLabelValues(endpoint, model, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler 以 Prometheus 格式输出所有指标
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// registry 本服务的指标，另含 Go 运行时和进程指标
+var registry = prometheus.NewRegistry()
+
+// factory 创建并注册到 registry 的指标
+var factory = promauto.With(registry)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Sample 抓取时计算出的一个样本
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// FuncVec 在每次抓取时调用 collect 计算样本的指标，适合从其他组件读取当前状态
+type FuncVec struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+
+	mu      sync.Mutex
+	collect func() []Sample
+}
+
+// NewGaugeFunc 创建并注册抓取时计算的仪表盘，collect 可以稍后通过 Set 设置
+func NewGaugeFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec(name, help, prometheus.GaugeValue, labels)
+}
+
+// NewCounterFunc 创建并注册抓取时计算的计数器，collect 返回的值应单调递增
+func NewCounterFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec(name, help, prometheus.CounterValue, labels)
+}
+
+func newFuncVec(name, help string, valueType prometheus.ValueType, labels []string) *FuncVec {
+	f := &FuncVec{desc: prometheus.NewDesc(name, help, labels, nil), valueType: valueType}
+	registry.MustRegister(f)
+	return f
+}
+
+// Set 设置样本来源
+func (f *FuncVec) Set(collect func() []Sample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.collect = collect
+}
+
+// Describe 实现 prometheus.Collector
+func (f *FuncVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+// Collect 实现 prometheus.Collector
+func (f *FuncVec) Collect(ch chan<- prometheus.Metric) {
+	f.mu.Lock()
+	collect := f.collect
+	f.mu.Unlock()
+
+	if collect == nil {
+		return
+	}
+	for _, s := range collect() {
+		ch <- prometheus.MustNewConstMetric(f.desc, f.valueType, s.Value, s.LabelValues...)
+	}
+}
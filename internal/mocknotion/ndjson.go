@@ -0,0 +1,105 @@
+package mocknotion
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ndjsonWriter 逐行写出 NDJSON 事件，每行立即刷新给客户端
+type ndjsonWriter struct {
+	c *gin.Context
+}
+
+func (w *ndjsonWriter) write(event interface{}) {
+	data, _ := json.Marshal(event)
+	w.raw(string(data))
+}
+
+func (w *ndjsonWriter) raw(line string) {
+	w.c.Writer.WriteString(line + "\n")
+	w.c.Writer.Flush()
+}
+
+// reply 按格式写出回复：先逐片段发送增量，最后发送包含完整回复的事件
+func (w *ndjsonWriter) reply(format, reply string, sc Scenario) {
+	if format == FormatRecordMap {
+		w.write(recordMapEvent(reply))
+		return
+	}
+
+	for i, chunk := range split(reply, sc.ChunkSize) {
+		if i > 0 && sc.Delay > 0 {
+			time.Sleep(time.Duration(sc.Delay) * time.Millisecond)
+		}
+		w.write(patchEvent(format, i, chunk))
+		if sc.Cut {
+			// 中断响应，客户端读取时得到 unexpected EOF
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	if format == FormatMarkdownChat {
+		w.write(map[string]interface{}{"type": "markdown-chat", "value": reply})
+		return
+	}
+	w.write(recordMapEvent(reply))
+}
+
+// patchEvent 第 index 个增量片段对应的 patch 事件：第一个片段新增内容块，之后的片段追加到块中
+func patchEvent(format string, index int, chunk string) map[string]interface{} {
+	var op map[string]interface{}
+	switch {
+	case format == FormatMarkdownChat && index == 0:
+		op = map[string]interface{}{"o": "a", "p": "/s/-", "v": map[string]interface{}{"type": "markdown-chat", "value": chunk}}
+	case format == FormatMarkdownChat:
+		op = map[string]interface{}{"o": "x", "p": "/s/4/value", "v": chunk}
+	case index == 0:
+		op = map[string]interface{}{"o": "a", "p": "/s/4/value/-", "v": map[string]interface{}{"type": "text", "content": chunk}}
+	default:
+		op = map[string]interface{}{"o": "x", "p": "/s/4/value/0/content", "v": chunk}
+	}
+	return map[string]interface{}{"type": "patch", "v": []interface{}{op}}
+}
+
+// recordMapEvent 包含完整回复的 record-map 事件
+func recordMapEvent(reply string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "record-map",
+		"recordMap": map[string]interface{}{
+			"thread_message": map[string]interface{}{
+				uuid.New().String(): map[string]interface{}{
+					"value": map[string]interface{}{
+						"value": map[string]interface{}{
+							"created_time": time.Now().UnixMilli(),
+							"step": map[string]interface{}{
+								"type":  "agent-inference",
+								"value": []interface{}{map[string]interface{}{"type": "text", "content": reply}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// split 按字符数拆分回复，size 为 0 时使用默认值
+func split(s string, size int) []string {
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	runes := []rune(s)
+	var chunks []string
+	for start := 0; start < len(runes); start += size {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, string(runes[start:end]))
+	}
+	return chunks
+}
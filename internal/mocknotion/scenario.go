@@ -0,0 +1,122 @@
+package mocknotion
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 推理响应的格式
+const (
+	// FormatPatch Claude / GPT 的补丁流：增量片段以 patch 事件发送，最后给出 record-map
+	FormatPatch = "patch"
+	// FormatMarkdownChat Gemini 的 markdown-chat 补丁流，最后给出 markdown-chat 事件
+	FormatMarkdownChat = "markdown-chat"
+	// FormatRecordMap 只返回一个包含完整回复的 record-map 事件
+	FormatRecordMap = "record-map"
+)
+
+// defaultChunkSize 回复按多少个字符拆分为增量片段
+const defaultChunkSize = 8
+
+// Scenario 一个脚本化的推理响应。请求按脚本中的顺序匹配第一个可用的场景，都不匹配时回显用户消息
+type Scenario struct {
+	// Name 场景名称，只用于日志
+	Name string `yaml:"name" json:"name"`
+	// Match 最后一条用户消息包含该字符串时匹配，为空匹配所有消息
+	Match string `yaml:"match" json:"match"`
+	// Model 请求的模型代号等于该值时匹配，为空匹配所有模型
+	Model string `yaml:"model" json:"model"`
+	// Times 场景最多使用的次数，用完后继续匹配后面的场景，0 表示不限
+	Times int `yaml:"times" json:"times"`
+
+	// Status 以该 HTTP 状态码返回错误，0 表示正常返回推理流
+	Status int `yaml:"status" json:"status"`
+	// RetryAfter 返回错误时附带的 Retry-After 响应头（秒）
+	RetryAfter int `yaml:"retry_after" json:"retry_after"`
+	// Quota 返回 premium-feature-unavailable 事件，模拟账号额度用尽
+	Quota bool `yaml:"quota" json:"quota"`
+	// Empty 返回不包含任何内容事件的空响应
+	Empty bool `yaml:"empty" json:"empty"`
+	// Cut 发送第一个增量片段后断开连接
+	Cut bool `yaml:"cut" json:"cut"`
+
+	// Format 响应格式：patch / markdown-chat / record-map，为空时按请求的线程类型选择 patch 或 markdown-chat
+	Format string `yaml:"format" json:"format"`
+	// Reply 回复内容，为空时回显用户消息
+	Reply string `yaml:"reply" json:"reply"`
+	// Thinking 思考过程，以 <thinking> 标签包裹在回复之前
+	Thinking string `yaml:"thinking" json:"thinking"`
+	// ChunkSize 增量片段的字符数，默认 8
+	ChunkSize int `yaml:"chunk_size" json:"chunk_size"`
+	// Delay 每个增量片段之间的间隔（毫秒）
+	Delay int `yaml:"delay" json:"delay"`
+	// Lines 原样返回的 NDJSON 行，设置后忽略上面的回复相关字段，用于复现 Notion 的特殊响应
+	Lines []string `yaml:"lines" json:"lines"`
+}
+
+// Model 工作区中可用的一个模型，由 getAvailableModels 返回
+type Model struct {
+	Codename string `yaml:"codename" json:"codename"`
+	Name     string `yaml:"name" json:"name"`
+	Family   string `yaml:"family" json:"family"`
+	Disabled bool   `yaml:"disabled" json:"disabled"`
+}
+
+// Script 模拟服务的脚本
+type Script struct {
+	// Models getAvailableModels 返回的模型，为空时返回内置模型表中的所有模型
+	Models    []Model    `yaml:"models" json:"models"`
+	Scenarios []Scenario `yaml:"scenarios" json:"scenarios"`
+}
+
+// LoadScript 读取场景脚本，支持 YAML 和 JSON
+func LoadScript(file string) (*Script, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取场景文件 %s 失败: %v", file, err)
+	}
+	var script Script
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("解析场景文件 %s 失败: %v", file, err)
+	}
+	if err := script.validate(); err != nil {
+		return nil, fmt.Errorf("场景文件 %s 无效: %v", file, err)
+	}
+	return &script, nil
+}
+
+func (s *Script) validate() error {
+	for i, sc := range s.Scenarios {
+		switch sc.Format {
+		case "", FormatPatch, FormatMarkdownChat, FormatRecordMap:
+		default:
+			return fmt.Errorf("第 %d 个场景的 format %q 无效，可选 patch / markdown-chat / record-map", i+1, sc.Format)
+		}
+		if sc.Status != 0 && (sc.Status < 100 || sc.Status > 599) {
+			return fmt.Errorf("第 %d 个场景的 status %d 无效", i+1, sc.Status)
+		}
+		if sc.Times < 0 || sc.ChunkSize < 0 || sc.Delay < 0 || sc.RetryAfter < 0 {
+			return fmt.Errorf("第 %d 个场景的 times / chunk_size / delay / retry_after 不能为负数", i+1)
+		}
+	}
+	return nil
+}
+
+// label 日志中使用的场景名称
+func (sc *Scenario) label(index int) string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+// matches 场景是否匹配请求的模型和用户消息
+func (sc *Scenario) matches(model, message string) bool {
+	if sc.Model != "" && sc.Model != model {
+		return false
+	}
+	return sc.Match == "" || strings.Contains(message, sc.Match)
+}
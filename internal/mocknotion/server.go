@@ -0,0 +1,336 @@
+package mocknotion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server 模拟 Notion 的 api/v3 接口，按脚本返回推理响应，用于在没有 Notion 账号和网络的情况下运行代理
+type Server struct {
+	script *Script
+
+	mu sync.Mutex
+	// used 每个场景已使用的次数
+	used map[int]int
+	// threads 见过的线程及其是否存活
+	threads map[string]*Thread
+}
+
+// Thread 模拟服务记录的线程
+type Thread struct {
+	ID        string    `json:"id"`
+	Alive     bool      `json:"alive"`
+	Turns     int       `json:"turns"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// New 创建模拟服务，script 为 nil 时所有请求都回显用户消息
+func New(script *Script) *Server {
+	if script == nil {
+		script = &Script{}
+	}
+	return &Server{
+		script:  script,
+		used:    make(map[int]int),
+		threads: make(map[string]*Thread),
+	}
+}
+
+// Handler 返回模拟服务的 HTTP 处理器
+func (s *Server) Handler() http.Handler {
+	// 不使用 gin.Recovery：模拟断开连接的场景依赖 http.ErrAbortHandler 交给 net/http 中断响应
+	r := gin.New()
+
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "mock notion")
+	})
+	api := r.Group("/api/v3")
+	api.POST("/runInferenceTranscript", s.runInference)
+	api.POST("/saveTransactionsFanout", s.saveTransactions)
+	api.POST("/getAvailableModels", s.availableModels)
+	api.POST("/getUploadFileUrl", s.uploadFileURL)
+	api.POST("/loadUserContent", s.loadUserContent)
+
+	// 模拟服务自身的接口
+	r.PUT("/__mock/upload/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/__mock/threads", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Threads())
+	})
+	return r
+}
+
+// Threads 返回见过的线程，按创建时间排序
+func (s *Server) Threads() []Thread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Thread, 0, len(s.threads))
+	for _, th := range s.threads {
+		list = append(list, *th)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// inferenceRequest runInferenceTranscript 请求中模拟服务关心的部分
+type inferenceRequest struct {
+	ThreadID     string `json:"threadId"`
+	CreateThread bool   `json:"createThread"`
+	Transcript   []struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"transcript"`
+}
+
+// config 第一个 transcript 步骤中的线程类型和模型代号
+func (r *inferenceRequest) config() (threadType, model string) {
+	for _, step := range r.Transcript {
+		if step.Type != "config" {
+			continue
+		}
+		var cfg struct {
+			Type  string `json:"type"`
+			Model string `json:"model"`
+		}
+		json.Unmarshal(step.Value, &cfg)
+		return cfg.Type, cfg.Model
+	}
+	return "", ""
+}
+
+// lastUserMessage 最后一条用户消息的文本
+func (r *inferenceRequest) lastUserMessage() string {
+	for i := len(r.Transcript) - 1; i >= 0; i-- {
+		step := r.Transcript[i]
+		if step.Type != "user" {
+			continue
+		}
+		var segments [][]interface{}
+		json.Unmarshal(step.Value, &segments)
+		var text strings.Builder
+		for _, segment := range segments {
+			if len(segment) > 0 {
+				if s, ok := segment[0].(string); ok {
+					text.WriteString(s)
+				}
+			}
+		}
+		return text.String()
+	}
+	return ""
+}
+
+// turns 本次请求中用户和模型消息的数量
+func (r *inferenceRequest) turns() int {
+	n := 0
+	for _, step := range r.Transcript {
+		if step.Type == "user" || step.Type == "agent-inference" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) runInference(c *gin.Context) {
+	var req inferenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"name": "ValidationError", "message": err.Error()})
+		return
+	}
+	threadType, model := req.config()
+	message := req.lastUserMessage()
+
+	index, sc := s.pick(model, message)
+	log.Infof("推理请求: 线程 %s, 模型 %s, 场景 %s", req.ThreadID, model, sc.label(index))
+
+	if sc.Status != 0 && sc.Status != http.StatusOK {
+		if sc.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(sc.RetryAfter))
+		}
+		c.JSON(sc.Status, gin.H{"name": "MockError", "message": fmt.Sprintf("场景 %s 返回状态码 %d", sc.label(index), sc.Status)})
+		return
+	}
+	s.recordThread(req)
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	w := &ndjsonWriter{c: c}
+
+	switch {
+	case sc.Quota:
+		w.write(map[string]interface{}{
+			"type": "premium-feature-unavailable",
+			"featureAvailability": map[string]interface{}{
+				"limit": map[string]interface{}{"current": 20, "total": 20},
+			},
+		})
+	case sc.Empty:
+	case len(sc.Lines) > 0:
+		for _, line := range sc.Lines {
+			w.raw(line)
+		}
+	default:
+		reply := sc.Reply
+		if reply == "" {
+			reply = "Mock 回复: " + message
+		}
+		if sc.Thinking != "" {
+			reply = "<thinking>" + sc.Thinking + "</thinking>" + reply
+		}
+		format := sc.Format
+		if format == "" {
+			format = FormatPatch
+			if threadType == config.ThreadTypeMarkdownChat {
+				format = FormatMarkdownChat
+			}
+		}
+		w.reply(format, reply, sc)
+	}
+}
+
+// pick 选择第一个匹配且未用完的场景，都不匹配时返回回显场景
+func (s *Server) pick(model, message string) (int, Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, sc := range s.script.Scenarios {
+		if !sc.matches(model, message) {
+			continue
+		}
+		if sc.Times > 0 && s.used[i] >= sc.Times {
+			continue
+		}
+		s.used[i]++
+		return i, sc
+	}
+	return -1, Scenario{Name: "echo"}
+}
+
+// recordThread 记录推理请求使用的线程，继续不存在的线程时只记录警告
+func (s *Server) recordThread(req inferenceRequest) {
+	if req.ThreadID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	th, ok := s.threads[req.ThreadID]
+	switch {
+	case !ok:
+		if !req.CreateThread {
+			log.Warnf("继续的线程 %s 不存在，按新线程处理", req.ThreadID)
+		}
+		th = &Thread{ID: req.ThreadID, Alive: true, CreatedAt: time.Now()}
+		s.threads[req.ThreadID] = th
+	case !th.Alive:
+		log.Warnf("线程 %s 已归档", req.ThreadID)
+	}
+	// 回复也计入线程的消息数
+	th.Turns += req.turns() + 1
+}
+
+func (s *Server) saveTransactions(c *gin.Context) {
+	var req struct {
+		Transactions []struct {
+			Operations []struct {
+				Pointer struct {
+					Table string `json:"table"`
+					ID    string `json:"id"`
+				} `json:"pointer"`
+				Args map[string]interface{} `json:"args"`
+			} `json:"operations"`
+		} `json:"transactions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"name": "ValidationError", "message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	for _, tx := range req.Transactions {
+		for _, op := range tx.Operations {
+			if op.Pointer.Table != "thread" || op.Pointer.ID == "" {
+				continue
+			}
+			th, ok := s.threads[op.Pointer.ID]
+			if !ok {
+				th = &Thread{ID: op.Pointer.ID, Alive: true, CreatedAt: time.Now()}
+				s.threads[op.Pointer.ID] = th
+			}
+			if alive, ok := op.Args["alive"].(bool); ok {
+				th.Alive = alive
+				log.Infof("线程 %s 的 alive 设置为 %v", th.ID, alive)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) availableModels(c *gin.Context) {
+	models := s.script.Models
+	if len(models) == 0 {
+		for _, spec := range config.BuiltinModels() {
+			models = append(models, Model{Codename: spec.Codename, Name: spec.ID})
+		}
+	}
+
+	list := make([]gin.H, 0, len(models))
+	for _, m := range models {
+		list = append(list, gin.H{
+			"model":        m.Codename,
+			"modelMessage": m.Name,
+			"modelFamily":  m.Family,
+			"isDisabled":   m.Disabled,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"models": list})
+}
+
+// uploadFileURL 返回指向模拟服务自身的签名上传地址，上传的内容直接丢弃
+func (s *Server) uploadFileURL(c *gin.Context) {
+	id := uuid.New().String()
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":          "attachment:" + id,
+		"signedPutUrl": fmt.Sprintf("%s://%s/__mock/upload/%s", scheme, c.Request.Host, id),
+	})
+}
+
+// mockUserID 请求没有指定当前用户时返回的用户 ID
+const mockUserID = "00000000-0000-0000-0000-000000000001"
+
+// loadUserContent 返回请求头 x-notion-active-user-header 指定的用户，会话预热用它确认 Cookie 有效
+func (s *Server) loadUserContent(c *gin.Context) {
+	userID := c.GetHeader("x-notion-active-user-header")
+	if userID == "" {
+		userID = mockUserID
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"recordMap": gin.H{
+			"notion_user": gin.H{
+				userID: gin.H{
+					"role":  "reader",
+					"value": gin.H{"id": userID, "name": "Mock User", "email": "mock@example.com"},
+				},
+			},
+		},
+	})
+}
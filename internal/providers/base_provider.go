@@ -1,6 +1,10 @@
 package providers
 
-import "github.com/gin-gonic/gin"
+import (
+	"notion-2api-go/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
 
 // BaseProvider 定义了所有 AI Provider 必须实现的接口
 type BaseProvider interface {
@@ -10,22 +14,65 @@ type BaseProvider interface {
 	// ChatCompletionAnthropic 处理聊天补全请求 (Anthropic 格式响应)
 	ChatCompletionAnthropic(c *gin.Context, convertedData map[string]interface{}, originalData map[string]interface{}) error
 
-	// GetModels 获取可用模型列表
+	// GetModels 获取可用模型列表，带 anthropic-version 请求头时返回 Anthropic 格式
 	GetModels(c *gin.Context) error
+
+	// GetModel 获取单个模型的信息，支持别名
+	GetModel(c *gin.Context, id string) error
+
+	// ListModels 返回当前的模型列表及其可用状态
+	ListModels() []ModelInfo
+
+	// Readiness 返回上游账号的就绪状态
+	Readiness() ReadinessReport
+
+	// AbortInFlight 停机等待超时后中止进行中的推理，流式响应会收到错误事件后结束
+	AbortInFlight()
+}
+
+// IsAnthropicRequest 请求是否来自 Anthropic 客户端（如 Claude CLI），用于两种客户端共用的接口选择响应格式
+func IsAnthropicRequest(c *gin.Context) bool {
+	return c.GetHeader("anthropic-version") != ""
+}
+
+// UsageTokensKey provider 在 gin 上下文中累计本次请求估算 token 用量（输入 + 输出）的键
+const UsageTokensKey = "usage_tokens"
+
+// ModelPermissionKey 客户端 API Key 的模型权限检查（func(modelID string) bool）在 gin 上下文中的键，未设置时不限制。
+// 检查的是别名规则和未知模型回退之后实际使用的模型 ID。
+const ModelPermissionKey = "model_permission"
+
+// modelAllowed 客户端 API Key 是否可以使用解析后的模型
+func modelAllowed(c *gin.Context, model *config.ModelSpec) bool {
+	if value, ok := c.Get(ModelPermissionKey); ok {
+		return value.(func(string) bool)(model.ID)
+	}
+	return true
+}
+
+// addUsage 累计本次请求的 token 用量，供认证中间件计入 API Key 配额
+func addUsage(c *gin.Context, tokens int) {
+	c.Set(UsageTokensKey, c.GetInt(UsageTokensKey)+tokens)
 }
 
 // ChatMessage 聊天消息结构
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string       `json:"role"`
+	Content string       `json:"content"`
+	Images  []ImageInput `json:"images,omitempty"`
+}
+
+// ImageInput 消息中的图片（data: URL 或 http(s) URL）
+type ImageInput struct {
+	URL string `json:"url"`
 }
 
 // ChatRequest 聊天请求结构
 type ChatRequest struct {
-	Model          string        `json:"model"`
-	Messages       []ChatMessage `json:"messages"`
-	Stream         bool          `json:"stream"`
-	NotionBlockID  string        `json:"notion_block_id,omitempty"`
+	Model         string        `json:"model"`
+	Messages      []ChatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	NotionBlockID string        `json:"notion_block_id,omitempty"`
 }
 
 // ModelResponse 模型响应结构
@@ -40,4 +87,38 @@ type ModelInfo struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
-}
\ No newline at end of file
+	// NotionModel 对应的 Notion 模型代号
+	NotionModel string `json:"notion_model,omitempty"`
+	// DisplayName Notion 界面中的模型名称，来自模型发现
+	DisplayName string `json:"display_name,omitempty"`
+	// Available 工作区当前能否使用该模型，模型发现尚未成功时省略
+	Available *bool `json:"available,omitempty"`
+	// ContextWindow 上下文窗口，MaxOutputTokens 最大输出长度（token），未配置时省略
+	ContextWindow   int `json:"context_window,omitempty"`
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+	// Capabilities 模型能力，只有模型表中配置的模型才有
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+}
+
+// ModelCapabilities 模型支持的输入输出能力
+type ModelCapabilities struct {
+	Vision   bool `json:"vision"`
+	Tools    bool `json:"tools"`
+	Thinking bool `json:"thinking"`
+}
+
+// AnthropicModelResponse Anthropic 格式的模型列表
+type AnthropicModelResponse struct {
+	Data    []AnthropicModelInfo `json:"data"`
+	HasMore bool                 `json:"has_more"`
+	FirstID *string              `json:"first_id"`
+	LastID  *string              `json:"last_id"`
+}
+
+// AnthropicModelInfo Anthropic 格式的模型信息
+type AnthropicModelInfo struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/cassettes"
+	"notion-2api-go/internal/config"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cassetteTransport 按 CASSETTE_MODE 包装发送请求的 Transport：
+// record 模式录制每次推理的请求载荷和原始响应，replay 模式从录制文件回放推理响应，
+// 其他接口返回 cassettes.ErrNoCassette，不访问网络
+func cassetteTransport(cfg *config.Settings, transport http.RoundTripper) (http.RoundTripper, error) {
+	switch cfg.CassetteMode {
+	case "record":
+		log.Infof("录制模式: Notion 推理的请求和响应将保存到 %s", cfg.CassetteDir)
+		return cassettes.NewRecorder(transport, cfg.CassetteDir, cassetteScrubber(cfg.Accounts)), nil
+	case "replay":
+		player, err := cassettes.NewPlayer(cfg.CassetteDir, cassetteScrubber(cfg.Accounts))
+		if err != nil {
+			return nil, fmt.Errorf("加载录制文件失败: %v", err)
+		}
+		log.Infof("回放模式: 从 %s 的 %d 个录制文件回放 Notion 推理响应，不访问 Notion", cfg.CassetteDir, player.Len())
+		return player, nil
+	default:
+		return transport, nil
+	}
+}
+
+// cassetteScrubber 录制时隐藏各账号的 Cookie、用户和空间 ID、邮箱和名称，回放时按同样的规则处理请求中的消息
+func cassetteScrubber(accounts []config.NotionAccount) *cassettes.Scrubber {
+	secrets := make(map[string]string)
+	for _, account := range accounts {
+		secrets[account.Cookie] = "<cookie>"
+		secrets[account.UserID] = "<user-id>"
+		secrets[account.SpaceID] = "<space-id>"
+		secrets[account.UserEmail] = "<user-email>"
+		secrets[account.UserName] = "<user-name>"
+	}
+	return cassettes.NewScrubber(secrets)
+}
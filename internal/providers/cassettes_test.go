@@ -0,0 +1,136 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"notion-2api-go/internal/accounts"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/mocknotion"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cassetteTestConfig 请求 baseURL 上的 Notion 的最小配置
+func cassetteTestConfig(baseURL, mode, dir string) *config.Settings {
+	return &config.Settings{
+		NotionBaseURL:          baseURL,
+		NotionClientVersion:    "test",
+		APIRequestTimeout:      10,
+		DefaultModel:           "claude-sonnet-4.5",
+		SystemPromptMode:       "user",
+		AccountStrategy:        accounts.StrategyRoundRobin,
+		ConversationMode:       "off",
+		ThreadRetention:        -1,
+		UpstreamRetryBaseDelay: 1,
+		UpstreamRetryMaxDelay:  1,
+		CassetteMode:           mode,
+		CassetteDir:            dir,
+		Accounts: []config.NotionAccount{{
+			Name:      "default",
+			Cookie:    "mock-token-v2-cookie",
+			SpaceID:   "mock-space-0000-0000",
+			UserID:    "mock-user-0000-0000",
+			UserEmail: "tester@example.com",
+		}},
+	}
+}
+
+// completion 以非流式请求发送一条用户消息，返回状态码和回复内容
+func completion(t *testing.T, p *NotionAIProvider, message string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	p.ChatCompletion(c, map[string]interface{}{
+		"model":    "claude-sonnet-4.5",
+		"stream":   false,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": message}},
+	})
+
+	var body struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if len(body.Choices) == 0 {
+		return recorder.Code, ""
+	}
+	return recorder.Code, body.Choices[0].Message.Content
+}
+
+// useBuiltinModels 测试未加载配置时使用内置模型表
+func useBuiltinModels(t *testing.T) {
+	t.Helper()
+	if config.Models() != nil {
+		return
+	}
+	catalog, err := config.NewModelCatalog(config.BuiltinModels(), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetModels(catalog)
+}
+
+func TestRecordAndReplayAgainstMockNotion(t *testing.T) {
+	useBuiltinModels(t)
+	dir := t.TempDir()
+	message := "请转告 tester@example.com 明天开会"
+
+	// 录制：请求模拟 Notion 服务，回复为回显的用户消息
+	server := httptest.NewServer(mocknotion.New(nil).Handler())
+	recording, err := NewNotionAIProvider(cassetteTestConfig(server.URL, "record", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, recorded := completion(t, recording, message)
+	recording.Stop()
+	server.Close()
+	if status != http.StatusOK || !strings.Contains(recorded, "明天开会") {
+		t.Fatalf("recorded completion = %d %q", status, recorded)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("recorded %d cassettes, want 1", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, secret := range []string{"tester@example.com", "mock-token-v2-cookie", "mock-space-0000-0000", "mock-user-0000-0000"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q", secret)
+		}
+	}
+
+	// 回放：模拟服务已关闭，推理响应只能来自录制文件
+	replaying, err := NewNotionAIProvider(cassetteTestConfig(server.URL, "replay", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replaying.Stop()
+
+	tests := []struct {
+		name    string
+		message string
+		status  int
+		content string
+	}{
+		// 录制的响应已脱敏，回放的回复中邮箱为占位符
+		{"相同的消息", message, http.StatusOK, strings.ReplaceAll(recorded, "tester@example.com", "<user-email>")},
+		{"未录制的消息", "没有录制过的问题", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, content := completion(t, replaying, tt.message)
+			if status != tt.status || content != tt.content {
+				t.Errorf("replayed completion = %d %q, want %d %q", status, content, tt.status, tt.content)
+			}
+		})
+	}
+}
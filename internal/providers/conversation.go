@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"notion-2api-go/internal/conversations"
+	"notion-2api-go/internal/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对话相关的请求头
+const (
+	// HeaderConversationID 客户端指定的对话 ID，同一对话的请求复用同一个 Notion 线程
+	HeaderConversationID = "X-Conversation-ID"
+	// headerNotionThread 响应头，返回本次请求使用的 Notion 线程 ID
+	headerNotionThread = "X-Notion-Thread-ID"
+)
+
+// SetConversationStore 设置对话与 Notion 线程的对应关系存储，未设置时每次请求都创建新线程
+func (p *NotionAIProvider) SetConversationStore(store *conversations.Store) {
+	p.conversations = store
+}
+
+// conversation 一次请求对应的对话：记录要继续的已有线程，以及构建载荷时实际使用的线程和账号
+type conversation struct {
+	store *conversations.Store
+	// key 请求成功后保存对应关系使用的键，为空表示不复用线程
+	key string
+	// lookup 查找已有线程使用的键，auto 模式下与 key 不同
+	lookup   string
+	existing *conversations.Conversation
+	messages []ChatMessage
+
+	threadID  string
+	account   string
+	continued bool
+	createdAt time.Time
+}
+
+// conversationFor 按 CONVERSATION_MODE 确定请求所属的对话，并查找可以继续的线程：
+// header 模式使用 X-Conversation-ID；user 模式没有该请求头时使用请求中的 user 字段；
+// auto 模式没有该请求头时使用消息历史的指纹，客户端带着上一次的回复继续对话时即可找到对应线程。
+// 不同 API Key 的对话互不可见。
+func (p *NotionAIProvider) conversationFor(c *gin.Context, requestData map[string]interface{}, messages []ChatMessage) *conversation {
+	conv := &conversation{messages: messages}
+	if p.conversations == nil || p.config.ConversationMode == "off" {
+		return conv
+	}
+
+	namespace := fingerprint(clientKey(c))[:16] + ":"
+	id := c.GetHeader(HeaderConversationID)
+	if id == "" && p.config.ConversationMode == "user" {
+		id, _ = requestData["user"].(string)
+	}
+
+	switch {
+	case id != "":
+		conv.key = namespace + "id:" + id
+		conv.lookup = conv.key
+	case p.config.ConversationMode == "auto":
+		conv.key = namespace + "auto:" + historyFingerprint(append(messages[:len(messages):len(messages)], ChatMessage{Role: "assistant"}))
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "assistant" {
+				conv.lookup = namespace + "auto:" + historyFingerprint(messages[:i+1])
+				break
+			}
+		}
+	default:
+		return conv
+	}
+	conv.store = p.conversations
+
+	if conv.lookup == "" {
+		return conv
+	}
+	existing, ok := conv.store.Peek(conv.lookup)
+	if !ok {
+		return conv
+	}
+	// 线程中的消息必须是本次请求历史的前缀，且以模型的回复结尾，否则（例如客户端编辑或重新生成了消息）改用新线程
+	if existing.Turns < 1 || existing.Turns >= len(messages) || messages[existing.Turns-1].Role != "assistant" ||
+		historyFingerprint(messages[:existing.Turns]) != existing.Fingerprint {
+		logging.FromContext(c.Request.Context()).Infof("对话 %s 的历史与线程 %s 不一致，将使用新线程", conv.lookup, existing.ThreadID)
+		return conv
+	}
+	conv.existing = &existing
+	return conv
+}
+
+// preferredAccount 继续已有线程时必须使用创建线程的账号
+func (conv *conversation) preferredAccount() string {
+	if conv.existing == nil {
+		return ""
+	}
+	return conv.existing.Account
+}
+
+// begin 为指定账号确定本次尝试使用的线程，返回线程 ID 和需要发送的消息。
+// 账号与已有线程一致时取出该对话独占线程，只发送新的消息；否则创建新线程并发送完整历史。
+func (conv *conversation) begin(account string, newThreadID func() string) (string, []ChatMessage) {
+	conv.account = account
+	conv.continued = false
+	if conv.existing != nil && conv.existing.Account == account {
+		existing := conv.existing
+		// 只在第一次尝试时取出，之后的尝试（换了账号）都使用新线程
+		conv.existing = nil
+		if taken, ok := conv.store.Take(conv.lookup); ok && taken.ThreadID == existing.ThreadID {
+			conv.threadID = taken.ThreadID
+			conv.continued = true
+			conv.createdAt = taken.CreatedAt
+			return conv.threadID, conv.messages[taken.Turns:]
+		}
+	}
+	conv.threadID = newThreadID()
+	conv.createdAt = time.Time{}
+	return conv.threadID, conv.messages
+}
+
+// commit 请求成功后保存对话与线程的对应关系，线程中的消息数包括模型刚刚给出的回复
+func (conv *conversation) commit() {
+	if conv.store == nil || conv.key == "" || conv.threadID == "" {
+		return
+	}
+	if conv.lookup != "" && conv.lookup != conv.key {
+		conv.store.Delete(conv.lookup)
+	}
+	history := append(conv.messages[:len(conv.messages):len(conv.messages)], ChatMessage{Role: "assistant"})
+	conv.store.Put(conversations.Conversation{
+		Key:         conv.key,
+		ThreadID:    conv.threadID,
+		Account:     conv.account,
+		Turns:       len(history),
+		Fingerprint: historyFingerprint(history),
+		CreatedAt:   conv.createdAt,
+	})
+}
+
+// historyFingerprint 对话历史的指纹。模型回复只计入角色：
+// 客户端发回的回复经过了清洗和工具调用转换，与线程中保存的原始回复不一定相同
+func historyFingerprint(messages []ChatMessage) string {
+	h := sha256.New()
+	for _, msg := range messages {
+		h.Write([]byte(msg.Role))
+		h.Write([]byte{0})
+		if msg.Role != "assistant" {
+			h.Write([]byte(msg.Content))
+			h.Write([]byte{0})
+			for _, image := range msg.Images {
+				h.Write([]byte(image.URL))
+				h.Write([]byte{0})
+			}
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprint 字符串的 sha256 十六进制摘要
+func fingerprint(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,223 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/config"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DiscoveredModel Notion 返回的工作区模型
+type DiscoveredModel struct {
+	// Codename Notion 内部的模型代号
+	Codename string
+	// DisplayName Notion 界面中显示的名称
+	DisplayName string
+	// Family 模型系列，如 anthropic / openai / gemini
+	Family string
+	// Disabled 工作区当前不能使用该模型（例如套餐不包含）
+	Disabled bool
+	// FirstSeen 第一次发现该模型的时间，由 provider 记录
+	FirstSeen time.Time
+}
+
+// ModelDiscoverer 查询工作区当前可以使用的 Notion 模型。
+// 默认实现走 Notion 的 getAvailableModels 接口，可替换为本地测试桩。
+type ModelDiscoverer interface {
+	Discover(ctx context.Context, account *config.NotionAccount) ([]DiscoveredModel, error)
+}
+
+// notionModelDiscoverer 通过 Notion 的 getAvailableModels 接口查询可用模型
+type notionModelDiscoverer struct {
+	client   *http.Client
+	endpoint string
+	headers  func(account *config.NotionAccount) map[string]string
+}
+
+// NewNotionModelDiscoverer 创建使用 Notion 接口的 ModelDiscoverer，
+// endpoint 为 getAvailableModels 接口地址，headers 返回以指定账号请求 Notion 时使用的请求头
+func NewNotionModelDiscoverer(client *http.Client, endpoint string, headers func(account *config.NotionAccount) map[string]string) ModelDiscoverer {
+	return &notionModelDiscoverer{
+		client:   client,
+		endpoint: endpoint,
+		headers:  headers,
+	}
+}
+
+// Discover 查询账号所在工作区的模型列表
+func (d *notionModelDiscoverer) Discover(ctx context.Context, account *config.NotionAccount) ([]DiscoveredModel, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{"spaceId": account.SpaceID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range d.headers(account) {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("查询可用模型失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("查询可用模型失败，状态码: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Models []struct {
+			Model        string `json:"model"`
+			ModelMessage string `json:"modelMessage"`
+			ModelFamily  string `json:"modelFamily"`
+			IsDisabled   bool   `json:"isDisabled"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析可用模型失败: %v", err)
+	}
+
+	models := make([]DiscoveredModel, 0, len(result.Models))
+	for _, m := range result.Models {
+		if m.Model == "" {
+			continue
+		}
+		models = append(models, DiscoveredModel{
+			Codename:    m.Model,
+			DisplayName: m.ModelMessage,
+			Family:      m.ModelFamily,
+			Disabled:    m.IsDisabled,
+		})
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("Notion 未返回任何模型")
+	}
+	return models, nil
+}
+
+// modelDiscovery 最近一次成功的模型发现结果，查询失败时保留上一次的结果
+type modelDiscovery struct {
+	mu         sync.RWMutex
+	discoverer ModelDiscoverer
+	models     map[string]DiscoveredModel
+	order      []string
+	updatedAt  time.Time
+}
+
+// SetModelDiscoverer 替换查询可用模型的实现（例如指向本地测试桩），并立即重新查询
+func (p *NotionAIProvider) SetModelDiscoverer(discoverer ModelDiscoverer) {
+	p.discovery.mu.Lock()
+	p.discovery.discoverer = discoverer
+	p.discovery.mu.Unlock()
+	p.discoverModels(p.backgroundContext())
+}
+
+// discoveryLoop 启动时立即查询一次可用模型，之后每隔 interval 刷新，ctx 取消时退出
+func (p *NotionAIProvider) discoveryLoop(ctx context.Context, interval time.Duration) {
+	p.discoverModels(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.discoverModels(ctx)
+		}
+	}
+}
+
+// discoverModels 依次用账号池中的账号查询可用模型，任一账号成功即可
+func (p *NotionAIProvider) discoverModels(ctx context.Context) {
+	p.discovery.mu.RLock()
+	discoverer := p.discovery.discoverer
+	p.discovery.mu.RUnlock()
+
+	for _, account := range p.accounts.Accounts() {
+		// 超时由 http.Client 的 API_REQUEST_TIMEOUT 控制
+		models, err := discoverer.Discover(ctx, &account.NotionAccount)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("账号 %s 查询可用模型失败: %v", account.Name, err)
+			continue
+		}
+
+		now := time.Now()
+		p.discovery.mu.Lock()
+		previous := p.discovery.models
+		p.discovery.models = make(map[string]DiscoveredModel, len(models))
+		p.discovery.order = nil
+		for _, m := range models {
+			if _, ok := p.discovery.models[m.Codename]; !ok {
+				p.discovery.order = append(p.discovery.order, m.Codename)
+			}
+			m.FirstSeen = now
+			if old, ok := previous[m.Codename]; ok {
+				m.FirstSeen = old.FirstSeen
+			}
+			p.discovery.models[m.Codename] = m
+		}
+		p.discovery.updatedAt = now
+		p.discovery.mu.Unlock()
+
+		catalog := config.Models()
+		var unconfigured []string
+		for _, m := range models {
+			if !catalog.HasCodename(m.Codename) {
+				unconfigured = append(unconfigured, m.Codename)
+			}
+		}
+		log.Infof("模型发现完成: 工作区共有 %d 个模型，未在模型表中配置的: %v", len(models), unconfigured)
+		return
+	}
+	log.Warn("所有账号查询可用模型均失败，继续使用上一次的结果")
+}
+
+// discoveredModel 返回模型发现的结果，known 为 false 表示尚未成功查询过
+func (p *NotionAIProvider) discoveredModel(codename string) (model DiscoveredModel, found bool, known bool) {
+	p.discovery.mu.RLock()
+	defer p.discovery.mu.RUnlock()
+
+	if p.discovery.updatedAt.IsZero() {
+		return DiscoveredModel{}, false, false
+	}
+	model, found = p.discovery.models[codename]
+	return model, found, true
+}
+
+// unconfiguredModels 返回工作区可用但没有在模型表中配置的模型，可以直接用代号请求
+func (p *NotionAIProvider) unconfiguredModels(catalog *config.ModelCatalog) []DiscoveredModel {
+	p.discovery.mu.RLock()
+	defer p.discovery.mu.RUnlock()
+
+	var list []DiscoveredModel
+	for _, codename := range p.discovery.order {
+		if !catalog.HasCodename(codename) {
+			list = append(list, p.discovery.models[codename])
+		}
+	}
+	return list
+}
+
+// discoveredSpec 把工作区中可用但未配置的模型作为以代号命名的模型使用
+func (p *NotionAIProvider) discoveredSpec(name string) (*config.ModelSpec, bool) {
+	model, found, _ := p.discoveredModel(name)
+	if !found || model.Disabled || config.Models().HasCodename(name) {
+		return nil, false
+	}
+	return &config.ModelSpec{ID: name, Codename: name, ThreadType: config.DefaultThreadType(name)}, true
+}
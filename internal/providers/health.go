@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"notion-2api-go/internal/accounts"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rewarmInterval 重新预热失败账号的间隔，避免启动时的网络抖动让实例一直无法就绪
+const rewarmInterval = time.Minute
+
+// ReadinessCheck 就绪检查中的一项
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// AccountReadiness 单个账号的就绪状态
+type AccountReadiness struct {
+	Name                string     `json:"name"`
+	Ready               bool       `json:"ready"`
+	Available           bool       `json:"available"`
+	CooldownSeconds     int        `json:"cooldown_seconds,omitempty"`
+	InFlight            int        `json:"in_flight"`
+	WarmupOK            bool       `json:"warmup_ok"`
+	WarmupAt            *time.Time `json:"warmup_at,omitempty"`
+	WarmupError         string     `json:"warmup_error,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	CircuitOpen         bool       `json:"circuit_open"`
+}
+
+// ReadinessReport 就绪检查结果，至少有一个账号就绪时实例可以接收流量
+type ReadinessReport struct {
+	Ready    bool               `json:"ready"`
+	Checks   []ReadinessCheck   `json:"checks"`
+	Accounts []AccountReadiness `json:"accounts"`
+}
+
+// Readiness 根据账号池的预热结果、推理结果和额度状态判断实例是否就绪
+func (p *NotionAIProvider) Readiness() ReadinessReport {
+	report := ReadinessReport{Accounts: []AccountReadiness{}}
+	var warmedUp, withQuota, reachable int
+
+	statuses := p.accounts.Status()
+	for _, status := range statuses {
+		account := AccountReadiness{
+			Name:                status.Name,
+			Ready:               status.Healthy,
+			Available:           status.Available,
+			CooldownSeconds:     int(status.Cooldown.Seconds()),
+			InFlight:            status.InFlight,
+			WarmupOK:            !status.WarmupAt.IsZero() && status.WarmupError == "",
+			WarmupAt:            timePtr(status.WarmupAt),
+			WarmupError:         status.WarmupError,
+			LastSuccessAt:       timePtr(status.LastSuccess),
+			LastFailureAt:       timePtr(status.LastFailure),
+			LastError:           status.LastError,
+			ConsecutiveFailures: status.ConsecutiveFailures,
+			CircuitOpen:         status.CircuitOpen,
+		}
+		if account.WarmupOK || account.LastSuccessAt != nil {
+			warmedUp++
+		}
+		if account.Available {
+			withQuota++
+		}
+		if status.ConsecutiveFailures < accounts.UnhealthyAfter {
+			reachable++
+		}
+		if account.Ready {
+			report.Ready = true
+		}
+		report.Accounts = append(report.Accounts, account)
+	}
+
+	total := len(statuses)
+	report.Checks = []ReadinessCheck{
+		countCheck("warmup", warmedUp, total, "个账号会话预热成功或已成功推理"),
+		countCheck("quota", withQuota, total, "个账号仍有额度"),
+		countCheck("upstream", reachable, total, "个账号没有连续推理失败"),
+		countCheck("accounts", countReady(report.Accounts), total, "个账号可以接收请求"),
+	}
+	return report
+}
+
+func countCheck(name string, ok, total int, message string) ReadinessCheck {
+	return ReadinessCheck{
+		Name:    name,
+		OK:      ok > 0,
+		Message: fmt.Sprintf("%d/%d %s", ok, total, message),
+	}
+}
+
+func countReady(list []AccountReadiness) int {
+	n := 0
+	for _, account := range list {
+		if account.Ready {
+			n++
+		}
+	}
+	return n
+}
+
+// rewarmLoop 定期重新预热失败的账号，预热成功或成功推理后不再重试
+func (p *NotionAIProvider) rewarmLoop(ctx context.Context) {
+	ticker := time.NewTicker(rewarmInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, account := range p.accounts.Accounts() {
+			if ctx.Err() != nil {
+				return
+			}
+			if !p.accounts.NeedsWarmup(account) {
+				continue
+			}
+			log.Infof("账号 %s 之前预热失败，重新预热", account.Name)
+			p.accounts.RecordWarmup(account, p.warmupSession(ctx, &account.NotionAccount))
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"notion-2api-go/internal/accounts"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWarmupSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		userID string
+		ok     bool
+	}{
+		{"有效会话", http.StatusOK, `{"recordMap":{"notion_user":{"u1":{"value":{"id":"u1"}}}}}`, "u1", true},
+		{"未配置用户 ID", http.StatusOK, `{"recordMap":{"notion_user":{"u1":{"value":{"id":"u1"}}}}}`, "", true},
+		{"Cookie 过期", http.StatusUnauthorized, `{}`, "u1", false},
+		{"无权限", http.StatusForbidden, `{}`, "u1", false},
+		{"没有用户信息", http.StatusOK, `{"recordMap":{}}`, "u1", false},
+		{"用户不一致", http.StatusOK, `{"recordMap":{"notion_user":{"u2":{"value":{"id":"u2"}}}}}`, "u1", false},
+		{"上游错误", http.StatusBadGateway, ``, "u1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/api/v3/loadUserContent" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			p := &NotionAIProvider{
+				client:       server.Client(),
+				config:       &config.Settings{},
+				apiEndpoints: map[string]string{"loadUserContent": server.URL + "/api/v3/loadUserContent"},
+			}
+			err := p.warmupSession(context.Background(), &config.NotionAccount{Name: "test", UserID: tt.userID})
+			if (err == nil) != tt.ok {
+				t.Fatalf("warmupSession() error = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAccountHealthMetrics(t *testing.T) {
+	pool, err := accounts.NewPool([]config.NotionAccount{{Name: "a", Cookie: "c", SpaceID: "s", UserID: "u"}}, accounts.StrategyRoundRobin, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetCircuitBreaker(2, time.Minute)
+	registerAccountMetrics(pool)
+	account := pool.Accounts()[0]
+
+	tests := []struct {
+		name        string
+		event       func()
+		healthy     float64
+		circuitOpen float64
+	}{
+		{"尚未验证", func() {}, 0, 0},
+		{"预热成功", func() { pool.RecordWarmup(account, nil) }, 1, 0},
+		{"一次失败", func() { pool.RecordFailure(account, errors.New("503")) }, 1, 0},
+		{"连续失败后熔断", func() { pool.RecordFailure(account, errors.New("503")) }, 0, 1},
+		{"试探成功", func() { pool.RecordSuccess(account) }, 1, 0},
+	}
+	for _, tt := range tests {
+		tt.event()
+		if got := testutil.ToFloat64(metrics.AccountHealthy); got != tt.healthy {
+			t.Errorf("%s: notion2api_account_healthy = %v, want %v", tt.name, got, tt.healthy)
+		}
+		if got := testutil.ToFloat64(metrics.AccountCircuitOpen); got != tt.circuitOpen {
+			t.Errorf("%s: notion2api_account_circuit_open = %v, want %v", tt.name, got, tt.circuitOpen)
+		}
+	}
+}
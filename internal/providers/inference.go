@@ -0,0 +1,415 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"notion-2api-go/internal/accounts"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/logging"
+	"notion-2api-go/internal/metrics"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPeekLines 发起推理后最多预读的行数。
+// 额度用尽的错误事件会出现在任何内容之前，预读这些行即可在向客户端输出前切换账号重试。
+const maxPeekLines = 32
+
+// errShuttingDown 服务停机等待超时，进行中的推理被中止
+var errShuttingDown = errors.New("服务正在关闭，推理已中止，请重试")
+
+// payloadBuilder 为指定账号构建推理请求载荷，每次尝试都会重新调用
+type payloadBuilder func(account *config.NotionAccount) (map[string]interface{}, error)
+
+// upstreamStatusError Notion 返回了非 200 状态码
+type upstreamStatusError struct {
+	StatusCode int
+	// Message Notion 在错误响应中给出的原因
+	Message string
+	// RetryAfter Notion 通过 Retry-After 头要求的等待时间
+	RetryAfter time.Duration
+}
+
+func (e *upstreamStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("Notion AI 返回错误状态码: %d (%s)", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("Notion AI 返回错误状态码: %d", e.StatusCode)
+}
+
+// inference 一次已经开始返回内容的 Notion 推理
+type inference struct {
+	p       *NotionAIProvider
+	resp    *http.Response
+	body    io.Reader
+	account *accounts.Account
+	// model/start 用于上游耗时指标
+	model string
+	start time.Time
+
+	// buffered 为 true 时响应已在发起推理时完整读取，read 直接返回结果
+	buffered  bool
+	collector *inferenceCollector
+	readErr   error
+}
+
+// read 读取推理结果，推理途中遇到额度用尽时同样标记账号。
+// 停机时调用了 AbortInFlight 会关闭上游响应让读取立即结束，并返回 errShuttingDown。
+func (inf *inference) read(ctx context.Context, onIncremental func(string)) (*inferenceCollector, error) {
+	if inf.buffered {
+		return inf.collector, inf.readErr
+	}
+
+	abort := inf.p.abortContext()
+	stop := context.AfterFunc(abort, func() { inf.resp.Body.Close() })
+	defer stop()
+
+	collector, err := inf.p.readInference(ctx, inf.body, onIncremental)
+	if abort.Err() != nil && ctx.Err() == nil {
+		return collector, errShuttingDown
+	}
+	if err != nil && ctx.Err() != nil {
+		inf.p.inferenceCancelled(ctx, inf.model, "stream", inf.start, collector)
+	}
+	if nerr, ok := err.(*notionError); ok && nerr.Quota {
+		inf.p.accounts.MarkExhausted(inf.account, nerr.Current, nerr.Total)
+	}
+	return collector, err
+}
+
+// Close 关闭响应并归还账号
+func (inf *inference) Close() {
+	metrics.UpstreamDuration.WithLabelValues(inf.model, inf.account.Name).Observe(time.Since(inf.start).Seconds())
+	inf.resp.Body.Close()
+	inf.p.accounts.Release(inf.account)
+}
+
+// AbortInFlight 中止所有进行中和之后发起的推理，停机等待超时后调用
+func (p *NotionAIProvider) AbortInFlight() {
+	p.abortContext()
+	p.abortCancel()
+}
+
+// abortContext 返回停机中止信号，首次调用时创建
+func (p *NotionAIProvider) abortContext() context.Context {
+	p.abortOnce.Do(func() {
+		p.abort, p.abortCancel = context.WithCancel(context.Background())
+	})
+	return p.abort
+}
+
+// startInference 从账号池选择账号发起推理，preferred 账号可用时优先使用。
+// 账号额度用尽时将其标记为不可用，并在向客户端输出任何内容之前换下一个账号重试；
+// 连接错误、429、502–504 和没有任何内容的响应按 UPSTREAM_MAX_RETRIES 退避重试，其他 4xx 直接返回。
+// buffered 为 true 时（非流式请求）完整读取响应后才返回。
+func (p *NotionAIProvider) startInference(c *gin.Context, model, preferred string, buffered bool, build payloadBuilder) (*inference, error) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+	key := clientKey(c)
+	tried := make(map[string]bool)
+	var lastErr error
+	retries := 0
+	start := time.Now()
+
+	for {
+		account, err := p.accounts.AcquirePreferred(preferred, key, tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		inf, err := p.tryInference(ctx, account, model, buffered, build)
+		if err == nil {
+			p.accounts.RecordSuccess(account)
+			return inf, nil
+		}
+		p.accounts.Release(account)
+		if ctx.Err() != nil {
+			p.inferenceCancelled(ctx, model, "connect", start, nil)
+			return nil, err
+		}
+		reason := upstreamErrorReason(err)
+		metrics.UpstreamErrors.WithLabelValues(account.Name, reason).Inc()
+		if breakerFailure(err) {
+			p.accounts.RecordFailure(account, err)
+		}
+		lastErr = err
+
+		if nerr, ok := err.(*notionError); ok && nerr.Quota {
+			tried[account.Name] = true
+			p.accounts.MarkExhausted(account, nerr.Current, nerr.Total)
+			logger.Warnf("账号 %s 额度已用尽，尝试使用下一个账号", account.Name)
+			continue
+		}
+		if !retryableError(err) || retries >= p.config.UpstreamMaxRetries {
+			return nil, err
+		}
+
+		retries++
+		delay := p.retryDelay(retries, err)
+		metrics.UpstreamRetries.WithLabelValues(reason).Inc()
+		logger.Warnf("账号 %s 发起推理失败: %v，%s 后第 %d 次重试", account.Name, err, delay, retries)
+		if werr := p.waitRetry(ctx, delay); werr != nil {
+			if ctx.Err() != nil {
+				p.inferenceCancelled(ctx, model, "retry_wait", start, nil)
+			}
+			return nil, werr
+		}
+	}
+}
+
+// inferenceCancelled 记录因客户端断开而取消的推理及其进度，所有取消路径都经过这里。
+// stage 为 connect（收到第一个内容事件之前）、retry_wait（等待重试时）或 stream（读取响应途中）；
+// collector 为已读取的内容，尚未开始读取时为 nil。
+func (p *NotionAIProvider) inferenceCancelled(ctx context.Context, model, stage string, start time.Time, collector *inferenceCollector) {
+	metrics.InferenceCancelled.WithLabelValues(model, stage).Inc()
+
+	fields := log.Fields{
+		"model":      model,
+		"stage":      stage,
+		"elapsed_ms": time.Since(start).Milliseconds(),
+	}
+	if collector != nil {
+		fields["lines_read"] = collector.linesRead
+		fields["bytes_read"] = collector.bytesRead
+		fields["fragments"] = len(collector.incrementalFragments)
+		fields["fragment_bytes"] = collector.fragmentBytes
+		fields["got_final"] = collector.finalMessage != ""
+	}
+	logging.FromContext(ctx).WithFields(fields).Warn("客户端已断开，已取消 Notion AI 推理")
+}
+
+// tryInference 使用指定账号发起一次推理，并预读响应开头以发现额度错误
+func (p *NotionAIProvider) tryInference(ctx context.Context, account *accounts.Account, model string, buffered bool, build payloadBuilder) (*inference, error) {
+	logger := logging.FromContext(ctx)
+	payload, err := build(&account.NotionAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	logger.Infof("请求 Notion AI URL: %s (账号: %s)", p.apiEndpoints["runInference"], account.Name)
+	logger.Debugf("请求体: %s", logging.Content(string(jsonData)))
+
+	// 绑定客户端请求的 context，客户端断开时中止上游推理
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiEndpoints["runInference"], bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	for key, value := range p.prepareHeaders(&account.NotionAccount) {
+		req.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 Notion AI 失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		logger.Errorf("Notion AI 返回错误，状态码: %d, 响应: %s", resp.StatusCode, logging.Content(string(bodyBytes)))
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(bodyBytes, &body)
+		return nil, &upstreamStatusError{StatusCode: resp.StatusCode, Message: body.Message, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	body, err := p.peekInference(ctx, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	metrics.UpstreamTTFB.WithLabelValues(model, account.Name).Observe(time.Since(start).Seconds())
+	inf := &inference{p: p, resp: resp, body: body, account: account, model: model, start: start}
+	if buffered {
+		// 预读时已经收到内容事件，模型已经完成了生成，即使清洗后没有内容也不再重试；
+		// 读取途中连接断开时还没有向客户端输出任何内容，返回错误由 startInference 退避后换账号重试
+		collector, err := inf.read(ctx, nil)
+		if err != nil && retryableError(err) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("读取 Notion 响应失败: %w", err)
+		}
+		inf.buffered, inf.collector, inf.readErr = true, collector, err
+	}
+	return inf, nil
+}
+
+// peekInference 预读响应直到第一个内容事件，遇到额度错误时返回 *notionError，
+// 响应流在任何内容事件之前结束时返回 errEmptyResponse。
+// 返回的 Reader 包含已预读的行，后续仍交给 readInference 完整解析。
+func (p *NotionAIProvider) peekInference(ctx context.Context, body io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(body)
+	var peeked bytes.Buffer
+
+	for i := 0; i < maxPeekLines; i++ {
+		line, err := reader.ReadBytes('\n')
+		peeked.Write(line)
+
+		var event struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(bytes.TrimSpace(line), &event) == nil {
+			switch event.Type {
+			case "premium-feature-unavailable":
+				for _, result := range p.parseNDJSONLine(ctx, string(line)) {
+					if result["type"] == "error" {
+						return nil, newNotionError(result)
+					}
+				}
+			case "patch", "markdown-chat", "record-map":
+				return io.MultiReader(&peeked, reader), nil
+			}
+		}
+
+		if err == io.EOF {
+			return nil, errEmptyResponse
+		}
+		if err != nil {
+			// 读取出错，交给 readInference 处理
+			break
+		}
+	}
+	return io.MultiReader(&peeked, reader), nil
+}
+
+// inferenceErrorStatus 将发起推理时的错误映射为返回给客户端的状态码
+func inferenceErrorStatus(err error) int {
+	var invalid *invalidRequestError
+	var nerr *notionError
+	var status *upstreamStatusError
+	switch {
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	case errors.As(err, &nerr), errors.Is(err, accounts.ErrNoAvailableAccount):
+		return http.StatusPaymentRequired
+	case errors.Is(err, accounts.ErrCircuitOpen), errors.Is(err, errShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &status):
+		return upstreamStatus(status.StatusCode)
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// upstreamStatus Notion 返回的错误状态码对应返回给客户端的状态码：
+// 请求本身的 4xx 原样返回，账号认证失败（401/403）和 5xx 返回 502
+func upstreamStatus(code int) int {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden, code >= http.StatusInternalServerError:
+		return http.StatusBadGateway
+	case code >= http.StatusBadRequest:
+		return code
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// upstreamErrorReason 发起推理失败的原因分类，用于 notion2api_upstream_errors_total 的 reason 标签
+func upstreamErrorReason(err error) string {
+	var nerr *notionError
+	var status *upstreamStatusError
+	var invalid *invalidRequestError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &nerr) && nerr.Quota:
+		return "quota"
+	case errors.As(err, &status):
+		return "status"
+	case errors.As(err, &invalid):
+		return "invalid_request"
+	case errors.Is(err, errEmptyResponse):
+		return "empty"
+	case errors.As(err, &urlErr), connectionBroken(err):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// clientKey 返回客户端使用的 API Key，用于 sticky 策略固定账号
+func clientKey(c *gin.Context) string {
+	if key := c.GetHeader("x-api-key"); key != "" {
+		return key
+	}
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// payloadFor 返回为每个账号构建推理载荷的函数。
+// 继续对话时沿用对话的线程，只发送新的消息；否则每次尝试都生成新的 thread ID 让 Notion 自动创建线程。
+// 每次尝试使用的线程在构建载荷时即被记录，请求结束后由 releaseThreads 按保留时间归档。
+func (p *NotionAIProvider) payloadFor(c *gin.Context, conv *conversation, images *imageSet, model *config.ModelSpec) payloadBuilder {
+	ctx := c.Request.Context()
+	return func(account *config.NotionAccount) (map[string]interface{}, error) {
+		threadID, messages := conv.begin(account.Name, func() string { return uuid.New().String() })
+		p.trackThread(c, account.Name, threadID)
+		payload, err := p.preparePayload(ctx, account, messages, images, threadID, model)
+		if err != nil {
+			return nil, err
+		}
+		if conv.continued {
+			logging.FromContext(ctx).Infof("继续对话线程 %s，发送 %d 条新消息", threadID, len(messages))
+			payload["createThread"] = false
+			payload["isPartialTranscript"] = true
+			payload["generateTitle"] = false
+		} else {
+			payload["createThread"] = true
+		}
+		return payload, nil
+	}
+}
+
+// openAIErrorType 状态码对应的 OpenAI 错误类型
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusPaymentRequired:
+		return "insufficient_quota"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusServiceUnavailable:
+		return "service_unavailable"
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError:
+		return "invalid_request_error"
+	default:
+		return "upstream_error"
+	}
+}
+
+// anthropicErrorType 状态码对应的 Anthropic 错误类型
+func anthropicErrorType(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusServiceUnavailable:
+		return "overloaded_error"
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError && status != http.StatusPaymentRequired:
+		return "invalid_request_error"
+	default:
+		return "api_error"
+	}
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/metrics"
+	"notion-2api-go/internal/mocknotion"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testPayload 模拟 Notion 可以接受的最小推理载荷
+func testPayload(*config.NotionAccount) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"threadId": "thread-cancel",
+		"transcript": []interface{}{
+			map[string]interface{}{"type": "config", "value": map[string]interface{}{"model": "cancel-model"}},
+			map[string]interface{}{"type": "user", "value": []interface{}{[]interface{}{"你好"}}},
+		},
+	}, nil
+}
+
+func TestInferenceCancelledStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario mocknotion.Scenario
+		// cancel 在何时取消请求：start 发起前，after 发起后经过一段时间，fragment 收到第一个片段时
+		cancel string
+		stage  string
+	}{
+		{"发起前", mocknotion.Scenario{}, "start", "connect"},
+		{"等待重试", mocknotion.Scenario{Status: http.StatusServiceUnavailable}, "after", "retry_wait"},
+		{"读取途中", mocknotion.Scenario{Reply: "一段很长的回复内容", ChunkSize: 2, Delay: 200}, "fragment", "stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(mocknotion.New(&mocknotion.Script{Scenarios: []mocknotion.Scenario{tt.scenario}}).Handler())
+			defer server.Close()
+			cfg := cassetteTestConfig(server.URL, "off", "")
+			cfg.UpstreamMaxRetries = 1
+			cfg.UpstreamRetryBaseDelay = 10000
+			cfg.UpstreamRetryMaxDelay = 10000
+			cfg.ModelDiscoveryInterval = 0
+			p, err := NewNotionAIProvider(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Stop()
+
+			counter := metrics.InferenceCancelled.WithLabelValues("cancel-model", tt.stage)
+			before := testutil.ToFloat64(counter)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(ctx)
+			switch tt.cancel {
+			case "start":
+				cancel()
+			case "after":
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			inf, err := p.startInference(c, "cancel-model", "", false, testPayload)
+			if err == nil {
+				_, err = inf.read(ctx, func(string) { cancel() })
+				inf.Close()
+			}
+			if err == nil {
+				t.Fatal("inference finished without being cancelled")
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("notion2api_inference_cancelled_total{stage=%q} increased by %v, want 1", tt.stage, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/logging"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// 系统提示词处理方式
+const (
+	// SystemPromptModeUser 作为带标记的独立用户消息放在对话最前面
+	SystemPromptModeUser = "user"
+	// SystemPromptModeMerge 合并到第一条用户消息的开头
+	SystemPromptModeMerge = "merge"
+	// SystemPromptModeDrop 丢弃（旧行为）
+	SystemPromptModeDrop = "drop"
+)
+
+// 系统提示词在 transcript 中的标记
+const (
+	systemPromptBegin = "[System Instructions]"
+	systemPromptEnd   = "[End of System Instructions]"
+)
+
+// requestMessages 将请求中的 messages 统一为 []map[string]interface{}
+func requestMessages(requestData map[string]interface{}) []map[string]interface{} {
+	switch messages := requestData["messages"].(type) {
+	case []interface{}:
+		result := make([]map[string]interface{}, 0, len(messages))
+		for _, msg := range messages {
+			if msgMap, ok := msg.(map[string]interface{}); ok {
+				result = append(result, msgMap)
+			}
+		}
+		return result
+	case []map[string]interface{}:
+		return messages
+	default:
+		log.Warnf("无法解析消息，类型: %T", requestData["messages"])
+		return nil
+	}
+}
+
+// invalidRequestError 请求内容不合法，应向客户端返回 400
+type invalidRequestError struct {
+	Message string
+}
+
+func (e *invalidRequestError) Error() string {
+	return e.Message
+}
+
+// parseMessages 解析请求中的消息，并按部署配置将 system/developer 指令并入对话。
+// tools 非空时会把工具说明加入指令，并将历史中的工具调用和结果序列化为文本。
+func (p *NotionAIProvider) parseMessages(ctx context.Context, requestData map[string]interface{}, tools *toolSession) ([]ChatMessage, error) {
+	logger := logging.FromContext(ctx)
+	var instructions []string
+	var turns []ChatMessage
+
+	// tool_call_id -> 函数名，用于标注工具结果
+	toolNames := make(map[string]string)
+	// 上一条是否为工具结果，连续的工具结果合并为一条用户消息
+	lastWasToolResult := false
+
+	messages := requestMessages(requestData)
+	logger.Infof("消息数量: %d", len(messages))
+	for i, msgMap := range messages {
+		role, _ := msgMap["role"].(string)
+		content, images, err := messageContent(role, msgMap["content"])
+		if err != nil {
+			return nil, &invalidRequestError{Message: fmt.Sprintf("messages[%d]: %v", i, err)}
+		}
+		logger.Infof("消息 %d: role=%s, content长度=%d, 图片数=%d", i, role, len(content), len(images))
+
+		isToolResult := false
+		switch role {
+		case "system", "developer":
+			if strings.TrimSpace(content) != "" {
+				instructions = append(instructions, content)
+			}
+		case "user":
+			turns = append(turns, ChatMessage{Role: role, Content: content, Images: images})
+		case "assistant":
+			if toolCalls, ok := msgMap["tool_calls"].([]interface{}); ok && len(toolCalls) > 0 {
+				calls, names := formatToolCalls(toolCalls)
+				for id, name := range names {
+					toolNames[id] = name
+				}
+				content = strings.TrimSpace(content + "\n" + calls)
+			}
+			turns = append(turns, ChatMessage{Role: role, Content: content})
+		case "tool", "function":
+			// 工具结果以用户消息的形式交给模型
+			toolCallID, _ := msgMap["tool_call_id"].(string)
+			name, _ := msgMap["name"].(string)
+			if name == "" {
+				name = toolNames[toolCallID]
+			}
+			result := formatToolResult(toolCallID, name, content)
+			if lastWasToolResult {
+				turns[len(turns)-1].Content += "\n" + result
+			} else {
+				turns = append(turns, ChatMessage{Role: "user", Content: result})
+			}
+			isToolResult = true
+		default:
+			return nil, &invalidRequestError{Message: fmt.Sprintf("messages[%d]: 不支持的角色 %q", i, role)}
+		}
+		lastWasToolResult = isToolResult
+	}
+
+	if p.config.SystemPromptMode == SystemPromptModeDrop && len(instructions) > 0 {
+		logger.Infof("根据配置丢弃 %d 条系统指令", len(instructions))
+		instructions = nil
+	}
+	// 工具说明不受 SYSTEM_PROMPT_MODE=drop 影响
+	if tools != nil {
+		instructions = append(instructions, tools.prompt())
+	}
+
+	return p.applySystemPrompt(instructions, turns), nil
+}
+
+// messageContent 将消息的 content 统一为文本和图片。
+// content 可以是字符串、null，或 OpenAI 的 content part 数组（text 部分按顺序以换行连接，
+// image_url 部分只允许出现在用户消息中）。
+func messageContent(role string, content interface{}) (string, []ImageInput, error) {
+	switch v := content.(type) {
+	case nil:
+		return "", nil, nil
+	case string:
+		return v, nil, nil
+	case map[string]interface{}:
+		return messageContent(role, []interface{}{v})
+	case []interface{}:
+		texts := make([]string, 0, len(v))
+		var images []ImageInput
+		for i, part := range v {
+			if text, ok := part.(string); ok {
+				texts = append(texts, text)
+				continue
+			}
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				return "", nil, fmt.Errorf("content[%d] 格式无效", i)
+			}
+
+			partType, _ := partMap["type"].(string)
+			switch {
+			case partType == "text" || partType == "input_text":
+				text, ok := partMap["text"].(string)
+				if !ok {
+					return "", nil, fmt.Errorf("content[%d] 缺少 text 字段", i)
+				}
+				texts = append(texts, text)
+			case partType == "refusal" && role == "assistant":
+				refusal, _ := partMap["refusal"].(string)
+				texts = append(texts, refusal)
+			case partType == "image_url" && role == "user":
+				url := imagePartURL(partMap["image_url"])
+				if url == "" {
+					return "", nil, fmt.Errorf("content[%d] 缺少 image_url.url 字段", i)
+				}
+				images = append(images, ImageInput{URL: url})
+			case partType == "":
+				return "", nil, fmt.Errorf("content[%d] 缺少 type 字段", i)
+			default:
+				return "", nil, fmt.Errorf("content[%d] 的类型 %q 不受支持 (role=%s)", i, partType, role)
+			}
+		}
+		return strings.Join(texts, "\n"), images, nil
+	default:
+		return "", nil, fmt.Errorf("content 的格式无效 (%T)", content)
+	}
+}
+
+// imagePartURL 取出 image_url 部分的地址，兼容字符串和 {url, detail} 两种写法
+func imagePartURL(imageURL interface{}) string {
+	switch v := imageURL.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		url, _ := v["url"].(string)
+		return url
+	}
+	return ""
+}
+
+// applySystemPrompt 按 SYSTEM_PROMPT_MODE 将系统指令并入对话轮次（drop 模式已在调用方处理）
+func (p *NotionAIProvider) applySystemPrompt(instructions []string, turns []ChatMessage) []ChatMessage {
+	if len(instructions) == 0 {
+		return turns
+	}
+
+	prompt := fmt.Sprintf("%s\n%s\n%s", systemPromptBegin, strings.Join(instructions, "\n\n"), systemPromptEnd)
+
+	switch p.config.SystemPromptMode {
+	case SystemPromptModeMerge:
+		for i := range turns {
+			if turns[i].Role == "user" {
+				merged := make([]ChatMessage, len(turns))
+				copy(merged, turns)
+				merged[i].Content = prompt + "\n\n" + turns[i].Content
+				return merged
+			}
+		}
+		// 没有用户消息可合并时退化为独立消息
+		fallthrough
+	default:
+		return append([]ChatMessage{{Role: "user", Content: prompt}}, turns...)
+	}
+}
+
+// attachmentValues 生成用户步骤中引用已上传文件的 attachments 字段
+func attachmentValues(files []*UploadedFile) []interface{} {
+	attachments := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		attachments = append(attachments, map[string]interface{}{
+			"type":        "file",
+			"url":         file.URL,
+			"name":        file.Name,
+			"contentType": file.ContentType,
+			"size":        file.Size,
+		})
+	}
+	return attachments
+}
+
+// transcriptStep 将一条对话消息转换为 Notion transcript 步骤
+func (p *NotionAIProvider) transcriptStep(account *config.NotionAccount, msg ChatMessage) map[string]interface{} {
+	if msg.Role == "assistant" {
+		return map[string]interface{}{
+			"id":   uuid.New().String(),
+			"type": "agent-inference",
+			"value": []interface{}{
+				map[string]interface{}{
+					"type":    "text",
+					"content": msg.Content,
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"id":        uuid.New().String(),
+		"type":      "user",
+		"value":     []interface{}{[]interface{}{msg.Content}},
+		"userId":    account.UserID,
+		"createdAt": time.Now().Format(time.RFC3339),
+	}
+}
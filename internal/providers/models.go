@@ -0,0 +1,217 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Anthropic 模型列表的分页参数
+const (
+	anthropicDefaultLimit = 20
+	anthropicMaxLimit     = 1000
+)
+
+// GetModels 获取模型列表
+func (p *NotionAIProvider) GetModels(c *gin.Context) error {
+	models := p.ListModels()
+	if IsAnthropicRequest(c) {
+		return p.anthropicModelList(c, models)
+	}
+
+	c.JSON(http.StatusOK, ModelResponse{
+		Object: "list",
+		Data:   models,
+	})
+	return nil
+}
+
+// GetModel 获取单个模型，别名和匹配别名规则的模型名返回对应的模型
+func (p *NotionAIProvider) GetModel(c *gin.Context, id string) error {
+	info, ok := p.findModel(id)
+	if !ok {
+		message := modelNotFoundMessage(id)
+		if IsAnthropicRequest(c) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"type":  "error",
+				"error": map[string]string{"type": "not_found_error", "message": message},
+			})
+		} else {
+			c.JSON(http.StatusNotFound, utils.ErrorResponse{
+				Error: utils.ErrorDetail{Message: message, Type: "invalid_request_error", Code: "model_not_found"},
+			})
+		}
+		return errors.New(message)
+	}
+
+	if IsAnthropicRequest(c) {
+		c.JSON(http.StatusOK, anthropicModel(info))
+		return nil
+	}
+	c.JSON(http.StatusOK, info)
+	return nil
+}
+
+// ListModels 合并模型表和模型发现的结果：模型表中的模型按发现结果标注是否可用，
+// 工作区中可用但未配置的模型以代号作为模型名追加在后面
+func (p *NotionAIProvider) ListModels() []ModelInfo {
+	catalog := config.Models()
+	models := []ModelInfo{}
+
+	for _, spec := range catalog.Listed() {
+		spec := spec
+		models = append(models, p.modelInfo(catalog, &spec))
+	}
+	for _, discovered := range p.unconfiguredModels(catalog) {
+		models = append(models, discoveredModelInfo(discovered))
+	}
+	return models
+}
+
+// findModel 按模型名、别名、别名规则和模型发现的代号查找模型，隐藏的模型也可以查到
+func (p *NotionAIProvider) findModel(id string) (ModelInfo, bool) {
+	catalog := config.Models()
+	if spec, _, ok := catalog.Resolve(id); ok {
+		return p.modelInfo(catalog, spec), true
+	}
+	if model, found, _ := p.discoveredModel(id); found && !catalog.HasCodename(id) {
+		return discoveredModelInfo(model), true
+	}
+	return ModelInfo{}, false
+}
+
+// modelInfo 模型表中模型的描述，可用状态来自模型发现
+func (p *NotionAIProvider) modelInfo(catalog *config.ModelCatalog, spec *config.ModelSpec) ModelInfo {
+	created := spec.CreatedAt()
+	if created.IsZero() {
+		created = catalog.LoadedAt
+	}
+	info := ModelInfo{
+		ID:              spec.ID,
+		Object:          "model",
+		Created:         created.Unix(),
+		OwnedBy:         "lzA6",
+		NotionModel:     spec.Codename,
+		DisplayName:     spec.DisplayName,
+		ContextWindow:   spec.ContextWindow,
+		MaxOutputTokens: spec.MaxOutputTokens,
+		Capabilities: &ModelCapabilities{
+			Vision:   spec.Vision,
+			Tools:    spec.Tools,
+			Thinking: spec.Thinking,
+		},
+	}
+	if discovered, found, known := p.discoveredModel(spec.Codename); known {
+		available := found && !discovered.Disabled
+		info.Available = &available
+		if info.DisplayName == "" {
+			info.DisplayName = discovered.DisplayName
+		}
+	}
+	return info
+}
+
+// discoveredModelInfo 模型发现到但未在模型表中配置的模型的描述
+func discoveredModelInfo(discovered DiscoveredModel) ModelInfo {
+	available := !discovered.Disabled
+	return ModelInfo{
+		ID:          discovered.Codename,
+		Object:      "model",
+		Created:     discovered.FirstSeen.Unix(),
+		OwnedBy:     "lzA6",
+		NotionModel: discovered.Codename,
+		DisplayName: discovered.DisplayName,
+		Available:   &available,
+	}
+}
+
+// anthropicModel 转换为 Anthropic 格式的模型信息
+func anthropicModel(info ModelInfo) AnthropicModelInfo {
+	displayName := info.DisplayName
+	if displayName == "" {
+		displayName = info.ID
+	}
+	return AnthropicModelInfo{
+		Type:        "model",
+		ID:          info.ID,
+		DisplayName: displayName,
+		CreatedAt:   time.Unix(info.Created, 0).UTC().Format(time.RFC3339),
+	}
+}
+
+// anthropicModelList 按 Anthropic 的 limit / after_id / before_id 分页返回模型列表
+func (p *NotionAIProvider) anthropicModelList(c *gin.Context, models []ModelInfo) error {
+	invalid := func(message string) error {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"type":  "error",
+			"error": map[string]string{"type": "invalid_request_error", "message": message},
+		})
+		return errors.New(message)
+	}
+
+	limit := anthropicDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > anthropicMaxLimit {
+			return invalid(fmt.Sprintf("limit 必须是 1 到 %d 之间的整数", anthropicMaxLimit))
+		}
+		limit = n
+	}
+
+	indexOf := func(id string) int {
+		for i, m := range models {
+			if m.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	start, end := 0, len(models)
+	if afterID := c.Query("after_id"); afterID != "" {
+		i := indexOf(afterID)
+		if i < 0 {
+			return invalid(fmt.Sprintf("after_id %s 不在模型列表中", afterID))
+		}
+		start = i + 1
+	}
+	if beforeID := c.Query("before_id"); beforeID != "" {
+		i := indexOf(beforeID)
+		if i < 0 {
+			return invalid(fmt.Sprintf("before_id %s 不在模型列表中", beforeID))
+		}
+		end = i
+		// 只指定 before_id 时返回紧挨着它之前的一页
+		if c.Query("after_id") == "" && end-limit > start {
+			start = end - limit
+		}
+	}
+	if start > end {
+		start = end
+	}
+
+	hasMore := false
+	if end-start > limit {
+		end = start + limit
+		hasMore = true
+	} else if c.Query("before_id") != "" && c.Query("after_id") == "" {
+		hasMore = start > 0
+	}
+
+	response := AnthropicModelResponse{Data: []AnthropicModelInfo{}, HasMore: hasMore}
+	for _, info := range models[start:end] {
+		response.Data = append(response.Data, anthropicModel(info))
+	}
+	if len(response.Data) > 0 {
+		response.FirstID = &response.Data[0].ID
+		response.LastID = &response.Data[len(response.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
@@ -1,16 +1,22 @@
 package providers
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"notion-2api-go/internal/accounts"
 	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/conversations"
+	"notion-2api-go/internal/logging"
+	"notion-2api-go/internal/metrics"
+	"notion-2api-go/internal/threads"
 	"notion-2api-go/internal/utils"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,13 +29,41 @@ type NotionAIProvider struct {
 	client       *http.Client
 	apiEndpoints map[string]string
 	config       *config.Settings
+	accounts     *accounts.Pool
+	uploader     FileUploader
+	// imageClient 下载客户端图片使用的 HTTP 客户端，拒绝访问内网地址
+	imageClient *http.Client
+	discovery   modelDiscovery
+	// conversations 对话与 Notion 线程的对应关系，为 nil 时不复用线程
+	conversations *conversations.Store
+	// threads 待归档的线程，为 nil 时不清理线程
+	threads *threads.Tracker
+	// activeThreads 进行中的请求正在使用的线程及使用次数
+	activeMu      sync.Mutex
+	activeThreads map[string]int
+
+	// 停机等待超时后中止进行中的推理
+	abortOnce   sync.Once
+	abort       context.Context
+	abortCancel context.CancelFunc
+
+	// 后台任务（模型发现、重新预热、线程归档），Stop 时取消并等待退出
+	backgroundOnce   sync.Once
+	backgroundCtx    context.Context
+	backgroundCancel context.CancelFunc
+	backgroundMu     sync.Mutex
+	stopped          bool
+	tasks            sync.WaitGroup
 }
 
 // NewNotionAIProvider 创建新的 Notion AI 提供者
 func NewNotionAIProvider(cfg *config.Settings) (*NotionAIProvider, error) {
-	if cfg.NotionCookie == "" || cfg.NotionSpaceID == "" || cfg.NotionUserID == "" {
-		return nil, fmt.Errorf("配置错误: NOTION_COOKIE, NOTION_SPACE_ID 和 NOTION_USER_ID 必须在 .env 文件中全部设置")
+	pool, err := accounts.NewPool(cfg.Accounts, cfg.AccountStrategy, time.Duration(cfg.AccountCooldown)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("配置错误: %v", err)
 	}
+	log.Infof("已加载 %d 个 Notion 账号，选择策略: %s", len(cfg.Accounts), cfg.AccountStrategy)
+	pool.SetCircuitBreaker(cfg.CircuitBreakerThreshold, time.Duration(cfg.CircuitBreakerCooldown)*time.Second)
 
 	// 配置 Transport 以更好地模拟浏览器行为
 	transport := &http.Transport{
@@ -38,58 +72,182 @@ func NewNotionAIProvider(cfg *config.Settings) (*NotionAIProvider, error) {
 		IdleConnTimeout:     90 * time.Second,
 		DisableCompression:  false,
 	}
+	roundTripper, err := cassetteTransport(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
 
 	provider := &NotionAIProvider{
 		client: &http.Client{
 			Timeout:   time.Duration(cfg.APIRequestTimeout) * time.Second,
-			Transport: transport,
+			Transport: roundTripper,
 		},
 		apiEndpoints: map[string]string{
-			"runInference":     "https://www.notion.so/api/v3/runInferenceTranscript",
-			"saveTransactions": "https://www.notion.so/api/v3/saveTransactionsFanout",
+			"runInference":       cfg.NotionBaseURL + "/api/v3/runInferenceTranscript",
+			"saveTransactions":   cfg.NotionBaseURL + "/api/v3/saveTransactionsFanout",
+			"getUploadFileUrl":   cfg.NotionBaseURL + "/api/v3/getUploadFileUrl",
+			"getAvailableModels": cfg.NotionBaseURL + "/api/v3/getAvailableModels",
+			"loadUserContent":    cfg.NotionBaseURL + "/api/v3/loadUserContent",
 		},
-		config: cfg,
+		imageClient: newImageClient(time.Duration(cfg.APIRequestTimeout) * time.Second),
+		config:      cfg,
+		accounts:    pool,
+	}
+	provider.uploader = NewNotionFileUploader(provider.client, provider.apiEndpoints["getUploadFileUrl"], provider.prepareHeaders)
+	provider.discovery.discoverer = NewNotionModelDiscoverer(provider.client, provider.apiEndpoints["getAvailableModels"], provider.prepareHeaders)
+
+	registerAccountMetrics(pool)
+	// 回放模式下只有推理请求有录制，不预热会话也不查询可用模型，账号在首次成功回放后就绪
+	if cfg.CassetteMode == "replay" {
+		log.Info("回放模式: 跳过会话预热和模型发现")
+		return provider, nil
 	}
 
 	// 会话预热
-	provider.warmupSession()
+	for _, account := range pool.Accounts() {
+		pool.RecordWarmup(account, provider.warmupSession(context.Background(), &account.NotionAccount))
+	}
+	provider.goBackground(provider.rewarmLoop)
+	if cfg.ModelDiscoveryInterval > 0 {
+		interval := time.Duration(cfg.ModelDiscoveryInterval) * time.Second
+		provider.goBackground(func(ctx context.Context) { provider.discoveryLoop(ctx, interval) })
+	}
 	return provider, nil
 }
 
-// warmupSession 会话预热
-func (p *NotionAIProvider) warmupSession() {
-	log.Info("正在进行会话预热 (Session Warm-up)...")
-	req, err := http.NewRequest("GET", "https://www.notion.so/", nil)
-	if err != nil {
-		log.Errorf("会话预热失败: %v", err)
+// backgroundContext 返回后台任务的 context，Stop 时取消，首次调用时创建
+func (p *NotionAIProvider) backgroundContext() context.Context {
+	p.backgroundOnce.Do(func() {
+		p.backgroundCtx, p.backgroundCancel = context.WithCancel(context.Background())
+	})
+	return p.backgroundCtx
+}
+
+// goBackground 在后台运行 task，Stop 之后不再启动新任务
+func (p *NotionAIProvider) goBackground(task func(ctx context.Context)) {
+	ctx := p.backgroundContext()
+
+	p.backgroundMu.Lock()
+	defer p.backgroundMu.Unlock()
+	if p.stopped {
 		return
 	}
+	p.tasks.Add(1)
+	go func() {
+		defer p.tasks.Done()
+		task(ctx)
+	}()
+}
+
+// Stop 停止后台任务并等待它们退出，服务退出时在关闭线程记录等存储之前调用
+func (p *NotionAIProvider) Stop() {
+	p.backgroundContext()
+
+	p.backgroundMu.Lock()
+	p.stopped = true
+	p.backgroundMu.Unlock()
+
+	p.backgroundCancel()
+	p.tasks.Wait()
+}
 
-	headers := p.prepareHeaders()
-	delete(headers, "Accept")
-	for key, value := range headers {
+// registerAccountMetrics 让账号健康指标在抓取时从账号池读取状态
+func registerAccountMetrics(pool *accounts.Pool) {
+	collect := func(value func(accounts.Status) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, status := range pool.Status() {
+				samples = append(samples, metrics.Sample{LabelValues: []string{status.Name}, Value: value(status)})
+			}
+			return samples
+		}
+	}
+	metrics.AccountAvailable.Set(collect(func(s accounts.Status) float64 { return boolValue(s.Available) }))
+	metrics.AccountHealthy.Set(collect(func(s accounts.Status) float64 { return boolValue(s.Healthy) }))
+	metrics.AccountCircuitOpen.Set(collect(func(s accounts.Status) float64 { return boolValue(s.CircuitOpen) }))
+	metrics.AccountInFlight.Set(collect(func(s accounts.Status) float64 { return float64(s.InFlight) }))
+	metrics.AccountRequests.Set(collect(func(s accounts.Status) float64 { return float64(s.Requests) }))
+	metrics.AccountCooldownSeconds.Set(collect(func(s accounts.Status) float64 { return s.Cooldown.Seconds() }))
+}
+
+// boolValue 布尔状态对应的指标值
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SetFileUploader 替换图片附件的上传实现（例如指向本地测试桩）
+func (p *NotionAIProvider) SetFileUploader(uploader FileUploader) {
+	p.uploader = uploader
+}
+
+// warmupSession 会话预热：以账号的 Cookie 调用 loadUserContent 接口，确认会话有效且能取到用户信息。
+// Cookie 失效时 Notion 返回 401/403 或空的用户记录，都视为预热失败，失败时返回错误供就绪检查使用
+func (p *NotionAIProvider) warmupSession(ctx context.Context, account *config.NotionAccount) error {
+	log.Infof("正在进行会话预热 (Session Warm-up)，账号: %s...", account.Name)
+	if err := p.loadUserContent(ctx, account); err != nil {
+		log.Errorf("会话预热失败: %v", err)
+		return err
+	}
+	log.Info("会话预热成功。")
+	return nil
+}
+
+// loadUserContent 查询账号的用户信息，返回 nil 表示会话有效
+func (p *NotionAIProvider) loadUserContent(ctx context.Context, account *config.NotionAccount) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiEndpoints["loadUserContent"], strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	for key, value := range p.prepareHeaders(account) {
 		req.Header.Set(key, value)
 	}
+	req.Header.Set("Accept", "application/json")
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		log.Errorf("会话预热失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	log.Info("会话预热成功。")
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("Cookie 无效或已过期 (状态码 %d)", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("会话预热返回状态码 %d", resp.StatusCode)
+	}
+
+	var result struct {
+		RecordMap struct {
+			NotionUser map[string]json.RawMessage `json:"notion_user"`
+		} `json:"recordMap"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析用户信息失败: %v", err)
+	}
+	users := result.RecordMap.NotionUser
+	if len(users) == 0 {
+		return fmt.Errorf("Cookie 无效或已过期 (未返回用户信息)")
+	}
+	if account.UserID != "" {
+		if _, ok := users[account.UserID]; !ok {
+			return fmt.Errorf("Cookie 对应的用户与配置的用户 ID %s 不一致", account.UserID)
+		}
+	}
+	return nil
 }
 
-// prepareHeaders 准备请求头
-func (p *NotionAIProvider) prepareHeaders() map[string]string {
+// prepareHeaders 准备以指定账号请求 Notion 的请求头
+func (p *NotionAIProvider) prepareHeaders(account *config.NotionAccount) map[string]string {
 	return map[string]string{
 		"Content-Type":                "application/json",
 		"Accept":                      "application/x-ndjson",
 		"Accept-Language":             "zh-CN,zh;q=0.9,en;q=0.8",
-		"Cookie":                      p.config.GetCookieHeader(),
-		"x-notion-space-id":           p.config.NotionSpaceID,
-		"x-notion-active-user-header": p.config.NotionUserID,
+		"Cookie":                      account.GetCookieHeader(),
+		"x-notion-space-id":           account.SpaceID,
+		"x-notion-active-user-header": account.UserID,
 		"x-notion-client-version":     p.config.NotionClientVersion,
 		"notion-audit-log-platform":   "web",
 		"Origin":                      "https://www.notion.so",
@@ -105,69 +263,77 @@ func (p *NotionAIProvider) prepareHeaders() map[string]string {
 }
 
 // createThread 创建对话线程
-func (p *NotionAIProvider) createThread(threadType string) (string, error) {
+func (p *NotionAIProvider) createThread(account *config.NotionAccount, threadType string) (string, error) {
 	threadID := uuid.New().String()
+	operation := map[string]interface{}{
+		"pointer": map[string]interface{}{
+			"table":   "thread",
+			"id":      threadID,
+			"spaceId": account.SpaceID,
+		},
+		"path":    []string{},
+		"command": "set",
+		"args": map[string]interface{}{
+			"id":               threadID,
+			"version":          1,
+			"parent_id":        account.SpaceID,
+			"parent_table":     "space",
+			"space_id":         account.SpaceID,
+			"created_time":     time.Now().UnixMilli(),
+			"created_by_id":    account.UserID,
+			"created_by_table": "notion_user",
+			"messages":         []interface{}{},
+			"data":             map[string]interface{}{},
+			"alive":            true,
+			"type":             threadType,
+		},
+	}
+
+	if err := p.saveTransactions(context.Background(), account, operation); err != nil {
+		return "", fmt.Errorf("创建对话线程失败: %v", err)
+	}
+
+	log.Infof("对话线程创建成功, Thread ID: %s", threadID)
+	return threadID, nil
+}
+
+// saveTransactions 以指定账号提交一个包含 operations 的事务
+func (p *NotionAIProvider) saveTransactions(ctx context.Context, account *config.NotionAccount, operations ...map[string]interface{}) error {
 	payload := map[string]interface{}{
 		"requestId": uuid.New().String(),
 		"transactions": []map[string]interface{}{
 			{
-				"id":      uuid.New().String(),
-				"spaceId": p.config.NotionSpaceID,
-				"operations": []map[string]interface{}{
-					{
-						"pointer": map[string]interface{}{
-							"table":   "thread",
-							"id":      threadID,
-							"spaceId": p.config.NotionSpaceID,
-						},
-						"path":    []string{},
-						"command": "set",
-						"args": map[string]interface{}{
-							"id":               threadID,
-							"version":          1,
-							"parent_id":        p.config.NotionSpaceID,
-							"parent_table":     "space",
-							"space_id":         p.config.NotionSpaceID,
-							"created_time":     time.Now().UnixMilli(),
-							"created_by_id":    p.config.NotionUserID,
-							"created_by_table": "notion_user",
-							"messages":         []interface{}{},
-							"data":             map[string]interface{}{},
-							"alive":            true,
-							"type":             threadType,
-						},
-					},
-				},
+				"id":         uuid.New().String(),
+				"spaceId":    account.SpaceID,
+				"operations": operations,
 			},
 		},
 	}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	req, err := http.NewRequest("POST", p.apiEndpoints["saveTransactions"], bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiEndpoints["saveTransactions"], bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	for key, value := range p.prepareHeaders() {
+	for key, value := range p.prepareHeaders(account) {
 		req.Header.Set(key, value)
 	}
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("创建对话线程失败: %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("创建对话线程失败，状态码: %d", resp.StatusCode)
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
 	}
-
-	log.Infof("对话线程创建成功, Thread ID: %s", threadID)
-	return threadID, nil
+	return nil
 }
 
 // normalizeBlockID 规范化 Block ID
@@ -182,62 +348,63 @@ func (p *NotionAIProvider) normalizeBlockID(blockID string) string {
 	return blockID
 }
 
-// preparePayload 准备请求载荷
-func (p *NotionAIProvider) preparePayload(requestData map[string]interface{}, threadID, mappedModel, threadType string) map[string]interface{} {
+// preparePayload 准备请求载荷，messages 为要写入 transcript 的对话消息
+func (p *NotionAIProvider) preparePayload(ctx context.Context, account *config.NotionAccount, messages []ChatMessage, images *imageSet, threadID string, model *config.ModelSpec) (map[string]interface{}, error) {
+	logger := logging.FromContext(ctx)
 	// 准备 config - 使用与浏览器一致的完整配置
 	configValue := map[string]interface{}{
-		"type":                            threadType,
-		"model":                           mappedModel,
-		"modelFromUser":                   true,
-		"useWebSearch":                    true,
-		"useReadOnlyMode":                 false,
-		"writerMode":                      false,
-		"isCustomAgent":                   false,
-		"isCustomAgentBuilder":            false,
-		"useCustomAgentDraft":             false,
-		"enableAgentAutomations":          false,
-		"enableAgentIntegrations":         false,
-		"enableBackgroundAgents":          false,
-		"enableCustomAgents":              false,
-		"enableExperimentalIntegrations":  false,
+		"type":                             model.ThreadType,
+		"model":                            model.Codename,
+		"modelFromUser":                    true,
+		"useWebSearch":                     model.UsesWebSearch(),
+		"useReadOnlyMode":                  false,
+		"writerMode":                       false,
+		"isCustomAgent":                    false,
+		"isCustomAgentBuilder":             false,
+		"useCustomAgentDraft":              false,
+		"enableAgentAutomations":           false,
+		"enableAgentIntegrations":          false,
+		"enableBackgroundAgents":           false,
+		"enableCustomAgents":               false,
+		"enableExperimentalIntegrations":   false,
 		"enableAgentViewNotificationsTool": false,
-		"enableAgentRevertTool":           false,
-		"enableAgentDiffs":                false,
-		"enableAgentCreateDbTemplate":     false,
-		"enableCsvAttachmentSupport":      true,
-		"enableDatabaseAgents":            false,
-		"enableAgentThreadTools":          false,
-		"enableRunAgentTool":              false,
-		"enableAgentDashboards":           false,
-		"enableAgentCardCustomization":    true,
-		"enableSystemPromptAsPage":        false,
-		"enableUserSessionContext":        false,
-		"enableComputer":                  false,
-		"enableScriptAgent":               false,
-		"enableAgentGenerateImage":        false,
-		"enableAgentTodos":                false,
-		"enableSpeculativeSearch":         false,
-		"enableQueryCalendar":             false,
-		"enableQueryMail":                 false,
-		"enableUpdatePageV2Tool":          true,
-		"enableUpdatePageAutofixer":       true,
-		"enableUpdateAgentV2Tools":        true,
-		"enableUpdatePageMarkdownTree":    false,
-		"enableUpdatePageTreeDiff":        false,
-		"enableUpdatePageOrderUpdates":    true,
-		"enableUpdatePageTreeDiffMetrics": false,
-		"availableConnectors":             []interface{}{},
-		"searchScopes":                    []map[string]interface{}{{"type": "everything"}},
+		"enableAgentRevertTool":            false,
+		"enableAgentDiffs":                 false,
+		"enableAgentCreateDbTemplate":      false,
+		"enableCsvAttachmentSupport":       true,
+		"enableDatabaseAgents":             false,
+		"enableAgentThreadTools":           false,
+		"enableRunAgentTool":               false,
+		"enableAgentDashboards":            false,
+		"enableAgentCardCustomization":     true,
+		"enableSystemPromptAsPage":         false,
+		"enableUserSessionContext":         false,
+		"enableComputer":                   false,
+		"enableScriptAgent":                false,
+		"enableAgentGenerateImage":         false,
+		"enableAgentTodos":                 false,
+		"enableSpeculativeSearch":          false,
+		"enableQueryCalendar":              false,
+		"enableQueryMail":                  false,
+		"enableUpdatePageV2Tool":           true,
+		"enableUpdatePageAutofixer":        true,
+		"enableUpdateAgentV2Tools":         true,
+		"enableUpdatePageMarkdownTree":     false,
+		"enableUpdatePageTreeDiff":         false,
+		"enableUpdatePageOrderUpdates":     true,
+		"enableUpdatePageTreeDiffMetrics":  false,
+		"availableConnectors":              []interface{}{},
+		"searchScopes":                     []map[string]interface{}{{"type": "everything"}},
 	}
 
 	// 准备 context
 	contextValue := map[string]interface{}{
 		"timezone":        "Asia/Shanghai",
-		"userName":        p.config.NotionUserName,
-		"userId":          p.config.NotionUserID,
-		"userEmail":       p.config.NotionUserEmail,
-		"spaceName":       p.config.NotionUserName + "的工作空间",
-		"spaceId":         p.config.NotionSpaceID,
+		"userName":        account.UserName,
+		"userId":          account.UserID,
+		"userEmail":       account.UserEmail,
+		"spaceName":       account.UserName + "的工作空间",
+		"spaceId":         account.SpaceID,
 		"currentDatetime": time.Now().Format(time.RFC3339Nano),
 		"surface":         "ai_module",
 	}
@@ -257,98 +424,36 @@ func (p *NotionAIProvider) preparePayload(requestData map[string]interface{}, th
 	}
 
 	// 添加消息
-	log.Infof("开始处理消息，类型: %T", requestData["messages"])
-	if messages, ok := requestData["messages"].([]interface{}); ok {
-		log.Infof("消息数量: %d", len(messages))
-		for i, msg := range messages {
-			if msgMap, ok := msg.(map[string]interface{}); ok {
-				role, _ := msgMap["role"].(string)
-				content, _ := msgMap["content"].(string)
-				log.Infof("消息 %d: role=%s, content长度=%d", i, role, len(content))
-
-				// 跳过 system 消息（Notion 不支持）
-				if role == "system" {
-					continue
-				}
-
-				if role == "user" {
-					transcript = append(transcript, map[string]interface{}{
-						"id":        uuid.New().String(),
-						"type":      "user",
-						"value":     []interface{}{[]interface{}{content}},
-						"userId":    p.config.NotionUserID,
-						"createdAt": time.Now().Format(time.RFC3339),
-					})
-				} else if role == "assistant" {
-					transcript = append(transcript, map[string]interface{}{
-						"id":   uuid.New().String(),
-						"type": "agent-inference",
-						"value": []interface{}{
-							map[string]interface{}{
-								"type":    "text",
-								"content": content,
-							},
-						},
-					})
-				}
-			}
-		}
-	} else if messages, ok := requestData["messages"].([]map[string]interface{}); ok {
-		// 处理 []map[string]interface{} 类型
-		log.Infof("消息数量 (map类型): %d", len(messages))
-		for i, msgMap := range messages {
-			role, _ := msgMap["role"].(string)
-			content, _ := msgMap["content"].(string)
-			log.Infof("消息 %d: role=%s, content长度=%d", i, role, len(content))
-
-			// 跳过 system 消息
-			if role == "system" {
-				continue
-			}
-
-			if role == "user" {
-				transcript = append(transcript, map[string]interface{}{
-					"id":        uuid.New().String(),
-					"type":      "user",
-					"value":     []interface{}{[]interface{}{content}},
-					"userId":    p.config.NotionUserID,
-					"createdAt": time.Now().Format(time.RFC3339),
-				})
-			} else if role == "assistant" {
-				transcript = append(transcript, map[string]interface{}{
-					"id":   uuid.New().String(),
-					"type": "agent-inference",
-					"value": []interface{}{
-						map[string]interface{}{
-							"type":    "text",
-							"content": content,
-						},
-					},
-				})
+	for _, msg := range messages {
+		step := p.transcriptStep(account, msg)
+		if len(msg.Images) > 0 {
+			uploaded, err := images.upload(ctx, account, threadID, msg.Images)
+			if err != nil {
+				return nil, err
 			}
+			step["attachments"] = attachmentValues(uploaded)
 		}
-	} else {
-		log.Warnf("无法解析消息，类型: %T", requestData["messages"])
+		transcript = append(transcript, step)
 	}
-	
-	log.Infof("最终 transcript 长度: %d", len(transcript))
+
+	logger.Infof("最终 transcript 长度: %d", len(transcript))
 
 	payload := map[string]interface{}{
-		"traceId":                 uuid.New().String(),
-		"spaceId":                 p.config.NotionSpaceID,
-		"transcript":              transcript,
-		"threadId":                threadID,
+		"traceId":    uuid.New().String(),
+		"spaceId":    account.SpaceID,
+		"transcript": transcript,
+		"threadId":   threadID,
 		"threadParentPointer": map[string]interface{}{
 			"table":   "space",
-			"id":      p.config.NotionSpaceID,
-			"spaceId": p.config.NotionSpaceID,
+			"id":      account.SpaceID,
+			"spaceId": account.SpaceID,
 		},
-		"createThread":            true,
-		"isPartialTranscript":     false,
-		"asPatchResponse":         false,
-		"generateTitle":           true,
-		"saveAllThreadOperations": true,
-		"threadType":              threadType,
+		"createThread":                  true,
+		"isPartialTranscript":           false,
+		"asPatchResponse":               false,
+		"generateTitle":                 true,
+		"saveAllThreadOperations":       true,
+		"threadType":                    model.ThreadType,
 		"isUserInAnySalesAssistedSpace": false,
 		"isSpaceSalesAssisted":          false,
 	}
@@ -361,18 +466,15 @@ func (p *NotionAIProvider) preparePayload(requestData map[string]interface{}, th
 		"emitInferences":                  false,
 	}
 
-	return payload
+	return payload, nil
 }
 
-// cleanContent 清理响应内容
-func (p *NotionAIProvider) cleanContent(content string) string {
-	if content == "" {
-		return ""
-	}
+// langTagPattern 响应中的语言标记
+var langTagPattern = regexp.MustCompile(`<lang primary="[^"]*"\s*/>\n*`)
 
-	// 移除各种标记和噪音文本
+// cleanPatterns 响应内容中需要移除的标记和噪音文本
+var cleanPatterns = func() []*regexp.Regexp {
 	patterns := []string{
-		`<lang primary="[^"]*"\s*/>\n*`,
 		`<thinking>[\s\S]*?</thinking>\s*`,
 		`<thought>[\s\S]*?</thought>\s*`,
 		`(?i)^.*?Chinese whatmodel I am.*?Theyspecifically.*?requested.*?me.*?to.*?reply.*?in.*?Chinese\.\s*`,
@@ -384,30 +486,52 @@ func (p *NotionAIProvider) cleanContent(content string) string {
 		`(?i)^.*?I.*?should.*?identify.*?myself.*?as.*?Notion.*?AI.*?as.*?mentioned.*?in.*?the.*?system.*?prompt.*?\s*`,
 		`(?i)^.*?I.*?should.*?not.*?make.*?specific.*?claims.*?about.*?the.*?underlying.*?model.*?architecture.*?since.*?that.*?information.*?is.*?not.*?provided.*?in.*?my.*?context\.\s*`,
 	}
-
+	compiled := []*regexp.Regexp{langTagPattern}
 	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		content = re.ReplaceAllString(content, "")
+		compiled = append(compiled, regexp.MustCompile(pattern))
+	}
+	return compiled
+}()
+
+// cleanContent 清理响应内容
+func (p *NotionAIProvider) cleanContent(content string) string {
+	if content == "" {
+		return ""
 	}
 
-	return strings.TrimSpace(content)
+	return strings.TrimSpace(stripNoise(content))
+}
+
+// stripNoise 移除各种标记和噪音文本
+func stripNoise(content string) string {
+	for _, re := range cleanPatterns {
+		content = re.ReplaceAllString(content, "")
+	}
+	return content
 }
 
 // parseNDJSONLine 解析 NDJSON 行
-func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{} {
+func (p *NotionAIProvider) parseNDJSONLine(ctx context.Context, line string) []map[string]interface{} {
+	logger := logging.FromContext(ctx)
 	results := []map[string]interface{}{}
-	
+
 	if strings.TrimSpace(line) == "" {
 		return results
 	}
 
 	var data map[string]interface{}
 	if err := json.Unmarshal([]byte(line), &data); err != nil {
-		log.Warnf("解析NDJSON行失败: %v - Line: %s", err, line)
+		logger.Warnf("解析NDJSON行失败: %v - Line: %s", err, logging.Content(line))
 		return results
 	}
 
-	log.Debugf("原始响应数据: %v", data)
+	logger.Debugf("原始响应数据: %s", logging.Content(line))
+
+	eventType, _ := data["type"].(string)
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	metrics.NDJSONEvents.WithLabelValues(eventType).Inc()
 
 	// 检查是否是额度用尽错误
 	if dataType, ok := data["type"].(string); ok && dataType == "premium-feature-unavailable" {
@@ -416,10 +540,13 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 				current, _ := limit["current"].(float64)
 				total, _ := limit["total"].(float64)
 				errorMsg := fmt.Sprintf("Notion AI 额度已用尽 (%d/%d)，请升级到 Business 计划或等待额度重置", int(current), int(total))
-				log.Errorf(errorMsg)
+				logger.Errorf(errorMsg)
 				results = append(results, map[string]interface{}{
-					"type":  "error",
-					"error": errorMsg,
+					"type":    "error",
+					"error":   errorMsg,
+					"quota":   true,
+					"current": int(current),
+					"total":   int(total),
 				})
 				return results
 			}
@@ -427,6 +554,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 		results = append(results, map[string]interface{}{
 			"type":  "error",
 			"error": "Notion AI 功能不可用，可能是额度用尽或需要升级计划",
+			"quota": true,
 		})
 		return results
 	}
@@ -434,7 +562,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 	// 格式1: Gemini 返回的 markdown-chat 事件
 	if dataType, ok := data["type"].(string); ok && dataType == "markdown-chat" {
 		if content, ok := data["value"].(string); ok && content != "" {
-			log.Info("从 'markdown-chat' 直接事件中提取到内容。")
+			logger.Info("从 'markdown-chat' 直接事件中提取到内容。")
 			results = append(results, map[string]interface{}{
 				"type":    "final",
 				"content": content,
@@ -451,14 +579,14 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 					path, _ := op["p"].(string)
 					value := op["v"]
 
-					// Gemini 的完整内容 patch 格式
+					// Gemini 的新增内容块 patch 格式（块的起始片段，后续由 "x" 操作追加）
 					if opType == "a" && strings.HasSuffix(path, "/s/-") {
 						if valueMap, ok := value.(map[string]interface{}); ok {
 							if valueMap["type"] == "markdown-chat" {
 								if content, ok := valueMap["value"].(string); ok && content != "" {
-									log.Info("从 'patch' (Gemini-style) 中提取到完整内容。")
+									logger.Info("从 'patch' (Gemini-style) 中提取到内容块起始片段。")
 									results = append(results, map[string]interface{}{
-										"type":    "final",
+										"type":    "incremental",
 										"content": content,
 									})
 								}
@@ -469,7 +597,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 					// Gemini 的增量内容 patch 格式
 					if opType == "x" && strings.Contains(path, "/s/") && strings.HasSuffix(path, "/value") {
 						if content, ok := value.(string); ok && content != "" {
-							log.Infof("从 'patch' (Gemini增量) 中提取到内容: %s", content)
+							logger.Debugf("从 'patch' (Gemini增量) 中提取到内容: %s", logging.Content(content))
 							results = append(results, map[string]interface{}{
 								"type":    "incremental",
 								"content": content,
@@ -480,7 +608,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 					// Claude 和 GPT 的增量内容 patch 格式
 					if opType == "x" && strings.Contains(path, "/value/") {
 						if content, ok := value.(string); ok && content != "" {
-							log.Infof("从 'patch' (Claude/GPT增量) 中提取到内容: %s", content)
+							logger.Debugf("从 'patch' (Claude/GPT增量) 中提取到内容: %s", logging.Content(content))
 							results = append(results, map[string]interface{}{
 								"type":    "incremental",
 								"content": content,
@@ -488,14 +616,14 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 						}
 					}
 
-					// Claude 和 GPT 的完整内容 patch 格式
+					// Claude 和 GPT 的新增内容块 patch 格式（块的起始片段，后续由 "x" 操作追加）
 					if opType == "a" && strings.HasSuffix(path, "/value/-") {
 						if valueMap, ok := value.(map[string]interface{}); ok {
 							if valueMap["type"] == "text" {
 								if content, ok := valueMap["content"].(string); ok && content != "" {
-									log.Info("从 'patch' (Claude/GPT-style) 中提取到完整内容。")
+									logger.Info("从 'patch' (Claude/GPT-style) 中提取到内容块起始片段。")
 									results = append(results, map[string]interface{}{
-										"type":    "final",
+										"type":    "incremental",
 										"content": content,
 									})
 								}
@@ -558,7 +686,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 				}
 
 				if latestContent != "" {
-					log.Infof("从 record-map 提取到最终内容 (created_time: %.0f)", latestTime)
+					logger.Infof("从 record-map 提取到最终内容 (created_time: %.0f)", latestTime)
 					results = append(results, map[string]interface{}{
 						"type":    "final",
 						"content": latestContent,
@@ -573,6 +701,7 @@ func (p *NotionAIProvider) parseNDJSONLine(line string) []map[string]interface{}
 
 // ChatCompletion 处理聊天补全请求（支持流式和非流式）
 func (p *NotionAIProvider) ChatCompletion(c *gin.Context, requestData map[string]interface{}) error {
+	logger := logging.FromContext(c.Request.Context())
 	// 解析 stream 参数，默认为 true
 	stream := true
 	if streamVal, ok := requestData["stream"].(bool); ok {
@@ -585,220 +714,258 @@ func (p *NotionAIProvider) ChatCompletion(c *gin.Context, requestData map[string
 		modelName = model
 	}
 
-	mappedModel := p.config.ModelMap[modelName]
-	if mappedModel == "" {
-		mappedModel = "anthropic-sonnet-alt-thinking"
+	model := p.resolveModel(c, modelName)
+	if model == nil {
+		message := modelNotFoundMessage(modelName)
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: message, Type: "invalid_request_error", Code: "model_not_found"},
+		})
+		return errors.New(message)
 	}
-
-	// 确定线程类型
-	threadType := "workflow"
-	if strings.HasPrefix(mappedModel, "vertex-") {
-		threadType = "markdown-chat"
+	if !modelAllowed(c, model) {
+		message := modelForbiddenMessage(modelName, model)
+		c.JSON(http.StatusForbidden, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: message, Type: "permission_error"},
+		})
+		return errors.New(message)
 	}
 
-	// 生成新的 thread ID，让 Notion 自动创建
-	threadID := uuid.New().String()
-
-	// 准备请求载荷
-	payload := p.preparePayload(requestData, threadID, mappedModel, threadType)
-	// 设置 createThread 为 true，让 Notion 自动创建线程
-	payload["createThread"] = true
-
-	// 发送请求到 Notion
-	jsonData, err := json.Marshal(payload)
+	// 解析工具定义
+	tools, err := parseToolSession(requestData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("序列化请求失败: %v", err),
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: err.Error(), Type: "invalid_request_error"},
 		})
 		return err
 	}
 
-	log.Infof("请求 Notion AI URL: %s", p.apiEndpoints["runInference"])
-	log.Debugf("请求体: %s", string(jsonData))
-
-	req, err := http.NewRequest("POST", p.apiEndpoints["runInference"], bytes.NewBuffer(jsonData))
+	messages, err := p.parseMessages(c.Request.Context(), requestData, tools)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("创建请求失败: %v", err),
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: err.Error(), Type: "invalid_request_error"},
 		})
 		return err
 	}
-
-	for key, value := range p.prepareHeaders() {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := p.client.Do(req)
+	conv := p.conversationFor(c, requestData, messages)
+	images, err := p.loadImages(c.Request.Context(), messages)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("请求 Notion AI 失败: %v", err),
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: err.Error(), Type: "invalid_request_error"},
 		})
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Errorf("Notion AI 返回错误，状态码: %d, 响应: %s", resp.StatusCode, string(bodyBytes))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Notion AI 返回错误状态码: %d", resp.StatusCode),
+	// 从账号池选择账号发起推理，额度用尽时自动切换账号
+	defer p.releaseThreads(c)
+	inf, err := p.startInference(c, model.Codename, conv.preferredAccount(), !stream, p.payloadFor(c, conv, images, model))
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 取消已由 startInference 记录
+			return err
+		}
+		status := inferenceErrorStatus(err)
+		c.JSON(status, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: err.Error(), Type: openAIErrorType(status)},
 		})
-		return fmt.Errorf("状态码: %d", resp.StatusCode)
+		return err
 	}
+	defer inf.Close()
+	c.Header(headerNotionThread, conv.threadID)
+	promptTokens := estimatePromptTokens(requestData)
+	addUsage(c, promptTokens)
 
-	// 处理响应 - 先收集所有数据
-	var incrementalFragments []string
-	var finalMessage string
+	withReasoning := p.wantsReasoning(requestData, model)
 
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if stream {
+		if err := p.streamChatCompletion(c, inf, modelName, withReasoning, tools != nil); err != nil {
+			return err
+		}
+		conv.commit()
+		return nil
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	// 非流式响应 - 先收集所有数据
+	collector, err := inf.read(c.Request.Context(), nil)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 客户端已断开，无需再写响应
+			return err
+		}
+		if err == errShuttingDown {
+			c.JSON(http.StatusServiceUnavailable, utils.ErrorResponse{
+				Error: utils.ErrorDetail{Message: err.Error(), Type: "service_unavailable"},
+			})
+			return err
+		}
+		if nerr, ok := err.(*notionError); ok {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": nerr.Message})
+			return err
 		}
+		// 响应中途读取失败，已收到的内容不完整，不能作为正常结果返回
+		c.JSON(http.StatusBadGateway, utils.ErrorResponse{
+			Error: utils.ErrorDetail{Message: fmt.Sprintf("读取 Notion 响应失败: %v", err), Type: "upstream_error"},
+		})
+		return err
+	}
 
-		// 调试：打印原始响应行
-		log.Infof("收到响应行: %s", line)
-
-		parsedResults := p.parseNDJSONLine(line)
-		for _, result := range parsedResults {
-			textType, _ := result["type"].(string)
-			content, _ := result["content"].(string)
-
-			// 处理错误类型
-			if textType == "error" {
-				errorMsg, _ := result["error"].(string)
-				if stream {
-					errorData := utils.CreateErrorSSE(errorMsg)
-					c.Writer.Header().Set("Content-Type", "text/event-stream")
-					c.Writer.Write(errorData)
-					c.Writer.Write(utils.DoneChunk)
-				} else {
-					c.JSON(http.StatusPaymentRequired, gin.H{"error": errorMsg})
-				}
-				return fmt.Errorf(errorMsg)
-			}
+	fullResponse := collector.fullResponse()
+	if fullResponse == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "未能从 Notion 获取有效响应"})
+		return fmt.Errorf("空响应")
+	}
+
+	thinking, cleanedResponse := p.splitResponse(fullResponse)
+	logger.Infof("清洗后的最终响应: %s", logging.Content(cleanedResponse))
+	completionTokens := utils.EstimateTokens(cleanedResponse) + utils.EstimateTokens(thinking)
+	addUsage(c, completionTokens)
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": cleanedResponse,
+	}
+	if withReasoning && thinking != "" {
+		message["reasoning_content"] = thinking
+	}
 
-			if textType == "final" {
-				finalMessage = content
-			} else if textType == "incremental" {
-				incrementalFragments = append(incrementalFragments, content)
+	finishReason := "stop"
+	if tools != nil {
+		text, calls := extractToolCalls(logger, cleanedResponse)
+		if len(calls) > 0 {
+			finishReason = "tool_calls"
+			message["tool_calls"] = openAIToolCalls(calls)
+			if text == "" {
+				message["content"] = nil
+			} else {
+				message["content"] = text
 			}
 		}
 	}
 
-	if err := scanner.Err(); err != nil && err != io.EOF {
-		log.Errorf("读取响应流时出错: %v", err)
+	// 非流式响应（OpenAI 格式）
+	response := map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%s", uuid.New().String()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   modelName,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       message,
+				"finish_reason": finishReason,
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
 	}
+	conv.commit()
+	c.JSON(http.StatusOK, response)
+
+	return nil
+}
 
-	// 确定最终响应
-	var fullResponse string
-	if finalMessage != "" {
-		fullResponse = finalMessage
-		log.Info("成功从 record-map 或 Gemini patch/event 中提取到最终消息。")
-	} else if len(incrementalFragments) > 0 {
-		fullResponse = strings.Join(incrementalFragments, "")
-		log.Info("使用拼接所有增量片段的方式获得最终消息。")
+// streamChatCompletion 将 Notion 的增量 patch 实时转发为 OpenAI SSE 增量，
+// 结束时用 record-map/markdown-chat 给出的最终消息补发校正尾部。
+// withReasoning 为 true 时思考内容以 reasoning_content 增量发送，
+// withTools 为 true 时模型输出的调用块以 tool_calls 增量发送。
+func (p *NotionAIProvider) streamChatCompletion(c *gin.Context, inf *inference, modelName string, withReasoning, withTools bool) error {
+	logger := logging.FromContext(c.Request.Context())
+	requestID := fmt.Sprintf("chatcmpl-%s", uuid.New().String())
+	sse := newOpenAIStream(c, requestID, modelName)
+	metrics.ActiveStreams.WithLabelValues("openai").Inc()
+	defer metrics.ActiveStreams.WithLabelValues("openai").Dec()
+	streamer := newContentStreamer(c.Request.Context(), p, withReasoning)
+	streamer.withTools = withTools
+
+	collector, err := inf.read(c.Request.Context(), func(fragment string) {
+		sse.WriteDelta(streamer.Push(fragment))
+	})
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 客户端已断开，无需再写响应
+			return err
+		}
+		if err == errShuttingDown {
+			sse.WriteError(err.Error())
+			return err
+		}
+		if nerr, ok := err.(*notionError); ok {
+			sse.WriteError(nerr.Message)
+			return err
+		}
+		sse.WriteError(fmt.Sprintf("读取 Notion 响应失败: %v", err))
+		return err
 	}
 
+	fullResponse := collector.fullResponse()
 	if fullResponse == "" {
-		errorMsg := "未能从 Notion 获取有效响应"
-		if stream {
-			errorData := utils.CreateErrorSSE(errorMsg)
-			c.Writer.Header().Set("Content-Type", "text/event-stream")
-			c.Writer.Write(errorData)
-			c.Writer.Write(utils.DoneChunk)
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
-		}
+		sse.WriteError("未能从 Notion 获取有效响应")
 		return fmt.Errorf("空响应")
 	}
 
-	cleanedResponse := p.cleanContent(fullResponse)
-	log.Infof("清洗后的最终响应: %s", cleanedResponse)
-
-	if stream {
-		// 流式响应
-		c.Writer.Header().Set("Content-Type", "text/event-stream")
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
-		c.Writer.Header().Set("Transfer-Encoding", "chunked")
-
-		requestID := fmt.Sprintf("chatcmpl-%s", uuid.New().String())
-
-		// 发送角色块
-		role := "assistant"
-		roleChunk := utils.CreateChatCompletionChunk(requestID, modelName, nil, nil, &role)
-		c.Writer.Write(utils.CreateSSEData(roleChunk))
-		c.Writer.Flush()
-
-		// 发送内容
-		chunk := utils.CreateChatCompletionChunk(requestID, modelName, &cleanedResponse, nil, nil)
-		c.Writer.Write(utils.CreateSSEData(chunk))
-		c.Writer.Flush()
-
-		// 发送完成标记
-		finishReason := "stop"
-		finalChunk := utils.CreateChatCompletionChunk(requestID, modelName, nil, &finishReason, nil)
-		c.Writer.Write(utils.CreateSSEData(finalChunk))
-		c.Writer.Write(utils.DoneChunk)
-		c.Writer.Flush()
-	} else {
-		// 非流式响应（OpenAI 格式）
-		response := map[string]interface{}{
-			"id":      fmt.Sprintf("chatcmpl-%s", uuid.New().String()),
-			"object":  "chat.completion",
-			"created": time.Now().Unix(),
-			"model":   modelName,
-			"choices": []map[string]interface{}{
-				{
-					"index": 0,
-					"message": map[string]interface{}{
-						"role":    "assistant",
-						"content": cleanedResponse,
-					},
-					"finish_reason": "stop",
-				},
-			},
-			"usage": map[string]interface{}{
-				"prompt_tokens":     0,
-				"completion_tokens": 0,
-				"total_tokens":      0,
-			},
-		}
-		c.JSON(http.StatusOK, response)
+	delta, err := streamer.Finish(fullResponse)
+	if err != nil {
+		sse.WriteError(err.Error())
+		return err
 	}
-
+	sse.WriteDelta(delta)
+	logger.Infof("清洗后的最终响应: %s", logging.Content(streamer.Emitted()))
+	addUsage(c, utils.EstimateTokens(streamer.Emitted())+utils.EstimateTokens(streamer.EmittedThinking()))
+
+	// 发送完成标记
+	finishReason := "stop"
+	if streamer.ToolCallCount() > 0 {
+		finishReason = "tool_calls"
+	}
+	sse.Finish(finishReason)
 	return nil
 }
 
-// GetModels 获取模型列表
-func (p *NotionAIProvider) GetModels(c *gin.Context) error {
-	models := []ModelInfo{}
-	created := time.Now().Unix()
-	
-	for _, modelName := range p.config.KnownModels {
-		models = append(models, ModelInfo{
-			ID:      modelName,
-			Object:  "model",
-			Created: created,
-			OwnedBy: "lzA6",
-		})
+// headerNotionModel 响应头，返回请求实际使用的 Notion 模型代号
+const headerNotionModel = "X-Notion-Model"
+
+// resolveModel 按模型名、别名和别名规则查找模型配置，都不匹配时再查找模型发现到的 Notion 代号，并通过响应头返回解析结果。
+// 未知模型在 UNKNOWN_MODEL_POLICY=fallback 时使用默认模型的配置（保持请求中的模型名不变），reject 时返回 nil。
+func (p *NotionAIProvider) resolveModel(c *gin.Context, name string) *config.ModelSpec {
+	logger := logging.FromContext(c.Request.Context())
+	catalog := config.Models()
+
+	model, rule, ok := catalog.Resolve(name)
+	if !ok {
+		model, ok = p.discoveredSpec(name)
+	}
+	switch {
+	case ok && rule != "":
+		logger.Debugf("模型 %s 匹配别名规则 %s，使用 %s", name, rule, model.ID)
+	case !ok && p.config.UnknownModelPolicy == "reject":
+		logger.Warnf("未知模型 %s，已拒绝请求", name)
+		return nil
+	case !ok:
+		logger.Warnf("未知模型 %s，使用默认模型 %s", name, p.config.DefaultModel)
+		model, _ = catalog.Lookup(p.config.DefaultModel)
 	}
+	c.Header(headerNotionModel, model.Codename)
+	return model
+}
 
-	c.JSON(http.StatusOK, ModelResponse{
-		Object: "list",
-		Data:   models,
-	})
-	
-	return nil
+// modelForbiddenMessage 客户端 API Key 无权使用解析后的模型时返回给客户端的错误信息
+func modelForbiddenMessage(name string, model *config.ModelSpec) string {
+	if name == model.ID {
+		return fmt.Sprintf("API Key 无权使用模型 %s", name)
+	}
+	return fmt.Sprintf("API Key 无权使用模型 %s（%s 对应的模型）", model.ID, name)
 }
 
+// modelNotFoundMessage 模型不存在时返回给客户端的错误信息
+func modelNotFoundMessage(name string) string {
+	return fmt.Sprintf("模型 %s 不存在，可通过 /v1/models 查看可用模型", name)
+}
 
 // ChatCompletionAnthropic 处理 Anthropic Messages API 请求
 func (p *NotionAIProvider) ChatCompletionAnthropic(c *gin.Context, convertedData map[string]interface{}, originalData map[string]interface{}) error {
+	logger := logging.FromContext(c.Request.Context())
 	// 解析 stream 参数
 	stream := false
 	if streamVal, ok := originalData["stream"].(bool); ok {
@@ -811,112 +978,113 @@ func (p *NotionAIProvider) ChatCompletionAnthropic(c *gin.Context, convertedData
 		modelName = model
 	}
 
-	mappedModel := p.config.ModelMap[modelName]
-	if mappedModel == "" {
-		mappedModel = "anthropic-sonnet-alt-thinking"
+	model := p.resolveModel(c, modelName)
+	if model == nil {
+		message := modelNotFoundMessage(modelName)
+		c.JSON(http.StatusNotFound, gin.H{
+			"type":  "error",
+			"error": map[string]string{"type": "not_found_error", "message": message},
+		})
+		return errors.New(message)
 	}
-
-	// 确定线程类型
-	threadType := "workflow"
-	if strings.HasPrefix(mappedModel, "vertex-") {
-		threadType = "markdown-chat"
+	if !modelAllowed(c, model) {
+		message := modelForbiddenMessage(modelName, model)
+		c.JSON(http.StatusForbidden, gin.H{
+			"type":  "error",
+			"error": map[string]string{"type": "permission_error", "message": message},
+		})
+		return errors.New(message)
 	}
 
-	// 生成新的 thread ID
-	threadID := uuid.New().String()
-
-	// 准备请求载荷
-	payload := p.preparePayload(convertedData, threadID, mappedModel, threadType)
-	payload["createThread"] = true
-
-	// 发送请求到 Notion
-	jsonData, err := json.Marshal(payload)
+	tools, err := parseToolSession(convertedData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"type":  "error",
-			"error": map[string]string{"type": "api_error", "message": fmt.Sprintf("序列化请求失败: %v", err)},
+			"error": map[string]string{"type": "invalid_request_error", "message": err.Error()},
 		})
 		return err
 	}
 
-	log.Infof("请求 Notion AI URL: %s", p.apiEndpoints["runInference"])
-
-	req, err := http.NewRequest("POST", p.apiEndpoints["runInference"], bytes.NewBuffer(jsonData))
+	messages, err := p.parseMessages(c.Request.Context(), convertedData, tools)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"type":  "error",
-			"error": map[string]string{"type": "api_error", "message": fmt.Sprintf("创建请求失败: %v", err)},
+			"error": map[string]string{"type": "invalid_request_error", "message": err.Error()},
 		})
 		return err
 	}
-
-	for key, value := range p.prepareHeaders() {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := p.client.Do(req)
+	conv := p.conversationFor(c, convertedData, messages)
+	images, err := p.loadImages(c.Request.Context(), messages)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"type":  "error",
-			"error": map[string]string{"type": "api_error", "message": fmt.Sprintf("请求 Notion AI 失败: %v", err)},
+			"error": map[string]string{"type": "invalid_request_error", "message": err.Error()},
 		})
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Errorf("Notion AI 返回错误，状态码: %d, 响应: %s", resp.StatusCode, string(bodyBytes))
-		c.JSON(http.StatusInternalServerError, gin.H{
+	// 从账号池选择账号发起推理，额度用尽时自动切换账号
+	defer p.releaseThreads(c)
+	inf, err := p.startInference(c, model.Codename, conv.preferredAccount(), !stream, p.payloadFor(c, conv, images, model))
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 取消已由 startInference 记录
+			return err
+		}
+		status := inferenceErrorStatus(err)
+		c.JSON(status, gin.H{
 			"type":  "error",
-			"error": map[string]string{"type": "api_error", "message": fmt.Sprintf("Notion AI 返回错误状态码: %d", resp.StatusCode)},
+			"error": map[string]string{"type": anthropicErrorType(status), "message": err.Error()},
 		})
-		return fmt.Errorf("状态码: %d", resp.StatusCode)
+		return err
 	}
+	defer inf.Close()
+	c.Header(headerNotionThread, conv.threadID)
 
-	// 处理响应
-	var incrementalFragments []string
-	var finalMessage string
-
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		parsedResults := p.parseNDJSONLine(line)
-		for _, result := range parsedResults {
-			textType, _ := result["type"].(string)
-			content, _ := result["content"].(string)
+	messageID := fmt.Sprintf("msg_%s", uuid.New().String())
+	inputTokens := estimatePromptTokens(convertedData)
+	addUsage(c, inputTokens)
 
-			if textType == "error" {
-				errorMsg, _ := result["error"].(string)
-				c.JSON(http.StatusPaymentRequired, gin.H{
-					"type":  "error",
-					"error": map[string]string{"type": "api_error", "message": errorMsg},
-				})
-				return fmt.Errorf(errorMsg)
-			}
+	withThinking := p.wantsThinking(originalData, model)
 
-			if textType == "final" {
-				finalMessage = content
-			} else if textType == "incremental" {
-				incrementalFragments = append(incrementalFragments, content)
-			}
+	if stream {
+		if err := p.streamChatCompletionAnthropic(c, inf, messageID, modelName, inputTokens, withThinking, tools != nil); err != nil {
+			return err
 		}
+		conv.commit()
+		return nil
 	}
 
-	// 确定最终响应
-	var fullResponse string
-	if finalMessage != "" {
-		fullResponse = finalMessage
-	} else if len(incrementalFragments) > 0 {
-		fullResponse = strings.Join(incrementalFragments, "")
+	// 处理响应
+	collector, err := inf.read(c.Request.Context(), nil)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 客户端已断开，无需再写响应
+			return err
+		}
+		if err == errShuttingDown {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"type":  "error",
+				"error": map[string]string{"type": "overloaded_error", "message": err.Error()},
+			})
+			return err
+		}
+		if nerr, ok := err.(*notionError); ok {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"type":  "error",
+				"error": map[string]string{"type": "api_error", "message": nerr.Message},
+			})
+			return err
+		}
+		// 响应中途读取失败，已收到的内容不完整，不能作为正常结果返回
+		c.JSON(http.StatusBadGateway, gin.H{
+			"type":  "error",
+			"error": map[string]string{"type": "api_error", "message": fmt.Sprintf("读取 Notion 响应失败: %v", err)},
+		})
+		return err
 	}
 
+	fullResponse := collector.fullResponse()
 	if fullResponse == "" {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"type":  "error",
@@ -925,106 +1093,127 @@ func (p *NotionAIProvider) ChatCompletionAnthropic(c *gin.Context, convertedData
 		return fmt.Errorf("空响应")
 	}
 
-	cleanedResponse := p.cleanContent(fullResponse)
-	log.Infof("清洗后的最终响应: %s", cleanedResponse)
+	thinking, cleanedResponse := p.splitResponse(fullResponse)
+	logger.Infof("清洗后的最终响应: %s", logging.Content(cleanedResponse))
 
-	messageID := fmt.Sprintf("msg_%s", uuid.New().String())
+	content := []map[string]interface{}{}
+	outputTokens := utils.EstimateTokens(cleanedResponse)
+	if withThinking && thinking != "" {
+		content = append(content, map[string]interface{}{
+			"type":      "thinking",
+			"thinking":  thinking,
+			"signature": "",
+		})
+		outputTokens += utils.EstimateTokens(thinking)
+	}
 
-	if stream {
-		// 流式响应 (Anthropic SSE 格式)
-		c.Writer.Header().Set("Content-Type", "text/event-stream")
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
-
-		// message_start 事件
-		messageStart := map[string]interface{}{
-			"type": "message_start",
-			"message": map[string]interface{}{
-				"id":           messageID,
-				"type":         "message",
-				"role":         "assistant",
-				"content":      []interface{}{},
-				"model":        modelName,
-				"stop_reason":  nil,
-				"stop_sequence": nil,
-				"usage":        map[string]int{"input_tokens": 0, "output_tokens": 0},
-			},
-		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: message_start\ndata: %s\n\n", mustMarshal(messageStart))))
-
-		// content_block_start 事件
-		contentBlockStart := map[string]interface{}{
-			"type":  "content_block_start",
-			"index": 0,
-			"content_block": map[string]interface{}{
-				"type": "text",
-				"text": "",
-			},
-		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: content_block_start\ndata: %s\n\n", mustMarshal(contentBlockStart))))
-
-		// content_block_delta 事件
-		contentBlockDelta := map[string]interface{}{
-			"type":  "content_block_delta",
-			"index": 0,
-			"delta": map[string]interface{}{
-				"type": "text_delta",
-				"text": cleanedResponse,
-			},
+	stopReason := "end_turn"
+	var calls []toolCall
+	if tools != nil {
+		cleanedResponse, calls = extractToolCalls(logger, cleanedResponse)
+	}
+	if cleanedResponse != "" || len(calls) == 0 {
+		content = append(content, map[string]interface{}{
+			"type": "text",
+			"text": cleanedResponse,
+		})
+	}
+	if len(calls) > 0 {
+		stopReason = "tool_use"
+		content = append(content, anthropicToolUses(logger, calls)...)
+		for _, call := range calls {
+			outputTokens += utils.EstimateTokens(call.Arguments)
 		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: content_block_delta\ndata: %s\n\n", mustMarshal(contentBlockDelta))))
+	}
 
-		// content_block_stop 事件
-		contentBlockStop := map[string]interface{}{
-			"type":  "content_block_stop",
-			"index": 0,
-		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: content_block_stop\ndata: %s\n\n", mustMarshal(contentBlockStop))))
-
-		// message_delta 事件
-		messageDelta := map[string]interface{}{
-			"type": "message_delta",
-			"delta": map[string]interface{}{
-				"stop_reason":   "end_turn",
-				"stop_sequence": nil,
-			},
-			"usage": map[string]int{"output_tokens": len(cleanedResponse)},
-		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: message_delta\ndata: %s\n\n", mustMarshal(messageDelta))))
+	// 非流式响应 (Anthropic 格式)
+	response := map[string]interface{}{
+		"id":            messageID,
+		"type":          "message",
+		"role":          "assistant",
+		"content":       content,
+		"model":         modelName,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage": map[string]int{
+			"input_tokens":  inputTokens,
+			"output_tokens": outputTokens,
+		},
+	}
+	conv.commit()
+	c.JSON(http.StatusOK, response)
+	addUsage(c, outputTokens)
+
+	return nil
+}
 
-		// message_stop 事件
-		messageStop := map[string]interface{}{
-			"type": "message_stop",
+// streamChatCompletionAnthropic 以 Anthropic Messages SSE 协议实时转发 Notion 的增量 patch，
+// withThinking 为 true 时思考内容以 thinking 内容块发送，withTools 为 true 时解析工具调用并以 tool_use 内容块发送
+func (p *NotionAIProvider) streamChatCompletionAnthropic(c *gin.Context, inf *inference, messageID, modelName string, inputTokens int, withThinking, withTools bool) error {
+	logger := logging.FromContext(c.Request.Context())
+	sse := newAnthropicStream(c, messageID, modelName)
+	defer sse.Close()
+	metrics.ActiveStreams.WithLabelValues("anthropic").Inc()
+	defer metrics.ActiveStreams.WithLabelValues("anthropic").Dec()
+	sse.Start(inputTokens)
+
+	streamer := newContentStreamer(c.Request.Context(), p, withThinking)
+	streamer.withTools = withTools
+	collector, err := inf.read(c.Request.Context(), func(fragment string) {
+		sse.WriteDelta(streamer.Push(fragment))
+	})
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			// 客户端已断开，无需再写响应
+			return err
 		}
-		c.Writer.Write([]byte(fmt.Sprintf("event: message_stop\ndata: %s\n\n", mustMarshal(messageStop))))
-
-		c.Writer.Flush()
-	} else {
-		// 非流式响应 (Anthropic 格式)
-		response := map[string]interface{}{
-			"id":   messageID,
-			"type": "message",
-			"role": "assistant",
-			"content": []map[string]interface{}{
-				{
-					"type": "text",
-					"text": cleanedResponse,
-				},
-			},
-			"model":         modelName,
-			"stop_reason":   "end_turn",
-			"stop_sequence": nil,
-			"usage": map[string]int{
-				"input_tokens":  0,
-				"output_tokens": len(cleanedResponse),
-			},
+		if err == errShuttingDown {
+			sse.WriteError("overloaded_error", err.Error())
+			return err
 		}
-		c.JSON(http.StatusOK, response)
+		if nerr, ok := err.(*notionError); ok {
+			sse.WriteError("api_error", nerr.Message)
+			return err
+		}
+		sse.WriteError("api_error", fmt.Sprintf("读取 Notion 响应失败: %v", err))
+		return err
+	}
+
+	fullResponse := collector.fullResponse()
+	if fullResponse == "" {
+		sse.WriteError("api_error", "未能从 Notion 获取有效响应")
+		return fmt.Errorf("空响应")
 	}
 
+	delta, err := streamer.Finish(fullResponse)
+	if err != nil {
+		sse.WriteError("api_error", err.Error())
+		return err
+	}
+	sse.WriteDelta(delta)
+	logger.Infof("清洗后的最终响应: %s", logging.Content(streamer.Emitted()))
+
+	outputTokens := utils.EstimateTokens(streamer.Emitted()) + utils.EstimateTokens(streamer.EmittedThinking())
+	stopReason := "end_turn"
+	if streamer.ToolCallCount() > 0 {
+		stopReason = "tool_use"
+	}
+	sse.Finish(stopReason, outputTokens)
+	addUsage(c, outputTokens)
 	return nil
 }
 
+// estimatePromptTokens 估算请求中所有消息的 token 数
+func estimatePromptTokens(requestData map[string]interface{}) int {
+	total := 0
+	for _, msgMap := range requestMessages(requestData) {
+		role, _ := msgMap["role"].(string)
+		content, _, _ := messageContent(role, msgMap["content"])
+		total += utils.EstimateTokens(content)
+	}
+	return total
+}
+
 // mustMarshal JSON 序列化，忽略错误
 func mustMarshal(v interface{}) string {
 	data, _ := json.Marshal(v)
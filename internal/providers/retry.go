@@ -0,0 +1,106 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// errEmptyResponse Notion 的响应流在给出任何内容之前就结束了
+var errEmptyResponse = errors.New("未能从 Notion 获取有效响应")
+
+// retryableError 判断发起推理的错误是否值得重试：连接错误（超时除外）、非流式请求读取途中断开的连接、
+// 429、502–504 以及空响应。额度用尽由 startInference 换账号处理，不计入重试次数。
+func retryableError(err error) bool {
+	var status *upstreamStatusError
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, errEmptyResponse):
+		return true
+	case errors.As(err, &status):
+		switch status.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case errors.As(err, &urlErr):
+		return !urlErr.Timeout() && !errors.Is(err, context.Canceled)
+	default:
+		return connectionBroken(err)
+	}
+}
+
+// connectionBroken 判断读取响应途中连接是否断开（unexpected EOF、连接被重置等），超时不算
+func connectionBroken(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// breakerFailure 判断发起推理的错误是否说明账号或 Notion 出了问题，计入账号的连续失败次数和熔断：
+// 连接错误（包括读取途中断开）、429 和 5xx。请求本身的 4xx 错误换账号也不会成功，不计入。
+func breakerFailure(err error) bool {
+	var status *upstreamStatusError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &status):
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= http.StatusInternalServerError
+	case errors.As(err, &urlErr):
+		return !errors.Is(err, context.Canceled)
+	default:
+		return connectionBroken(err)
+	}
+}
+
+// retryDelay 第 attempt 次重试前的等待时间：指数退避加随机抖动，Notion 通过 Retry-After 要求更长的等待时以它为准，
+// 都不超过 UPSTREAM_RETRY_MAX_DELAY
+func (p *NotionAIProvider) retryDelay(attempt int, err error) time.Duration {
+	base := time.Duration(p.config.UpstreamRetryBaseDelay) * time.Millisecond
+	max := time.Duration(p.config.UpstreamRetryMaxDelay) * time.Millisecond
+
+	delay := base << (attempt - 1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	// 在 [delay/2, delay) 之间随机，避免多个请求同时重试
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+
+	var status *upstreamStatusError
+	if errors.As(err, &status) && status.RetryAfter > delay {
+		delay = status.RetryAfter
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// waitRetry 等待重试，客户端断开或服务停机时提前返回错误
+func (p *NotionAIProvider) waitRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.abortContext().Done():
+		return errShuttingDown
+	}
+}
+
+// retryAfter 解析以秒为单位的 Retry-After 响应头
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
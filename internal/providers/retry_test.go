@@ -0,0 +1,75 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"notion-2api-go/internal/mocknotion"
+	"strings"
+	"testing"
+)
+
+func TestRetryAndBreakerClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		breaker   bool
+		status    int
+	}{
+		{"400", &upstreamStatusError{StatusCode: http.StatusBadRequest}, false, false, http.StatusBadRequest},
+		{"401", &upstreamStatusError{StatusCode: http.StatusUnauthorized}, false, false, http.StatusBadGateway},
+		{"403", &upstreamStatusError{StatusCode: http.StatusForbidden}, false, false, http.StatusBadGateway},
+		{"404", &upstreamStatusError{StatusCode: http.StatusNotFound}, false, false, http.StatusNotFound},
+		{"429", &upstreamStatusError{StatusCode: http.StatusTooManyRequests}, true, true, http.StatusTooManyRequests},
+		{"500", &upstreamStatusError{StatusCode: http.StatusInternalServerError}, false, true, http.StatusBadGateway},
+		{"503", &upstreamStatusError{StatusCode: http.StatusServiceUnavailable}, true, true, http.StatusBadGateway},
+		{"连接错误", &url.Error{Op: "Post", URL: "https://www.notion.so", Err: errors.New("connection refused")}, true, true, http.StatusBadGateway},
+		{"客户端取消", &url.Error{Op: "Post", URL: "https://www.notion.so", Err: context.Canceled}, false, false, http.StatusBadGateway},
+		{"空响应", errEmptyResponse, true, false, http.StatusBadGateway},
+		{"读取途中断开", fmt.Errorf("读取 Notion 响应失败: %w", io.ErrUnexpectedEOF), true, true, http.StatusBadGateway},
+		{"额度用尽", &notionError{Message: "额度已用尽", Quota: true}, false, false, http.StatusPaymentRequired},
+		{"无效请求", &invalidRequestError{Message: "bad"}, false, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := retryableError(tt.err); got != tt.retryable {
+			t.Errorf("%s: retryableError() = %v, want %v", tt.name, got, tt.retryable)
+		}
+		if got := breakerFailure(tt.err); got != tt.breaker {
+			t.Errorf("%s: breakerFailure() = %v, want %v", tt.name, got, tt.breaker)
+		}
+		if got := inferenceErrorStatus(tt.err); got != tt.status {
+			t.Errorf("%s: inferenceErrorStatus() = %d, want %d", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestBufferedInferenceRetriesBrokenStream(t *testing.T) {
+	useBuiltinModels(t)
+	// 第一次推理发送一个片段后断开连接，之后回显用户消息
+	server := httptest.NewServer(mocknotion.New(&mocknotion.Script{Scenarios: []mocknotion.Scenario{
+		{Name: "cut", Cut: true, Times: 1},
+	}}).Handler())
+	defer server.Close()
+
+	cfg := cassetteTestConfig(server.URL, "off", "")
+	cfg.UpstreamMaxRetries = 1
+	cfg.CircuitBreakerThreshold = 5
+	p, err := NewNotionAIProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	status, content := completion(t, p, "断线重试")
+	if status != http.StatusOK || !strings.Contains(content, "断线重试") {
+		t.Fatalf("completion = %d %q, want 200 with the echoed message", status, content)
+	}
+	if got := p.accounts.Status()[0].Requests; got != 2 {
+		t.Errorf("account requests = %d, want 2 (the broken attempt and the retry)", got)
+	}
+}
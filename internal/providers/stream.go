@@ -0,0 +1,730 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"notion-2api-go/internal/logging"
+	"notion-2api-go/internal/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// notionError Notion 在 NDJSON 流中返回的错误事件（如额度用尽）
+type notionError struct {
+	Message string
+	// Quota 为 true 表示账号额度用尽，Current/Total 为 Notion 返回的用量
+	Quota   bool
+	Current int
+	Total   int
+}
+
+func (e *notionError) Error() string {
+	return e.Message
+}
+
+// newNotionError 由 parseNDJSONLine 返回的 error 结果构造错误
+func newNotionError(result map[string]interface{}) *notionError {
+	nerr := &notionError{}
+	nerr.Message, _ = result["error"].(string)
+	nerr.Quota, _ = result["quota"].(bool)
+	nerr.Current, _ = result["current"].(int)
+	nerr.Total, _ = result["total"].(int)
+	return nerr
+}
+
+// inferenceCollector 收集一次推理过程中的增量片段和最终消息
+type inferenceCollector struct {
+	incrementalFragments []string
+	finalMessage         string
+
+	log *log.Entry
+
+	// 读取进度，用于记录被取消的推理
+	linesRead     int
+	bytesRead     int
+	fragmentBytes int
+}
+
+// fullResponse 确定最终响应：优先使用 record-map/markdown-chat 给出的完整消息，否则拼接增量片段
+func (ic *inferenceCollector) fullResponse() string {
+	if ic.finalMessage != "" {
+		ic.log.Info("成功从 record-map 或 Gemini patch/event 中提取到最终消息。")
+		return ic.finalMessage
+	}
+	if len(ic.incrementalFragments) > 0 {
+		ic.log.Info("使用拼接所有增量片段的方式获得最终消息。")
+		return strings.Join(ic.incrementalFragments, "")
+	}
+	return ""
+}
+
+// readInference 逐行读取 Notion 返回的 NDJSON 流。
+// 每解析出一个增量片段就立即调用 onIncremental（可为 nil），
+// 遇到 Notion 错误事件时返回 *notionError，
+// ctx 被取消（客户端断开）时返回 ctx 的错误，由调用方记录推理进度。
+func (p *NotionAIProvider) readInference(ctx context.Context, body io.Reader, onIncremental func(string)) (*inferenceCollector, error) {
+	collector := &inferenceCollector{log: logging.FromContext(ctx)}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		collector.linesRead++
+		collector.bytesRead += len(line) + 1
+		if line == "" {
+			continue
+		}
+
+		// 调试：打印原始响应行
+		collector.log.Debugf("收到响应行: %s", logging.Content(line))
+
+		for _, result := range p.parseNDJSONLine(ctx, line) {
+			textType, _ := result["type"].(string)
+			content, _ := result["content"].(string)
+
+			switch textType {
+			case "error":
+				return collector, newNotionError(result)
+			case "final":
+				collector.finalMessage = content
+			case "incremental":
+				collector.incrementalFragments = append(collector.incrementalFragments, content)
+				collector.fragmentBytes += len(content)
+				if onIncremental != nil {
+					onIncremental(content)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		if ctx.Err() != nil {
+			return collector, ctx.Err()
+		}
+		collector.log.Errorf("读取响应流时出错: %v", err)
+		return collector, err
+	}
+
+	return collector, nil
+}
+
+// streamDelta 一次可以发送给客户端的增量
+type streamDelta struct {
+	Thinking  string
+	Text      string
+	ToolCalls []toolCall
+}
+
+// maxTagLen 流式输出时暂缓的未闭合标签的最大长度，更长的内容按普通文本输出
+const maxTagLen = 64
+
+// headLimit 正文开头最多暂缓的字节数。开头的噪音文本按行清洗，需要等第一行完整后再输出
+const headLimit = 1024
+
+// contentStreamer 将增量片段实时拆分、清洗后输出。
+// 片段按到达顺序增量解析，只保留尚未确定归属的末尾内容（可能是标签开头），每个片段只处理一次。
+// 未开启思考输出时，思考内容会被丢弃；开启工具调用时，<tool_call> 块会被解析为调用而不是正文，
+// 第一个调用块之后的正文暂缓到 Finish 再发送。
+type contentStreamer struct {
+	p            *NotionAIProvider
+	log          *log.Entry
+	withThinking bool
+	withTools    bool
+
+	// pending 尚未确定归属的原始内容
+	pending string
+	// block 当前所在的块：空为正文，thinking/thought 为思考块，tool_call 为调用块
+	block string
+	// skipSpace 思考块结束后跳过紧随的空白
+	skipSpace bool
+	// skipNewlines lang 标记之后跳过紧随的换行
+	skipNewlines  bool
+	afterToolCall bool
+
+	head     strings.Builder
+	headDone bool
+
+	text          textEmitter
+	thinking      textEmitter
+	toolCallsSent int
+}
+
+func newContentStreamer(ctx context.Context, p *NotionAIProvider, withThinking bool) *contentStreamer {
+	return &contentStreamer{p: p, log: logging.FromContext(ctx), withThinking: withThinking}
+}
+
+// Push 追加一个原始片段，返回可以立即发送给客户端的新增内容
+func (s *contentStreamer) Push(fragment string) streamDelta {
+	s.pending += fragment
+	delta := streamDelta{}
+	for {
+		switch s.block {
+		case "":
+			if s.skipSpace {
+				s.pending = strings.TrimLeft(s.pending, " \t\r\n")
+				if s.pending == "" {
+					return delta
+				}
+				s.skipSpace = false
+			}
+			open, tag := s.nextTag()
+			if open < 0 {
+				cut := partialTagStart(s.pending)
+				s.writeText(&delta, s.pending[:cut])
+				s.pending = s.pending[cut:]
+				return delta
+			}
+			s.writeText(&delta, s.pending[:open])
+			s.pending = s.pending[open+len(tag)+2:]
+			s.block = tag
+			if tag == "tool_call" {
+				s.afterToolCall = true
+			}
+		case "tool_call":
+			end := strings.Index(s.pending, toolCallClose)
+			if end < 0 {
+				return delta
+			}
+			if call, ok := parseToolCall(strings.TrimSpace(s.pending[:end])); ok {
+				delta.ToolCalls = append(delta.ToolCalls, call)
+				s.toolCallsSent++
+			}
+			s.pending = s.pending[end+len(toolCallClose):]
+			s.block = ""
+		default:
+			closeTag := "</" + s.block + ">"
+			end := strings.Index(s.pending, closeTag)
+			if end < 0 {
+				cut := partialTagStart(s.pending)
+				s.writeThinking(&delta, s.pending[:cut])
+				s.pending = s.pending[cut:]
+				return delta
+			}
+			s.writeThinking(&delta, s.pending[:end])
+			s.thinking.endPart()
+			s.pending = s.pending[end+len(closeTag):]
+			s.block = ""
+			s.skipSpace = true
+		}
+	}
+}
+
+// nextTag 返回 pending 中最早出现的思考块或调用块开始标签的位置和标签名
+func (s *contentStreamer) nextTag() (int, string) {
+	tags := thinkingTags
+	if s.withTools {
+		tags = append(tags[:len(tags):len(tags)], "tool_call")
+	}
+	open, tag := -1, ""
+	for _, t := range tags {
+		if i := strings.Index(s.pending, "<"+t+">"); i >= 0 && (open < 0 || i < open) {
+			open, tag = i, t
+		}
+	}
+	return open, tag
+}
+
+// writeText 清洗正文并加入增量。开头的内容先暂缓，第一行完整后再按整段清洗
+func (s *contentStreamer) writeText(delta *streamDelta, text string) {
+	if text == "" || s.afterToolCall {
+		return
+	}
+	if !s.headDone {
+		s.head.WriteString(text)
+		cleaned := stripNoise(s.head.String())
+		if !strings.Contains(cleaned, "\n") && s.head.Len() < headLimit {
+			return
+		}
+		s.headDone = true
+		s.head.Reset()
+		text = cleaned
+	} else {
+		text = s.stripLangTags(text)
+	}
+	delta.Text += s.text.write(text)
+}
+
+// stripLangTags 去掉正文中的 lang 标记及其后的换行
+func (s *contentStreamer) stripLangTags(text string) string {
+	if s.skipNewlines {
+		text = strings.TrimLeft(text, "\n")
+		if text == "" {
+			return ""
+		}
+		s.skipNewlines = false
+	}
+	if matches := langTagPattern.FindAllStringIndex(text, -1); len(matches) > 0 {
+		s.skipNewlines = matches[len(matches)-1][1] == len(text)
+		text = langTagPattern.ReplaceAllString(text, "")
+	}
+	return text
+}
+
+func (s *contentStreamer) writeThinking(delta *streamDelta, text string) {
+	if !s.withThinking {
+		return
+	}
+	delta.Thinking += s.thinking.write(text)
+}
+
+// Finish 用最终消息校正已发送内容，返回需要补发的尾部，只有空白不同时视为一致。
+// 已发送内容不是最终消息的前缀（流与最终消息不一致）时无法撤回，返回错误，调用方应以错误事件结束流。
+func (s *contentStreamer) Finish(fullResponse string) (streamDelta, error) {
+	thinking, answer := s.p.splitResponse(fullResponse)
+	delta := streamDelta{}
+	if s.withTools {
+		var calls []toolCall
+		answer, calls = extractToolCalls(s.log, answer)
+		delta = s.newToolCalls(calls)
+	}
+	text, ok := s.text.finish(answer)
+	if !ok {
+		s.log.Warnf("流式输出与最终消息不一致，已发送 %d 字节，最终消息 %d 字节", len(s.text.String()), len(answer))
+		return streamDelta{}, errStreamDiverged
+	}
+	delta.Text = text
+	if s.withThinking {
+		thinkingTail, ok := s.thinking.finish(thinking)
+		if !ok {
+			s.log.Warnf("流式输出的思考内容与最终消息不一致，已发送 %d 字节，最终消息 %d 字节", len(s.thinking.String()), len(thinking))
+			return streamDelta{}, errStreamDiverged
+		}
+		delta.Thinking = thinkingTail
+	}
+	return delta, nil
+}
+
+// errStreamDiverged 已发送的内容与 Notion 的最终消息不一致，剩余内容无法补发
+var errStreamDiverged = errors.New("流式输出与 Notion 返回的最终消息不一致，回复可能不完整，请重试")
+
+// newToolCalls 返回尚未发送的工具调用
+func (s *contentStreamer) newToolCalls(calls []toolCall) streamDelta {
+	if len(calls) <= s.toolCallsSent {
+		return streamDelta{}
+	}
+	delta := streamDelta{ToolCalls: calls[s.toolCallsSent:]}
+	s.toolCallsSent = len(calls)
+	return delta
+}
+
+// ToolCallCount 返回已发送的工具调用数量
+func (s *contentStreamer) ToolCallCount() int {
+	return s.toolCallsSent
+}
+
+// Emitted 返回已发送的正文
+func (s *contentStreamer) Emitted() string {
+	return s.text.String()
+}
+
+// EmittedThinking 返回已发送的思考内容
+func (s *contentStreamer) EmittedThinking() string {
+	return s.thinking.String()
+}
+
+// partialTagStart 返回末尾可能是标签开头（尚未出现 '>'）的内容的起始位置，没有时返回 len(s)
+func partialTagStart(s string) int {
+	if idx := strings.LastIndex(s, "<"); idx >= 0 && len(s)-idx <= maxTagLen && !strings.Contains(s[idx:], ">") {
+		return idx
+	}
+	return len(s)
+}
+
+// textEmitter 累积已发送的内容，与 splitThinking/cleanContent 的结果保持一致：
+// 去掉开头的空白，末尾的空白暂缓到后面有内容时再发送，多段内容（思考块）之间以空行分隔
+type textEmitter struct {
+	emitted strings.Builder
+	// space 暂缓的末尾空白
+	space string
+	// newPart 下一段非空内容属于新的一段
+	newPart bool
+}
+
+func (e *textEmitter) write(s string) string {
+	if e.newPart || e.emitted.Len() == 0 {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return ""
+		}
+		if e.newPart && e.emitted.Len() > 0 {
+			s = "\n\n" + s
+		}
+		e.newPart = false
+		e.space = ""
+	}
+	body := strings.TrimRight(s, " \t\r\n")
+	if body == "" {
+		e.space += s
+		return ""
+	}
+	out := e.space + body
+	e.space = s[len(body):]
+	e.emitted.WriteString(out)
+	return out
+}
+
+// endPart 当前段结束，丢弃暂缓的末尾空白
+func (e *textEmitter) endPart() {
+	e.space = ""
+	e.newPart = true
+}
+
+// finish 返回最终内容中尚未发送的尾部。Notion 在最终消息中可能调整空白（例如合并空格、改变换行数），
+// 比较时连续的空白视为相同；除空白外已发送内容不是最终内容的前缀时返回 false
+func (e *textEmitter) finish(final string) (string, bool) {
+	end, ok := matchPrefix(final, e.emitted.String())
+	if !ok {
+		return "", false
+	}
+	tail := final[end:]
+	e.emitted.WriteString(tail)
+	return tail, true
+}
+
+// matchPrefix 忽略空白差异判断 prefix 是否为 s 的前缀，返回 s 中与 prefix 对应部分的结束位置。
+// prefix 中的一段空白对应 s 中的一段空白，只有 prefix 末尾的空白可以对应 s 中没有空白的位置。
+func matchPrefix(s, prefix string) (int, bool) {
+	i, j := 0, 0
+	for j < len(prefix) {
+		if !isSpace(prefix[j]) {
+			if i >= len(s) || s[i] != prefix[j] {
+				return 0, false
+			}
+			i++
+			j++
+			continue
+		}
+		for j < len(prefix) && isSpace(prefix[j]) {
+			j++
+		}
+		start := i
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == start && j < len(prefix) {
+			return 0, false
+		}
+	}
+	return i, true
+}
+
+// isSpace 是否为 ASCII 空白，多字节字符的各个字节都不会被当作空白
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+func (e *textEmitter) String() string {
+	return e.emitted.String()
+}
+
+// openAIStream 按需写出 OpenAI 格式的 SSE 流，第一次写入时才发送响应头和角色块
+type openAIStream struct {
+	c             *gin.Context
+	requestID     string
+	model         string
+	started       bool
+	toolCallIndex int
+}
+
+func newOpenAIStream(c *gin.Context, requestID, model string) *openAIStream {
+	return &openAIStream{c: c, requestID: requestID, model: model}
+}
+
+func (s *openAIStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.c.Writer.Header().Set("Content-Type", "text/event-stream")
+	s.c.Writer.Header().Set("Cache-Control", "no-cache")
+	s.c.Writer.Header().Set("Connection", "keep-alive")
+	s.c.Writer.Header().Set("Transfer-Encoding", "chunked")
+
+	// 发送角色块
+	role := "assistant"
+	roleChunk := utils.CreateChatCompletionChunk(s.requestID, s.model, nil, nil, &role)
+	s.c.Writer.Write(utils.CreateSSEData(roleChunk))
+	s.c.Writer.Flush()
+}
+
+// WriteDelta 发送思考内容和正文增量
+func (s *openAIStream) WriteDelta(delta streamDelta) {
+	if delta.Thinking != "" {
+		s.writeChunk(map[string]interface{}{"reasoning_content": delta.Thinking})
+	}
+	if delta.Text != "" {
+		s.writeChunk(map[string]interface{}{"content": delta.Text})
+	}
+	for _, call := range delta.ToolCalls {
+		s.writeChunk(map[string]interface{}{
+			"tool_calls": []map[string]interface{}{
+				{
+					"index": s.toolCallIndex,
+					"id":    call.ID,
+					"type":  "function",
+					"function": map[string]interface{}{
+						"name":      call.Name,
+						"arguments": call.Arguments,
+					},
+				},
+			},
+		})
+		s.toolCallIndex++
+	}
+}
+
+func (s *openAIStream) writeChunk(delta map[string]interface{}) {
+	s.start()
+	chunk := utils.CreateChatCompletionDeltaChunk(s.requestID, s.model, delta, nil)
+	s.c.Writer.Write(utils.CreateSSEData(chunk))
+	s.c.Writer.Flush()
+}
+
+// Finish 发送完成标记
+func (s *openAIStream) Finish(finishReason string) {
+	s.start()
+	finalChunk := utils.CreateChatCompletionChunk(s.requestID, s.model, nil, &finishReason, nil)
+	s.c.Writer.Write(utils.CreateSSEData(finalChunk))
+	s.c.Writer.Write(utils.DoneChunk)
+	s.c.Writer.Flush()
+}
+
+// WriteError 发送错误事件并结束流
+func (s *openAIStream) WriteError(message string) {
+	if !s.started {
+		s.c.Writer.Header().Set("Content-Type", "text/event-stream")
+	}
+	s.started = true
+	s.c.Writer.Write(utils.CreateErrorSSE(message))
+	s.c.Writer.Write(utils.DoneChunk)
+	s.c.Writer.Flush()
+}
+
+// anthropicPingInterval Anthropic 流中 ping 事件的发送间隔
+const anthropicPingInterval = 10 * time.Second
+
+// anthropicStream 写出 Anthropic Messages 协议的 SSE 流。
+// 后台定期发送 ping 事件，因此所有写入都需要加锁。
+type anthropicStream struct {
+	c         *gin.Context
+	messageID string
+	model     string
+
+	mu         sync.Mutex
+	blockIndex int
+	blockType  string
+	finished   bool
+
+	stopPing chan struct{}
+	pingDone chan struct{}
+}
+
+func newAnthropicStream(c *gin.Context, messageID, model string) *anthropicStream {
+	return &anthropicStream{
+		c:          c,
+		messageID:  messageID,
+		model:      model,
+		blockIndex: -1,
+	}
+}
+
+// Start 发送响应头和 message_start 事件，并开始定期发送 ping
+func (s *anthropicStream) Start(inputTokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.c.Writer.Header().Set("Content-Type", "text/event-stream")
+	s.c.Writer.Header().Set("Cache-Control", "no-cache")
+	s.c.Writer.Header().Set("Connection", "keep-alive")
+
+	s.writeEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            s.messageID,
+			"type":          "message",
+			"role":          "assistant",
+			"content":       []interface{}{},
+			"model":         s.model,
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": inputTokens, "output_tokens": 0},
+		},
+	})
+	s.writeEvent("ping", map[string]interface{}{"type": "ping"})
+
+	s.stopPing = make(chan struct{})
+	s.pingDone = make(chan struct{})
+	go s.pingLoop(s.stopPing, s.pingDone)
+}
+
+func (s *anthropicStream) pingLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(anthropicPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.c.Request.Context().Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if !s.finished {
+				s.writeEvent("ping", map[string]interface{}{"type": "ping"})
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close 停止 ping，必须在处理函数返回前调用
+func (s *anthropicStream) Close() {
+	s.mu.Lock()
+	s.finished = true
+	stop := s.stopPing
+	s.stopPing = nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-s.pingDone
+	}
+}
+
+// WriteDelta 发送思考内容、正文和工具调用增量，必要时先打开对应的内容块
+func (s *anthropicStream) WriteDelta(delta streamDelta) {
+	if delta.Thinking == "" && delta.Text == "" && len(delta.ToolCalls) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	if delta.Thinking != "" {
+		if s.blockType != "thinking" {
+			s.startBlock(map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""})
+		}
+		s.writeEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": map[string]interface{}{
+				"type":     "thinking_delta",
+				"thinking": delta.Thinking,
+			},
+		})
+	}
+	if delta.Text != "" {
+		if s.blockType != "text" {
+			s.startBlock(map[string]interface{}{"type": "text", "text": ""})
+		}
+		s.writeEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": delta.Text,
+			},
+		})
+	}
+	// 工具调用在模型输出完整调用块后才会出现，每个调用以一个完整的 input_json_delta 发送
+	for _, call := range delta.ToolCalls {
+		s.startBlock(map[string]interface{}{
+			"type":  "tool_use",
+			"id":    anthropicToolUseID(call.ID),
+			"name":  call.Name,
+			"input": map[string]interface{}{},
+		})
+		s.writeEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": map[string]interface{}{
+				"type":         "input_json_delta",
+				"partial_json": call.Arguments,
+			},
+		})
+		s.stopBlock()
+	}
+}
+
+// Finish 关闭当前内容块并发送 message_delta 和 message_stop
+func (s *anthropicStream) Finish(stopReason string, outputTokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	if s.blockIndex < 0 {
+		// 保证消息至少包含一个内容块
+		s.startBlock(map[string]interface{}{"type": "text", "text": ""})
+	}
+	s.stopBlock()
+	s.writeEvent("message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+		},
+		"usage": map[string]int{"output_tokens": outputTokens},
+	})
+	s.writeEvent("message_stop", map[string]interface{}{"type": "message_stop"})
+	s.finished = true
+}
+
+// WriteError 发送 error 事件并结束流
+func (s *anthropicStream) WriteError(errType, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return
+	}
+	s.writeEvent("error", map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+	s.finished = true
+}
+
+// startBlock 关闭上一个内容块并打开新的内容块，调用方需持有锁
+func (s *anthropicStream) startBlock(block map[string]interface{}) {
+	s.stopBlock()
+	s.blockIndex++
+	s.blockType, _ = block["type"].(string)
+	s.writeEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.blockIndex,
+		"content_block": block,
+	})
+}
+
+// stopBlock 关闭当前内容块，调用方需持有锁
+func (s *anthropicStream) stopBlock() {
+	if s.blockType == "" {
+		return
+	}
+	s.writeEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.blockIndex,
+	})
+	s.blockType = ""
+}
+
+// writeEvent 写出一个 SSE 事件并立即刷新，调用方需持有锁
+func (s *anthropicStream) writeEvent(event string, data interface{}) {
+	s.c.Writer.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, mustMarshal(data))))
+	s.c.Writer.Flush()
+}
@@ -0,0 +1,195 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// pushAll 按 size 个字符一片推送原始响应，返回流式发送的正文、思考内容和调用数
+func pushAll(s *contentStreamer, raw string, size int) (text, thinking string, calls int) {
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		delta := s.Push(string(runes[i:end]))
+		text += delta.Text
+		thinking += delta.Thinking
+		calls += len(delta.ToolCalls)
+	}
+	return text, thinking, calls
+}
+
+func TestContentStreamerMatchesFinalMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		tools     bool
+		text      string
+		thinking  string
+		toolCalls int
+	}{
+		{"纯文本", "你好，世界！\n第二行内容。", false, "你好，世界！\n第二行内容。", "", 0},
+		{"前后空白", "  \n回答  \n\n", false, "回答", "", 0},
+		{"思考块", "<thinking>先想一想</thinking>\n\n答案是 42。", false, "答案是 42。", "先想一想", 0},
+		{"多个思考块", "<thinking> 一 </thinking>开头<thought>二</thought> 结尾", false, "开头结尾", "一\n\n二", 0},
+		{"lang 标记", "<lang primary=\"zh-CN\"/>\n第一行\n第二行<lang primary=\"en\"/>\n\n第三行", false, "第一行\n第二行第三行", "", 0},
+		{"比较符号", "a < b 并且 c > d，还有 x<y", false, "a < b 并且 c > d，还有 x<y", "", 0},
+		{"开头的噪音文本", "This is a straightforward question about my identity asan AI assistant.\n我是 Notion AI。", false, "我是 Notion AI。", "", 0},
+		{"工具调用", "我来查一下。\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"北京\"}}\n</tool_call>", true, "我来查一下。", "", 1},
+		{"调用后的正文", "<tool_call>{\"name\": \"a\", \"arguments\": {}}</tool_call>\n之后的说明", true, "之后的说明", "", 1},
+		{"未开启工具时保留调用块", "<tool_call>{\"name\": \"a\"}</tool_call>", false, "<tool_call>{\"name\": \"a\"}</tool_call>", "", 0},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{1, 3, 1000} {
+			s := newContentStreamer(context.Background(), &NotionAIProvider{}, true)
+			s.withTools = tt.tools
+			text, thinking, calls := pushAll(s, tt.raw, size)
+
+			delta, err := s.Finish(tt.raw)
+			if err != nil {
+				t.Fatalf("%s (片段 %d): Finish() error = %v，已发送 %q", tt.name, size, err, text)
+			}
+			text += delta.Text
+			thinking += delta.Thinking
+			calls += len(delta.ToolCalls)
+
+			if text != tt.text || thinking != tt.thinking || calls != tt.toolCalls {
+				t.Errorf("%s (片段 %d): got text %q thinking %q calls %d, want %q %q %d",
+					tt.name, size, text, thinking, calls, tt.text, tt.thinking, tt.toolCalls)
+			}
+			if !utf8.ValidString(text) {
+				t.Errorf("%s (片段 %d): 发送的正文不是有效的 UTF-8", tt.name, size)
+			}
+		}
+	}
+}
+
+func TestContentStreamerWithoutThinking(t *testing.T) {
+	s := newContentStreamer(context.Background(), &NotionAIProvider{}, false)
+	raw := "<thinking>不应发送</thinking>正文\n"
+	text, thinking, _ := pushAll(s, raw, 2)
+	delta, err := s.Finish(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thinking != "" || delta.Thinking != "" {
+		t.Errorf("thinking = %q, want empty", thinking+delta.Thinking)
+	}
+	if text+delta.Text != "正文" {
+		t.Errorf("text = %q, want %q", text+delta.Text, "正文")
+	}
+}
+
+func TestContentStreamerDiverged(t *testing.T) {
+	s := newContentStreamer(context.Background(), &NotionAIProvider{}, false)
+	pushAll(s, "第一版回答\n后续内容", 4)
+	if _, err := s.Finish("完全不同的最终消息"); err != errStreamDiverged {
+		t.Fatalf("Finish() error = %v, want errStreamDiverged", err)
+	}
+}
+
+func TestSplitThinking(t *testing.T) {
+	tests := []struct {
+		raw      string
+		thinking string
+		answer   string
+	}{
+		{"没有思考", "", "没有思考"},
+		{"<thinking>想法</thinking>回答", "想法", "回答"},
+		{"<thought>想法</thought>\n\n回答", "想法", "回答"},
+		{"前言<thinking>一</thinking> 中间 <thinking>二</thinking>结尾", "一\n\n二", "前言中间 结尾"},
+		{"<thinking>  </thinking>回答", "", "回答"},
+		{"<thinking>仍在思考</thin", "仍在思考", ""},
+		{"<thinking>仍在思考", "仍在思考", ""},
+		{"回答<thinking>后面的思考", "后面的思考", "回答"},
+	}
+	for _, tt := range tests {
+		thinking, answer := splitThinking(tt.raw)
+		if thinking != tt.thinking || answer != tt.answer {
+			t.Errorf("splitThinking(%q) = %q, %q; want %q, %q", tt.raw, thinking, answer, tt.thinking, tt.answer)
+		}
+	}
+}
+
+func TestPartialTagStart(t *testing.T) {
+	long := "<" + strings.Repeat("x", maxTagLen)
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"正文", len("正文")},
+		{"正文<thin", len("正文")},
+		{"正文<thinking>", len("正文<thinking>")},
+		{"a<b>c", len("a<b>c")},
+		{long, len(long)},
+	}
+	for _, tt := range tests {
+		if got := partialTagStart(tt.s); got != tt.want {
+			t.Errorf("partialTagStart(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestContentStreamerStreamsBeforeFinish(t *testing.T) {
+	s := newContentStreamer(context.Background(), &NotionAIProvider{}, true)
+	text, thinking, _ := pushAll(s, "<thinking>想法</thinking>第一行\n第二行的一部分", 2)
+	if thinking != "想法" {
+		t.Errorf("thinking = %q, want %q", thinking, "想法")
+	}
+	if text != "第一行\n第二行的一部分" {
+		t.Errorf("text = %q, want %q", text, "第一行\n第二行的一部分")
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		s, prefix string
+		end       int
+		ok        bool
+	}{
+		{"第一行\n第二行", "第一行", len("第一行"), true},
+		{"第一行\n\n第二行结尾", "第一行\n第二行", len("第一行\n\n第二行"), true},
+		{"a  b c", "a b", len("a  b"), true},
+		{"回答", "回答 ", len("回答"), true},
+		{"ab", "a b", 0, false},
+		{"答案是 41", "答案是 42", 0, false},
+		{"短", "更长的内容", 0, false},
+		{"", "", 0, true},
+	}
+	for _, tt := range tests {
+		end, ok := matchPrefix(tt.s, tt.prefix)
+		if end != tt.end || ok != tt.ok {
+			t.Errorf("matchPrefix(%q, %q) = %d, %v; want %d, %v", tt.s, tt.prefix, end, ok, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestContentStreamerToleratesWhitespaceRewrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		streamed string
+		final    string
+		tail     string
+	}{
+		{"末尾空白", "第一段回答  \n", "第一段回答", ""},
+		{"换行数变化", "标题\n正文第一句", "标题\n\n正文第一句，第二句。", "，第二句。"},
+		{"合并空格", "开头\na  b  c", "开头\na b c d", " d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newContentStreamer(context.Background(), &NotionAIProvider{}, false)
+			pushAll(s, tt.streamed, 3)
+			delta, err := s.Finish(tt.final)
+			if err != nil {
+				t.Fatalf("Finish() error = %v", err)
+			}
+			if delta.Text != tt.tail {
+				t.Errorf("tail = %q, want %q", delta.Text, tt.tail)
+			}
+		})
+	}
+}
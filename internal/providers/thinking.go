@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"notion-2api-go/internal/config"
+	"strings"
+)
+
+// thinkingTags 模型输出中包裹思考过程的标签
+var thinkingTags = []string{"thinking", "thought"}
+
+// splitThinking 将原始输出拆分为思考内容和正文。
+// 未闭合的思考块（流式输出中仍在生成）也计入思考内容，多个思考块之间以空行连接。
+func splitThinking(raw string) (thinking string, answer string) {
+	var thoughts []string
+	var body strings.Builder
+
+	rest := raw
+	for {
+		// 找到最早出现的开始标签
+		open, tag := -1, ""
+		for _, t := range thinkingTags {
+			if i := strings.Index(rest, "<"+t+">"); i >= 0 && (open < 0 || i < open) {
+				open, tag = i, t
+			}
+		}
+		if open < 0 {
+			body.WriteString(rest)
+			break
+		}
+
+		body.WriteString(rest[:open])
+		rest = rest[open+len(tag)+2:]
+
+		end := strings.Index(rest, "</"+tag+">")
+		if end < 0 {
+			// 思考块尚未结束，去掉末尾可能是半个结束标签的内容
+			thoughts = append(thoughts, trimPartialTag(rest))
+			break
+		}
+		thoughts = append(thoughts, rest[:end])
+		rest = strings.TrimLeft(rest[end+len(tag)+3:], " \t\r\n")
+	}
+
+	parts := make([]string, 0, len(thoughts))
+	for _, t := range thoughts {
+		if t = strings.TrimSpace(t); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return strings.Join(parts, "\n\n"), body.String()
+}
+
+// trimPartialTag 去掉末尾可能是标签开头（尚未出现 '>'）的内容
+func trimPartialTag(s string) string {
+	if idx := strings.LastIndex(s, "<"); idx >= 0 && !strings.Contains(s[idx:], ">") {
+		return s[:idx]
+	}
+	return s
+}
+
+// splitResponse 将完整响应拆分为思考内容和清洗后的正文
+func (p *NotionAIProvider) splitResponse(fullResponse string) (thinking string, answer string) {
+	thinking, answer = splitThinking(fullResponse)
+	return thinking, p.cleanContent(answer)
+}
+
+// wantsReasoning 判断 OpenAI 请求是否需要返回 reasoning_content
+func (p *NotionAIProvider) wantsReasoning(requestData map[string]interface{}, model *config.ModelSpec) bool {
+	if include, ok := requestData["include_reasoning"].(bool); ok {
+		return include
+	}
+	return p.exposeThinking(model)
+}
+
+// wantsThinking 判断 Anthropic 请求是否需要返回 thinking 内容块，
+// 优先遵循请求中的 thinking 参数，未指定时使用部署配置
+func (p *NotionAIProvider) wantsThinking(originalData map[string]interface{}, model *config.ModelSpec) bool {
+	if thinking, ok := originalData["thinking"].(map[string]interface{}); ok {
+		thinkingType, _ := thinking["type"].(string)
+		return thinkingType == "enabled"
+	}
+	return p.exposeThinking(model)
+}
+
+// exposeThinking 部署配置中是否返回思考内容，模型配置了 expose_thinking 时以模型为准
+func (p *NotionAIProvider) exposeThinking(model *config.ModelSpec) bool {
+	if model.ExposeThinking != nil {
+		return *model.ExposeThinking
+	}
+	return p.config.ExposeThinking
+}
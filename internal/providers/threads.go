@@ -0,0 +1,214 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/logging"
+	"notion-2api-go/internal/threads"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// threadSweepInterval 检查到期线程的间隔
+const threadSweepInterval = time.Minute
+
+// ThreadRetentionKey 客户端 API Key 单独设置的线程保留时间（time.Duration）在 gin 上下文中的键，负数表示不清理
+const ThreadRetentionKey = "thread_retention"
+
+// archiveTimeout 单次归档线程请求的超时时间
+const archiveTimeout = 30 * time.Second
+
+// SetThreadTracker 设置待归档线程的记录，并启动定期归档到期线程的后台任务。
+// 回放模式下线程并不存在于 Notion，不记录也不归档。
+func (p *NotionAIProvider) SetThreadTracker(tracker *threads.Tracker) {
+	if p.config.CassetteMode == "replay" {
+		return
+	}
+	p.threads = tracker
+	p.goBackground(p.threadSweepLoop)
+}
+
+// threadRetention 本次请求创建的线程的保留时间：API Key 单独设置的优先，否则使用 THREAD_RETENTION
+func (p *NotionAIProvider) threadRetention(c *gin.Context) time.Duration {
+	if value, ok := c.Get(ThreadRetentionKey); ok {
+		return value.(time.Duration)
+	}
+	return time.Duration(p.config.ThreadRetention) * time.Second
+}
+
+// attemptThreadsKey 本次请求各次尝试使用的线程（[]threads.Thread）在 gin 上下文中的键
+const attemptThreadsKey = "attempt_threads"
+
+// trackThread 按保留时间记录一次尝试使用的线程。
+// 每次尝试构建载荷时即记录，失败或重试的尝试创建的线程同样会被归档；
+// 请求结束前线程标记为使用中，后台任务不会归档。保留时间为负数时不记录。
+func (p *NotionAIProvider) trackThread(c *gin.Context, account, threadID string) {
+	if p.threads == nil || threadID == "" {
+		return
+	}
+	retention := p.threadRetention(c)
+	if retention < 0 {
+		return
+	}
+
+	thread := threads.Thread{
+		ID:        threadID,
+		Account:   account,
+		Retention: int(retention / time.Second),
+		ExpiresAt: time.Now().Add(retention),
+	}
+	p.threads.Track(thread)
+	p.setThreadActive(thread.ID, 1)
+
+	attempts, _ := c.Get(attemptThreadsKey)
+	list, _ := attempts.([]threads.Thread)
+	c.Set(attemptThreadsKey, append(list, thread))
+}
+
+// releaseThreads 请求结束后按保留时间处理本次请求各次尝试使用的线程。
+// 保留时间为 0 且线程不属于进行中的对话时立即归档，否则由后台任务在到期后归档；
+// 继续对话时到期时间从最后一次使用算起。
+func (p *NotionAIProvider) releaseThreads(c *gin.Context) {
+	attempts, _ := c.Get(attemptThreadsKey)
+	list, _ := attempts.([]threads.Thread)
+	logger := logging.FromContext(c.Request.Context())
+
+	released := make(map[string]bool)
+	for _, thread := range list {
+		p.setThreadActive(thread.ID, -1)
+		if released[thread.ID] {
+			continue
+		}
+		released[thread.ID] = true
+
+		thread.ExpiresAt = time.Now().Add(time.Duration(thread.Retention) * time.Second)
+		p.threads.Track(thread)
+		if thread.Retention > 0 || p.threadInUse(thread.ID) {
+			continue
+		}
+
+		account := p.accountByName(thread.Account)
+		if account == nil {
+			continue
+		}
+		threadID := thread.ID
+		p.goBackground(func(ctx context.Context) {
+			if err := p.archiveThread(ctx, account, threadID); err != nil {
+				logger.Warnf("归档线程 %s 失败，稍后重试: %v", threadID, err)
+				return
+			}
+			p.threads.Remove(threadID)
+		})
+	}
+}
+
+// setThreadActive 调整线程被进行中的请求使用的次数
+func (p *NotionAIProvider) setThreadActive(threadID string, delta int) {
+	p.activeMu.Lock()
+	defer p.activeMu.Unlock()
+
+	if p.activeThreads == nil {
+		p.activeThreads = make(map[string]int)
+	}
+	p.activeThreads[threadID] += delta
+	if p.activeThreads[threadID] <= 0 {
+		delete(p.activeThreads, threadID)
+	}
+}
+
+// threadActive 线程是否正被进行中的请求使用
+func (p *NotionAIProvider) threadActive(threadID string) bool {
+	p.activeMu.Lock()
+	defer p.activeMu.Unlock()
+	return p.activeThreads[threadID] > 0
+}
+
+// threadInUse 线程是否仍属于未过期的对话，这样的线程不会被归档
+func (p *NotionAIProvider) threadInUse(threadID string) bool {
+	return p.conversations != nil && p.conversations.HasThread(threadID)
+}
+
+// archiveThread 将线程标记为 alive: false，线程从 Notion 侧边栏的 AI 对话历史中移除
+func (p *NotionAIProvider) archiveThread(ctx context.Context, account *config.NotionAccount, threadID string) error {
+	operation := map[string]interface{}{
+		"pointer": map[string]interface{}{
+			"table":   "thread",
+			"id":      threadID,
+			"spaceId": account.SpaceID,
+		},
+		"path":    []string{},
+		"command": "update",
+		"args": map[string]interface{}{
+			"alive": false,
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, archiveTimeout)
+	defer cancel()
+	if err := p.saveTransactions(ctx, account, operation); err != nil {
+		return fmt.Errorf("归档对话线程失败: %v", err)
+	}
+	return nil
+}
+
+// threadSweepLoop 定期归档到期的线程，ctx 取消时退出
+func (p *NotionAIProvider) threadSweepLoop(ctx context.Context) {
+	ticker := time.NewTicker(threadSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.sweepThreads(ctx, time.Now())
+		}
+	}
+}
+
+// sweepThreads 归档到期的线程：仍属于进行中对话或请求的线程顺延，创建线程的账号已移除时放弃，归档失败的下次重试
+func (p *NotionAIProvider) sweepThreads(ctx context.Context, now time.Time) {
+	due := p.threads.Due(now)
+	if len(due) == 0 {
+		return
+	}
+
+	archived := 0
+	for _, thread := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if p.threadInUse(thread.ID) || p.threadActive(thread.ID) {
+			thread.ExpiresAt = now.Add(time.Duration(thread.Retention) * time.Second)
+			p.threads.Track(thread)
+			continue
+		}
+
+		account := p.accountByName(thread.Account)
+		if account == nil {
+			log.Warnf("线程 %s 所属的账号 %s 已不存在，放弃归档", thread.ID, thread.Account)
+			p.threads.Remove(thread.ID)
+			continue
+		}
+		if err := p.archiveThread(ctx, account, thread.ID); err != nil {
+			log.Warnf("归档线程 %s 失败，稍后重试: %v", thread.ID, err)
+			continue
+		}
+		p.threads.Remove(thread.ID)
+		archived++
+	}
+	if archived > 0 {
+		log.Infof("已归档 %d 个到期的 Notion 线程，剩余 %d 个待归档", archived, p.threads.Len())
+	}
+}
+
+// accountByName 按名称查找账号池中的账号
+func (p *NotionAIProvider) accountByName(name string) *config.NotionAccount {
+	for _, account := range p.accounts.Accounts() {
+		if account.Name == name {
+			return &account.NotionAccount
+		}
+	}
+	return nil
+}
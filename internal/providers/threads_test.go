@@ -0,0 +1,113 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"notion-2api-go/internal/accounts"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/threads"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archiveRecorder 记录 saveTransactionsFanout 请求中被归档的线程
+type archiveRecorder struct {
+	mu       sync.Mutex
+	archived []string
+}
+
+func (r *archiveRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Transactions []struct {
+			Operations []struct {
+				Pointer struct {
+					ID string `json:"id"`
+				} `json:"pointer"`
+			} `json:"operations"`
+		} `json:"transactions"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+	r.mu.Lock()
+	for _, tx := range body.Transactions {
+		for _, op := range tx.Operations {
+			r.archived = append(r.archived, op.Pointer.ID)
+		}
+	}
+	r.mu.Unlock()
+	w.Write([]byte("{}"))
+}
+
+func newThreadTestProvider(t *testing.T, retention int) (*NotionAIProvider, *archiveRecorder) {
+	recorder := &archiveRecorder{}
+	server := httptest.NewServer(recorder)
+	t.Cleanup(server.Close)
+
+	tracker, err := threads.Open(filepath.Join(t.TempDir(), "threads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracker.Close() })
+	pool, err := accounts.NewPool([]config.NotionAccount{{Name: "a", Cookie: "c", SpaceID: "s", UserID: "u"}}, accounts.StrategyRoundRobin, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &NotionAIProvider{
+		client:       server.Client(),
+		config:       &config.Settings{ThreadRetention: retention},
+		accounts:     pool,
+		threads:      tracker,
+		apiEndpoints: map[string]string{"saveTransactions": server.URL + "/api/v3/saveTransactionsFanout"},
+	}
+	return p, recorder
+}
+
+func TestReleaseThreadsArchivesEveryAttempt(t *testing.T) {
+	p, recorder := newThreadTestProvider(t, 0)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	// 第一次尝试失败后重试，两次尝试各自创建了线程
+	p.trackThread(c, "a", "failed-attempt")
+	p.trackThread(c, "a", "final-attempt")
+	if p.threads.Len() != 2 {
+		t.Fatalf("tracked %d threads, want 2", p.threads.Len())
+	}
+
+	// 请求进行中，到期的线程不会被归档
+	p.sweepThreads(c.Request.Context(), time.Now().Add(time.Second))
+	if len(recorder.archived) != 0 {
+		t.Fatalf("archived %v while the request is in progress", recorder.archived)
+	}
+
+	p.releaseThreads(c)
+	p.tasks.Wait()
+	if len(recorder.archived) != 2 {
+		t.Fatalf("archived %v, want both attempt threads", recorder.archived)
+	}
+	if p.threads.Len() != 0 {
+		t.Errorf("%d threads left after archiving, want 0", p.threads.Len())
+	}
+}
+
+func TestReleaseThreadsKeepsRetainedThreads(t *testing.T) {
+	p, recorder := newThreadTestProvider(t, 3600)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	p.trackThread(c, "a", "thread-1")
+	p.releaseThreads(c)
+	p.tasks.Wait()
+	if len(recorder.archived) != 0 {
+		t.Fatalf("archived %v before the retention expired", recorder.archived)
+	}
+	due := p.threads.Due(time.Now().Add(2 * time.Hour))
+	if len(due) != 1 || due[0].ID != "thread-1" {
+		t.Fatalf("due threads = %v, want thread-1", due)
+	}
+}
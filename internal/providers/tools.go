@@ -0,0 +1,310 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"notion-2api-go/internal/logging"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Notion 不支持函数调用，这里通过提示词约定模型以 <tool_call> 块输出调用，
+// 再把它解析回 OpenAI 的 tool_calls。
+const (
+	toolCallOpen  = "<tool_call>"
+	toolCallClose = "</tool_call>"
+)
+
+// toolDefinition 客户端声明的函数工具
+type toolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// toolCall 模型发起的一次函数调用，Arguments 为 JSON 字符串
+type toolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// toolSession 一次请求中的工具配置
+type toolSession struct {
+	tools []toolDefinition
+	// forced 非空时模型必须调用该工具
+	forced string
+	// required 为 true 时模型必须至少调用一个工具
+	required bool
+	// parallel 为 false 时每轮最多调用一个工具
+	parallel bool
+}
+
+// parseToolSession 解析请求中的 tools / tool_choice（兼容旧版 functions / function_call）。
+// 没有声明工具或 tool_choice 为 "none" 时返回 nil。
+func parseToolSession(requestData map[string]interface{}) (*toolSession, error) {
+	session := &toolSession{parallel: true}
+
+	if tools, ok := requestData["tools"].([]interface{}); ok {
+		for i, tool := range tools {
+			toolMap, ok := tool.(map[string]interface{})
+			if !ok {
+				return nil, &invalidRequestError{Message: fmt.Sprintf("tools[%d] 格式无效", i)}
+			}
+			if toolType, _ := toolMap["type"].(string); toolType != "function" {
+				return nil, &invalidRequestError{Message: fmt.Sprintf("tools[%d] 的类型 %q 不受支持，仅支持 function", i, toolType)}
+			}
+			definition, err := parseToolDefinition(toolMap["function"])
+			if err != nil {
+				return nil, &invalidRequestError{Message: fmt.Sprintf("tools[%d]: %v", i, err)}
+			}
+			session.tools = append(session.tools, definition)
+		}
+	} else if functions, ok := requestData["functions"].([]interface{}); ok {
+		for i, function := range functions {
+			definition, err := parseToolDefinition(function)
+			if err != nil {
+				return nil, &invalidRequestError{Message: fmt.Sprintf("functions[%d]: %v", i, err)}
+			}
+			session.tools = append(session.tools, definition)
+		}
+	}
+
+	if len(session.tools) == 0 {
+		return nil, nil
+	}
+
+	choice, ok := requestData["tool_choice"]
+	if !ok {
+		choice = requestData["function_call"]
+	}
+	switch v := choice.(type) {
+	case string:
+		switch v {
+		case "none":
+			return nil, nil
+		case "required", "any":
+			session.required = true
+		}
+	case map[string]interface{}:
+		name, _ := v["name"].(string)
+		if function, ok := v["function"].(map[string]interface{}); ok {
+			name, _ = function["name"].(string)
+		}
+		if name == "" {
+			return nil, &invalidRequestError{Message: "tool_choice 缺少函数名"}
+		}
+		if !session.hasTool(name) {
+			return nil, &invalidRequestError{Message: fmt.Sprintf("tool_choice 指定的函数 %q 未在 tools 中声明", name)}
+		}
+		session.forced = name
+	}
+
+	if parallel, ok := requestData["parallel_tool_calls"].(bool); ok {
+		session.parallel = parallel
+	}
+
+	return session, nil
+}
+
+// parseToolDefinition 解析单个函数定义
+func parseToolDefinition(function interface{}) (toolDefinition, error) {
+	functionMap, ok := function.(map[string]interface{})
+	if !ok {
+		return toolDefinition{}, fmt.Errorf("缺少 function 定义")
+	}
+	name, _ := functionMap["name"].(string)
+	if name == "" {
+		return toolDefinition{}, fmt.Errorf("函数缺少 name")
+	}
+	description, _ := functionMap["description"].(string)
+	parameters, _ := functionMap["parameters"].(map[string]interface{})
+	return toolDefinition{Name: name, Description: description, Parameters: parameters}, nil
+}
+
+func (ts *toolSession) hasTool(name string) bool {
+	for _, tool := range ts.tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// prompt 生成向模型描述工具和调用格式的指令
+func (ts *toolSession) prompt() string {
+	definitions, _ := json.MarshalIndent(ts.tools, "", "  ")
+
+	var b strings.Builder
+	b.WriteString("You have access to the following tools, described as JSON:\n")
+	b.Write(definitions)
+	b.WriteString("\n\nTo call a tool, output a block in exactly this format, with the arguments matching the tool's JSON schema:\n")
+	b.WriteString(toolCallOpen + "\n{\"name\": \"<tool name>\", \"arguments\": {<arguments>}}\n" + toolCallClose + "\n")
+	b.WriteString("Do not wrap the block in code fences. After the tool call blocks, stop your reply and wait: ")
+	b.WriteString("the results will be sent back to you in <tool_result> blocks.\n")
+	b.WriteString("Only call the tools listed above, and never invent tool results yourself.")
+
+	switch {
+	case ts.forced != "":
+		fmt.Fprintf(&b, "\nYou MUST call the tool %q in this reply.", ts.forced)
+	case ts.required:
+		b.WriteString("\nYou MUST call at least one tool in this reply.")
+	default:
+		b.WriteString("\nIf no tool is needed, answer normally without any tool call block.")
+	}
+	if !ts.parallel {
+		b.WriteString("\nCall at most one tool per reply.")
+	}
+	return b.String()
+}
+
+// formatToolCalls 将历史中的 assistant tool_calls 序列化为模型输出的调用格式
+func formatToolCalls(toolCalls []interface{}) (string, map[string]string) {
+	names := make(map[string]string)
+	blocks := make([]string, 0, len(toolCalls))
+	for _, tc := range toolCalls {
+		tcMap, ok := tc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := tcMap["id"].(string)
+		function, _ := tcMap["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		if name == "" {
+			continue
+		}
+		names[id] = name
+
+		var arguments interface{} = map[string]interface{}{}
+		if raw, ok := function["arguments"].(string); ok && raw != "" {
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(raw), &parsed); err == nil {
+				arguments = parsed
+			} else {
+				arguments = raw
+			}
+		} else if obj, ok := function["arguments"].(map[string]interface{}); ok {
+			arguments = obj
+		}
+
+		call, _ := json.Marshal(map[string]interface{}{"name": name, "arguments": arguments})
+		blocks = append(blocks, toolCallOpen+"\n"+string(call)+"\n"+toolCallClose)
+	}
+	return strings.Join(blocks, "\n"), names
+}
+
+// formatToolResult 将工具结果序列化为交给模型的 <tool_result> 块
+func formatToolResult(toolCallID, name, content string) string {
+	return fmt.Sprintf("<tool_result tool_call_id=%q name=%q>\n%s\n</tool_result>", toolCallID, name, content)
+}
+
+// codeFencePattern 模型偶尔会把调用 JSON 包在代码块中
+var codeFencePattern = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+
+// extractToolCalls 从模型输出中解析 <tool_call> 块，返回去掉调用块后的正文和解析出的调用。
+// 只解析已闭合的块；无法解析的块保留在正文中。
+func extractToolCalls(logger *log.Entry, answer string) (string, []toolCall) {
+	var calls []toolCall
+	var text strings.Builder
+
+	rest := answer
+	for {
+		open := strings.Index(rest, toolCallOpen)
+		if open < 0 {
+			text.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[open:], toolCallClose)
+		if end < 0 {
+			text.WriteString(rest)
+			break
+		}
+		end += open
+
+		raw := strings.TrimSpace(rest[open+len(toolCallOpen) : end])
+		if call, ok := parseToolCall(raw); ok {
+			text.WriteString(rest[:open])
+			calls = append(calls, call)
+		} else {
+			logger.Debugf("无法解析模型输出的工具调用: %s", logging.Content(raw))
+			text.WriteString(rest[:end+len(toolCallClose)])
+		}
+		rest = rest[end+len(toolCallClose):]
+	}
+
+	return strings.TrimSpace(text.String()), calls
+}
+
+// parseToolCall 解析单个调用块中的 JSON
+func parseToolCall(raw string) (toolCall, bool) {
+	if m := codeFencePattern.FindStringSubmatch(raw); m != nil {
+		raw = m[1]
+	}
+
+	var parsed struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil || parsed.Name == "" {
+		return toolCall{}, false
+	}
+
+	arguments := "{}"
+	if len(parsed.Arguments) > 0 && string(parsed.Arguments) != "null" {
+		// 参数可能被模型写成 JSON 字符串
+		var asString string
+		if err := json.Unmarshal(parsed.Arguments, &asString); err == nil {
+			arguments = asString
+		} else {
+			arguments = string(parsed.Arguments)
+		}
+	}
+
+	return toolCall{
+		ID:        "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+		Name:      parsed.Name,
+		Arguments: arguments,
+	}, true
+}
+
+// openAIToolCalls 将调用转换为 OpenAI message.tool_calls 格式
+func openAIToolCalls(calls []toolCall) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(calls))
+	for _, call := range calls {
+		result = append(result, map[string]interface{}{
+			"id":   call.ID,
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      call.Name,
+				"arguments": call.Arguments,
+			},
+		})
+	}
+	return result
+}
+
+// anthropicToolUseID 将调用 ID 转换为 Anthropic 风格的 toolu_ 前缀
+func anthropicToolUseID(id string) string {
+	return "toolu_" + strings.TrimPrefix(id, "call_")
+}
+
+// anthropicToolUses 将调用转换为 Anthropic 的 tool_use 内容块
+func anthropicToolUses(logger *log.Entry, calls []toolCall) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(calls))
+	for _, call := range calls {
+		input := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(call.Arguments), &input); err != nil {
+			logger.Warnf("工具调用 %s 的参数不是 JSON 对象: %s", call.Name, logging.Content(call.Arguments))
+		}
+		result = append(result, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    anthropicToolUseID(call.ID),
+			"name":  call.Name,
+			"input": input,
+		})
+	}
+	return result
+}
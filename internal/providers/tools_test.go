@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestExtractToolCalls(t *testing.T) {
+	type call struct{ name, arguments string }
+	tests := []struct {
+		name  string
+		in    string
+		text  string
+		calls []call
+	}{
+		{"没有调用", "普通回答", "普通回答", nil},
+		{"单个调用", "我来查一下。\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"北京\"}}\n</tool_call>",
+			"我来查一下。", []call{{"get_weather", `{"city": "北京"}`}}},
+		{"多个调用", "<tool_call>{\"name\":\"a\",\"arguments\":{}}</tool_call>\n<tool_call>{\"name\":\"b\",\"arguments\":{\"x\":1}}</tool_call>",
+			"", []call{{"a", "{}"}, {"b", `{"x":1}`}}},
+		{"代码块包裹", "<tool_call>\n```json\n{\"name\":\"a\",\"arguments\":{\"q\":\"go\"}}\n```\n</tool_call>", "", []call{{"a", `{"q":"go"}`}}},
+		{"参数为字符串", `<tool_call>{"name":"a","arguments":"{\"q\":1}"}</tool_call>`, "", []call{{"a", `{"q":1}`}}},
+		{"没有参数", `<tool_call>{"name":"a"}</tool_call>`, "", []call{{"a", "{}"}}},
+		{"参数为 null", `<tool_call>{"name":"a","arguments":null}</tool_call>`, "", []call{{"a", "{}"}}},
+		{"无法解析的调用保留在正文", "前<tool_call>不是 JSON</tool_call>后", "前<tool_call>不是 JSON</tool_call>后", nil},
+		{"缺少名称", `<tool_call>{"arguments":{}}</tool_call>`, `<tool_call>{"arguments":{}}</tool_call>`, nil},
+		{"未闭合的调用", "回答<tool_call>{\"name\":\"a\"", "回答<tool_call>{\"name\":\"a\"", nil},
+		{"调用前后的正文", "开头 <tool_call>{\"name\":\"a\"}</tool_call> 结尾", "开头  结尾", []call{{"a", "{}"}}},
+	}
+	logger := log.NewEntry(log.StandardLogger())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, calls := extractToolCalls(logger, tt.in)
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			var got []call
+			for _, c := range calls {
+				if !strings.HasPrefix(c.ID, "call_") {
+					t.Errorf("call ID %q has no call_ prefix", c.ID)
+				}
+				got = append(got, call{c.Name, c.Arguments})
+			}
+			if !reflect.DeepEqual(got, tt.calls) {
+				t.Errorf("calls = %v, want %v", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestAnthropicToolUseID(t *testing.T) {
+	if got := anthropicToolUseID("call_abc123"); got != "toolu_abc123" {
+		t.Errorf("anthropicToolUseID() = %q, want %q", got, "toolu_abc123")
+	}
+}
@@ -0,0 +1,382 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/logging"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxImageBytes 单张图片的大小上限
+const maxImageBytes = 20 << 20
+
+// UploadFile 待上传的文件
+type UploadFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// ThreadID 文件所属的对话线程
+	ThreadID string
+	// Account 发起本次推理的 Notion 账号，文件上传到该账号的空间
+	Account *config.NotionAccount
+}
+
+// UploadedFile 上传完成后可在 transcript 中引用的文件
+type UploadedFile struct {
+	URL         string
+	Name        string
+	ContentType string
+	Size        int
+}
+
+// FileUploader 将附件上传到 Notion。
+// 默认实现走 Notion 的 getUploadFileUrl 流程，可替换为本地测试桩。
+type FileUploader interface {
+	Upload(ctx context.Context, file UploadFile) (*UploadedFile, error)
+}
+
+// notionFileUploader 通过 Notion 的 getUploadFileUrl 接口获取签名地址并上传文件
+type notionFileUploader struct {
+	client   *http.Client
+	endpoint string
+	headers  func(account *config.NotionAccount) map[string]string
+}
+
+// NewNotionFileUploader 创建使用 Notion 上传流程的 FileUploader，
+// endpoint 为 getUploadFileUrl 接口地址，headers 返回以指定账号请求 Notion 时使用的请求头
+func NewNotionFileUploader(client *http.Client, endpoint string, headers func(account *config.NotionAccount) map[string]string) FileUploader {
+	return &notionFileUploader{
+		client:   client,
+		endpoint: endpoint,
+		headers:  headers,
+	}
+}
+
+// Upload 申请签名上传地址，然后把文件内容上传到存储
+func (u *notionFileUploader) Upload(ctx context.Context, file UploadFile) (*UploadedFile, error) {
+	payload := map[string]interface{}{
+		"bucket":      "secure",
+		"name":        file.Name,
+		"contentType": file.ContentType,
+		"record": map[string]interface{}{
+			"table":   "thread",
+			"id":      file.ThreadID,
+			"spaceId": file.Account.SpaceID,
+		},
+	}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range u.headers(file.Account) {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("获取上传地址失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取上传地址失败，状态码: %d", resp.StatusCode)
+	}
+
+	var uploadInfo struct {
+		URL                 string            `json:"url"`
+		SignedGetURL        string            `json:"signedGetUrl"`
+		SignedPutURL        string            `json:"signedPutUrl"`
+		SignedUploadPostURL string            `json:"signedUploadPostUrl"`
+		Fields              map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&uploadInfo); err != nil {
+		return nil, fmt.Errorf("解析上传地址失败: %v", err)
+	}
+
+	switch {
+	case uploadInfo.SignedPutURL != "":
+		err = u.put(ctx, uploadInfo.SignedPutURL, file)
+	case uploadInfo.SignedUploadPostURL != "":
+		err = u.post(ctx, uploadInfo.SignedUploadPostURL, uploadInfo.Fields, file)
+	default:
+		err = fmt.Errorf("Notion 未返回可用的上传地址")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fileURL := uploadInfo.URL
+	if fileURL == "" {
+		fileURL = uploadInfo.SignedGetURL
+	}
+	logging.FromContext(ctx).Infof("图片上传成功: %s (%d 字节)", file.Name, len(file.Data))
+
+	return &UploadedFile{
+		URL:         fileURL,
+		Name:        file.Name,
+		ContentType: file.ContentType,
+		Size:        len(file.Data),
+	}, nil
+}
+
+// put 使用签名 PUT 地址上传
+func (u *notionFileUploader) put(ctx context.Context, url string, file UploadFile) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(file.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", file.ContentType)
+	return u.doUpload(req)
+}
+
+// post 使用签名表单地址上传
+func (u *notionFileUploader) post(ctx context.Context, url string, fields map[string]string, file UploadFile) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("file", file.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(file.Data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return u.doUpload(req)
+}
+
+func (u *notionFileUploader) doUpload(req *http.Request) error {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("上传文件失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("上传文件失败，状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// loadImage 读取图片内容，支持 data: URL 和 http(s) URL
+func (p *NotionAIProvider) loadImage(ctx context.Context, image ImageInput) (UploadFile, error) {
+	if strings.HasPrefix(image.URL, "data:") {
+		return decodeDataURL(image.URL)
+	}
+	if !strings.HasPrefix(image.URL, "http://") && !strings.HasPrefix(image.URL, "https://") {
+		return UploadFile{}, &invalidRequestError{Message: "image_url 只支持 data: URL 或 http(s) URL"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", image.URL, nil)
+	if err != nil {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("无效的图片地址: %v", err)}
+	}
+	resp, err := p.imageClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errForbiddenAddress) {
+			return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("图片地址 %s 指向本机或内网地址，已拒绝", req.URL.Host)}
+		}
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("下载图片失败: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("下载图片失败，状态码: %d", resp.StatusCode)}
+	}
+	if resp.ContentLength > maxImageBytes {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("图片超过大小上限 (%d 字节)", maxImageBytes)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("下载图片失败: %v", err)}
+	}
+	if len(data) > maxImageBytes {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("图片超过大小上限 (%d 字节)", maxImageBytes)}
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("不支持的图片类型: %s", contentType)}
+	}
+
+	name := path.Base(req.URL.Path)
+	if name == "" || name == "/" || name == "." {
+		name = imageFileName(contentType)
+	}
+	return UploadFile{Name: name, ContentType: contentType, Data: data}, nil
+}
+
+// decodeDataURL 解析 base64 编码的 data: URL
+func decodeDataURL(dataURL string) (UploadFile, error) {
+	header, encoded, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return UploadFile{}, &invalidRequestError{Message: "图片 data URL 必须使用 base64 编码"}
+	}
+
+	contentType := strings.TrimSuffix(header, ";base64")
+	if !strings.HasPrefix(contentType, "image/") {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("不支持的图片类型: %s", contentType)}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("图片 base64 解码失败: %v", err)}
+	}
+	if len(data) > maxImageBytes {
+		return UploadFile{}, &invalidRequestError{Message: fmt.Sprintf("图片超过大小上限 (%d 字节)", maxImageBytes)}
+	}
+
+	return UploadFile{Name: imageFileName(contentType), ContentType: contentType, Data: data}, nil
+}
+
+// imageFileName 根据 MIME 类型生成文件名
+func imageFileName(contentType string) string {
+	ext := strings.TrimPrefix(contentType, "image/")
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	return fmt.Sprintf("image-%s.%s", uuid.New().String()[:8], ext)
+}
+
+// imageSet 一次请求中的图片：发起推理前统一下载，每个账号只上传一次，换账号或重试时复用已上传的文件
+type imageSet struct {
+	p     *NotionAIProvider
+	files map[string]UploadFile
+	// uploaded 按账号名和图片地址记录已上传的文件
+	uploaded map[string]map[string]*UploadedFile
+}
+
+// loadImages 下载消息中的所有图片，图片无效时返回 *invalidRequestError
+func (p *NotionAIProvider) loadImages(ctx context.Context, messages []ChatMessage) (*imageSet, error) {
+	set := &imageSet{p: p, files: make(map[string]UploadFile), uploaded: make(map[string]map[string]*UploadedFile)}
+	for _, msg := range messages {
+		for _, image := range msg.Images {
+			if _, ok := set.files[image.URL]; ok {
+				continue
+			}
+			file, err := p.loadImage(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			set.files[image.URL] = file
+		}
+	}
+	return set, nil
+}
+
+// upload 把图片上传到指定账号的空间，已上传到该账号的图片直接复用。
+// 文件关联到该账号第一次尝试使用的线程。
+func (s *imageSet) upload(ctx context.Context, account *config.NotionAccount, threadID string, images []ImageInput) ([]*UploadedFile, error) {
+	done := s.uploaded[account.Name]
+	if done == nil {
+		done = make(map[string]*UploadedFile)
+		s.uploaded[account.Name] = done
+	}
+
+	uploaded := make([]*UploadedFile, 0, len(images))
+	for _, image := range images {
+		if result, ok := done[image.URL]; ok {
+			uploaded = append(uploaded, result)
+			continue
+		}
+		file, ok := s.files[image.URL]
+		if !ok {
+			return nil, fmt.Errorf("图片 %s 未下载", image.URL)
+		}
+		file.ThreadID = threadID
+		file.Account = account
+
+		result, err := s.p.uploader.Upload(ctx, file)
+		if err != nil {
+			return nil, fmt.Errorf("上传图片到 Notion 失败: %v", err)
+		}
+		done[image.URL] = result
+		uploaded = append(uploaded, result)
+	}
+	return uploaded, nil
+}
+
+// errForbiddenAddress 图片地址解析到了本机、内网或云元数据等不允许访问的地址
+var errForbiddenAddress = errors.New("不允许访问的地址")
+
+// cgnatRange 运营商级 NAT 地址段，同样视为内网
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// newImageClient 下载客户端图片使用的 HTTP 客户端，与请求 Notion 的客户端分开：
+// 不使用代理，在 DNS 解析后、建立连接前检查目标地址（重定向同样检查），防止借图片地址访问内网（SSRF）
+func newImageClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("重定向次数过多")
+			}
+			return nil
+		},
+	}
+}
+
+// publicIP 地址是否可以从公网访问：排除回环、私有、链路本地（包括 169.254.169.254 元数据地址）、组播和未指定地址
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatRange.Contains(ip))
+}
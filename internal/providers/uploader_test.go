@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"notion-2api-go/internal/config"
+	"testing"
+	"time"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestLoadImageRejectsLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("图片请求不应到达本机地址")
+	}))
+	defer server.Close()
+
+	p := &NotionAIProvider{imageClient: newImageClient(5 * time.Second)}
+	_, err := p.loadImage(context.Background(), ImageInput{URL: server.URL + "/a.png"})
+	var invalid *invalidRequestError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("loadImage 应返回 invalidRequestError，得到 %v", err)
+	}
+}
+
+// countingUploader 记录上传次数的测试桩
+type countingUploader struct {
+	uploads int
+}
+
+func (u *countingUploader) Upload(ctx context.Context, file UploadFile) (*UploadedFile, error) {
+	u.uploads++
+	return &UploadedFile{URL: "attachment:" + file.Account.Name, Name: file.Name, ContentType: file.ContentType, Size: len(file.Data)}, nil
+}
+
+func TestImageSetUploadsOncePerAccount(t *testing.T) {
+	uploader := &countingUploader{}
+	p := &NotionAIProvider{uploader: uploader}
+	// 1x1 PNG
+	image := ImageInput{URL: "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="}
+	messages := []ChatMessage{{Role: "user", Content: "看图", Images: []ImageInput{image, image}}}
+
+	images, err := p.loadImages(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("loadImages: %v", err)
+	}
+	a := &config.NotionAccount{Name: "a"}
+	b := &config.NotionAccount{Name: "b"}
+	for _, account := range []*config.NotionAccount{a, a, b} {
+		files, err := images.upload(context.Background(), account, "thread", messages[0].Images)
+		if err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+		if len(files) != 2 || files[0].URL != "attachment:"+account.Name {
+			t.Fatalf("账号 %s 上传结果不正确: %+v", account.Name, files)
+		}
+	}
+	if uploader.uploads != 2 {
+		t.Errorf("每个账号应只上传一次，实际上传 %d 次", uploader.uploads)
+	}
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval 清理空闲令牌桶的间隔
+const sweepInterval = 5 * time.Minute
+
+// Rule 一组限流参数，值为 0 表示不限制
+type Rule struct {
+	// RequestsPerMinute 每分钟请求数，令牌桶容量与之相同，允许短时间内用完一分钟的额度
+	RequestsPerMinute int
+	// MaxConcurrent 同时进行中的请求数
+	MaxConcurrent int
+}
+
+// Check 一次请求需要通过的一组限流规则
+type Check struct {
+	// Scope 规则作用范围，如 global、key、ip，用于错误信息
+	Scope string
+	// Key 同一 Scope 下区分不同调用方的标识
+	Key  string
+	Rule Rule
+}
+
+// Decision 限流判断结果。Limit/Remaining/Reset 取自剩余请求数最少的令牌桶，用于 x-ratelimit-* 响应头，
+// 没有按请求数限流的规则时 Limit 为 0
+type Decision struct {
+	Allowed bool
+	// Scope 拒绝请求的规则范围
+	Scope string
+	// Concurrent 为 true 表示因并发数超限被拒绝
+	Concurrent bool
+	Limit      int
+	Remaining  int
+	// Reset 令牌桶恢复满额所需的时间
+	Reset time.Duration
+	// RetryAfter 被拒绝时建议的重试等待时间
+	RetryAfter time.Duration
+}
+
+// bucket 令牌桶，每分钟补充 rate 个令牌，最多 rate 个
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   int
+}
+
+func (b *bucket) refill(now time.Time, rate int) {
+	if b.rate != rate {
+		// 规则被修改时按新容量截断
+		b.rate = rate
+		b.tokens = math.Min(b.tokens, float64(rate))
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(rate), b.tokens+elapsed*float64(rate)/60)
+	b.last = now
+}
+
+// untilAvailable 令牌数达到 n 所需的时间
+func (b *bucket) untilAvailable(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) * 60 / float64(b.rate) * float64(time.Second))
+}
+
+// Limiter 按 Scope+Key 维护令牌桶和进行中请求数的限流器
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	inFlight  map[string]int
+	lastSweep time.Time
+}
+
+// New 创建限流器
+func New() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*bucket),
+		inFlight:  make(map[string]int),
+		lastSweep: time.Now(),
+	}
+}
+
+// Acquire 检查所有规则，全部通过时消耗令牌并占用并发名额。
+// 允许时返回的 release 必须在请求结束后调用；拒绝时不消耗任何令牌。
+func (l *Limiter) Acquire(checks []Check) (Decision, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	// tightest 剩余请求数最少的令牌桶，决定响应头
+	var tightest *bucket
+	consume := make([]*bucket, 0, len(checks))
+	for _, check := range checks {
+		id := check.Scope + ":" + check.Key
+
+		if max := check.Rule.MaxConcurrent; max > 0 && l.inFlight[id] >= max {
+			return Decision{
+				Scope:      check.Scope,
+				Concurrent: true,
+				Limit:      max,
+				RetryAfter: time.Second,
+			}, nil
+		}
+
+		rate := check.Rule.RequestsPerMinute
+		if rate <= 0 {
+			continue
+		}
+		b, ok := l.buckets[id]
+		if !ok {
+			b = &bucket{tokens: float64(rate), last: now, rate: rate}
+			l.buckets[id] = b
+		}
+		b.refill(now, rate)
+		if b.tokens < 1 {
+			return Decision{
+				Scope:      check.Scope,
+				Limit:      rate,
+				Reset:      b.untilAvailable(float64(rate)),
+				RetryAfter: b.untilAvailable(1),
+			}, nil
+		}
+		consume = append(consume, b)
+		if tightest == nil || b.tokens < tightest.tokens {
+			tightest = b
+		}
+	}
+
+	for _, b := range consume {
+		b.tokens--
+	}
+	ids := make([]string, 0, len(checks))
+	for _, check := range checks {
+		if check.Rule.MaxConcurrent > 0 {
+			id := check.Scope + ":" + check.Key
+			l.inFlight[id]++
+			ids = append(ids, id)
+		}
+	}
+
+	decision := Decision{Allowed: true}
+	if tightest != nil {
+		decision.Limit = tightest.rate
+		decision.Remaining = int(tightest.tokens)
+		decision.Reset = tightest.untilAvailable(float64(tightest.rate))
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, id := range ids {
+				if l.inFlight[id]--; l.inFlight[id] <= 0 {
+					delete(l.inFlight, id)
+				}
+			}
+		})
+	}
+	return decision, release
+}
+
+// sweep 定期删除已恢复满额的令牌桶，避免按 IP 限流时无限增长，调用方需持有锁
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.buckets {
+		b.refill(now, b.rate)
+		if b.tokens >= float64(b.rate) {
+			delete(l.buckets, id)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Now()
+	b := &bucket{tokens: 0, last: start, rate: 60}
+
+	// 每分钟 60 个令牌，即每秒一个
+	b.refill(start.Add(2500*time.Millisecond), 60)
+	if b.tokens < 2.49 || b.tokens > 2.51 {
+		t.Errorf("tokens after 2.5s = %.2f, want 2.5", b.tokens)
+	}
+	if got := b.untilAvailable(3); got < 490*time.Millisecond || got > 510*time.Millisecond {
+		t.Errorf("untilAvailable(3) = %s, want 500ms", got)
+	}
+
+	// 最多补满容量
+	b.refill(start.Add(10*time.Minute), 60)
+	if b.tokens != 60 {
+		t.Errorf("tokens after 10m = %.2f, want capped at 60", b.tokens)
+	}
+
+	// 规则改小时按新容量截断
+	b.refill(start.Add(10*time.Minute), 10)
+	if b.tokens != 10 || b.rate != 10 {
+		t.Errorf("after lowering the rate: tokens = %.2f, rate = %d, want 10, 10", b.tokens, b.rate)
+	}
+}
+
+func TestAcquireRequestsPerMinute(t *testing.T) {
+	l := New()
+	checks := []Check{{Scope: "key", Key: "k1", Rule: Rule{RequestsPerMinute: 3}}}
+
+	for i := 3; i > 0; i-- {
+		d, release := l.Acquire(checks)
+		if !d.Allowed {
+			t.Fatalf("request with %d tokens left was rejected: %+v", i, d)
+		}
+		if d.Limit != 3 || d.Remaining != i-1 {
+			t.Errorf("Limit/Remaining = %d/%d, want 3/%d", d.Limit, d.Remaining, i-1)
+		}
+		release()
+	}
+
+	d, release := l.Acquire(checks)
+	if d.Allowed || release != nil {
+		t.Fatalf("request over the limit was allowed: %+v", d)
+	}
+	if d.Scope != "key" || d.Concurrent {
+		t.Errorf("rejection = %+v, want scope key by rate", d)
+	}
+	// 每 20 秒补充一个令牌
+	if d.RetryAfter <= 19*time.Second || d.RetryAfter > 20*time.Second {
+		t.Errorf("RetryAfter = %s, want about 20s", d.RetryAfter)
+	}
+
+	// 其他 Key 使用独立的令牌桶
+	other := []Check{{Scope: "key", Key: "k2", Rule: Rule{RequestsPerMinute: 3}}}
+	if d, _ := l.Acquire(other); !d.Allowed {
+		t.Errorf("another key was rejected: %+v", d)
+	}
+}
+
+func TestAcquireRejectionConsumesNothing(t *testing.T) {
+	l := New()
+	global := Check{Scope: "global", Rule: Rule{RequestsPerMinute: 10}}
+	ip := Check{Scope: "ip", Key: "10.0.0.1", Rule: Rule{RequestsPerMinute: 1}}
+
+	if d, _ := l.Acquire([]Check{global, ip}); !d.Allowed {
+		t.Fatalf("first request rejected: %+v", d)
+	}
+	for i := 0; i < 5; i++ {
+		if d, _ := l.Acquire([]Check{global, ip}); d.Allowed || d.Scope != "ip" {
+			t.Fatalf("request over the IP limit: %+v, want rejection by ip", d)
+		}
+	}
+
+	// 被 IP 规则拒绝的请求不消耗全局令牌，响应头取剩余最少的桶
+	d, _ := l.Acquire([]Check{global})
+	if !d.Allowed || d.Remaining != 8 {
+		t.Errorf("global decision = %+v, want allowed with 8 remaining", d)
+	}
+}
+
+func TestAcquireMaxConcurrent(t *testing.T) {
+	l := New()
+	checks := []Check{{Scope: "key", Key: "k1", Rule: Rule{MaxConcurrent: 2}}}
+
+	_, release1 := l.Acquire(checks)
+	_, release2 := l.Acquire(checks)
+	d, _ := l.Acquire(checks)
+	if d.Allowed || !d.Concurrent || d.Limit != 2 {
+		t.Fatalf("third concurrent request = %+v, want rejection by concurrency", d)
+	}
+
+	// release 可以重复调用，只归还一次名额
+	release1()
+	release1()
+	d, release3 := l.Acquire(checks)
+	if !d.Allowed {
+		t.Fatalf("request after release was rejected: %+v", d)
+	}
+	if d.Limit != 0 {
+		t.Errorf("Limit = %d without a per-minute rule, want 0", d.Limit)
+	}
+	if d, _ := l.Acquire(checks); d.Allowed {
+		t.Fatal("double release freed two slots")
+	}
+	release2()
+	release3()
+	if n := len(l.inFlight); n != 0 {
+		t.Errorf("%d in-flight entries left after releasing everything", n)
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	l := New()
+	l.Acquire([]Check{{Scope: "ip", Key: "a", Rule: Rule{RequestsPerMinute: 6}}})
+	l.Acquire([]Check{{Scope: "ip", Key: "b", Rule: Rule{RequestsPerMinute: 6000}}})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.lastSweep = now.Add(-sweepInterval)
+	// 一秒后 b 已补满，a 每 10 秒才补充一个令牌
+	l.sweep(now.Add(time.Second))
+	if _, ok := l.buckets["ip:a"]; !ok {
+		t.Error("sweep dropped a bucket that is not full")
+	}
+	if _, ok := l.buckets["ip:b"]; ok {
+		t.Error("sweep kept a full bucket")
+	}
+}
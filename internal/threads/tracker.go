@@ -0,0 +1,157 @@
+package threads
+
+import (
+	"fmt"
+	"notion-2api-go/internal/jsonstore"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// flushInterval 线程记录写回文件的间隔
+const flushInterval = 10 * time.Second
+
+// Thread 代理在 Notion 中创建的一个线程，到期后归档
+type Thread struct {
+	ID string `json:"id"`
+	// Account 创建线程的账号名，归档时使用该账号的凭据
+	Account string `json:"account"`
+	// Retention 线程最后一次使用后保留的时间（秒），线程仍属于进行中的对话时按它顺延
+	Retention int       `json:"retention"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Tracker 记录待归档的线程，定期写回文件，重启后继续清理之前创建的线程
+type Tracker struct {
+	mu      sync.Mutex
+	path    string
+	threads map[string]*Thread
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open 打开线程记录文件，文件不存在时从空记录开始，file 为空时只保存在内存中
+func Open(file string) (*Tracker, error) {
+	t := &Tracker{
+		path:    file,
+		threads: make(map[string]*Thread),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if file != "" {
+		var list []*Thread
+		if err := jsonstore.Load(file, &list); err != nil {
+			return nil, fmt.Errorf("加载线程记录失败: %v", err)
+		}
+		for _, th := range list {
+			t.threads[th.ID] = th
+		}
+	}
+
+	go t.flushLoop()
+	return t, nil
+}
+
+// Close 停止后台任务并保存未写入的记录
+func (t *Tracker) Close() error {
+	close(t.stop)
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty || t.path == "" {
+		return nil
+	}
+	return t.saveLocked()
+}
+
+// Len 当前待归档的线程数
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.threads)
+}
+
+// Track 记录线程，已记录的线程更新保留时间和到期时间
+func (t *Tracker) Track(th Thread) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if existing, ok := t.threads[th.ID]; ok {
+		th.CreatedAt = existing.CreatedAt
+	}
+	if th.CreatedAt.IsZero() {
+		th.CreatedAt = time.Now()
+	}
+	t.threads[th.ID] = &th
+	t.dirty = true
+}
+
+// Due 返回到期的线程，按到期时间排序
+func (t *Tracker) Due(now time.Time) []Thread {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var due []Thread
+	for _, th := range t.threads {
+		if !now.Before(th.ExpiresAt) {
+			due = append(due, *th)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ExpiresAt.Before(due[j].ExpiresAt) })
+	return due
+}
+
+// Remove 删除已归档的线程
+func (t *Tracker) Remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.threads[id]; ok {
+		delete(t.threads, id)
+		t.dirty = true
+	}
+}
+
+// flushLoop 定期把记录写回文件
+func (t *Tracker) flushLoop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			if t.dirty && t.path != "" {
+				if err := t.saveLocked(); err != nil {
+					log.Errorf("保存线程记录文件失败: %v", err)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// saveLocked 按 ID 排序写回文件，调用方需持有锁
+func (t *Tracker) saveLocked() error {
+	list := make([]*Thread, 0, len(t.threads))
+	for _, th := range t.threads {
+		list = append(list, th)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if err := jsonstore.Save(t.path, list); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
@@ -1,10 +1,19 @@
-
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"html"
+	"strings"
+)
 
-// GetDocsHTML 返回文档页面 HTML
-func GetDocsHTML(appName, appVersion string, port int) string {
+// GetDocsHTML 返回文档页面 HTML，模型列表使用 provider 当前的模型及其可用状态
+func GetDocsHTML(appName, appVersion string, port int, models []DocsModel) string {
+	available := 0
+	for _, m := range models {
+		if m.Available != nil && *m.Available {
+			available++
+		}
+	}
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="zh-CN">
 <head>
@@ -66,6 +75,7 @@ func GetDocsHTML(appName, appVersion string, port int) string {
         }
         .model-card.success { border-left-color: #28a745; background: #f0f9f4; }
         .model-card.warning { border-left-color: #ffc107; background: #fff9e6; }
+        .model-card.unknown { border-left-color: #adb5bd; background: #f8f9fa; }
         .model-name {
             font-size: 1.2em;
             font-weight: bold;
@@ -82,6 +92,7 @@ func GetDocsHTML(appName, appVersion string, port int) string {
         }
         .status-success { background: #28a745; color: white; }
         .status-warning { background: #ffc107; color: #333; }
+        .status-unknown { background: #adb5bd; color: white; }
         .model-desc { color: #666; font-size: 0.95em; line-height: 1.5; }
         .code-block {
             background: #2d2d2d;
@@ -164,11 +175,11 @@ func GetDocsHTML(appName, appVersion string, port int) string {
                     </div>
                     <div class="stat-card">
                         <div class="stat-label">可用模型</div>
-                        <div class="stat-value">6</div>
+                        <div class="stat-value">%d</div>
                     </div>
                     <div class="stat-card">
-                        <div class="stat-label">成功率</div>
-                        <div class="stat-value">67%%</div>
+                        <div class="stat-label">模型总数</div>
+                        <div class="stat-value">%d</div>
                     </div>
                 </div>
             </div>
@@ -176,65 +187,7 @@ func GetDocsHTML(appName, appVersion string, port int) string {
             <div class="section">
                 <h2>🤖 支持的模型</h2>
                 
-                <h3>✅ 可用模型 (4/6)</h3>
-                <div class="model-grid">
-                    <div class="model-card success">
-                        <div class="model-name">claude-sonnet-4.5</div>
-                        <span class="model-status status-success">✓ 可用</span>
-                        <div class="model-desc">
-                            <strong>推荐使用</strong><br>
-                            Claude Sonnet 4.5 - 通用任务的最佳选择，平衡性能与质量
-                        </div>
-                    </div>
-                    
-                    <div class="model-card success">
-                        <div class="model-name">gpt-5</div>
-                        <span class="model-status status-success">✓ 可用</span>
-                        <div class="model-desc">
-                            GPT-5 - 最新一代模型，适合高级推理和复杂任务
-                        </div>
-                    </div>
-                    
-                    <div class="model-card success">
-                        <div class="model-name">claude-opus-4.1</div>
-                        <span class="model-status status-success">✓ 可用</span>
-                        <div class="model-desc">
-                            Claude Opus 4.1 - 适合需要深度思考的复杂任务
-                        </div>
-                    </div>
-                    
-                    <div class="model-card success">
-                        <div class="model-name">gpt-4.1</div>
-                        <span class="model-status status-success">✓ 可用</span>
-                        <div class="model-desc">
-                            GPT-4.1 - 快速响应，适合常规对话和任务
-                        </div>
-                    </div>
-                </div>
-                
-                <h3>⚠️ 暂不可用 (2/6)</h3>
-                <div class="info-box">
-                    <strong>注意：</strong> 以下 Gemini 模型目前从 Notion AI 
-返回空响应，可能是 Notion AI 的支持问题。
-                </div>
-                <div class="model-grid">
-                    <div class="model-card warning">
-                        <div class="model-name">gemini-2.5-flash</div>
-                        <span class="model-status status-warning">⚠ 暂不可用</span>
-                        <div class="model-desc">
-                            Gemini 2.5 Flash - 快速对话模型（目前返回空响应）
-                        </div>
-                    </div>
-                    
-                    <div class="model-card warning">
-                        <div class="model-name">gemini-2.5-pro</div>
-                        <span class="model-status status-warning">⚠ 暂不可用</span>
-                        <div class="model-desc">
-                            Gemini 2.5 Pro - 高质量输出模型（目前返回空响应）
-                        </div>
-                    </div>
-                </div>
-            </div>
+%s            </div>
 
             <div class="section">
                 <h2>📡 API 端点</h2>
@@ -320,8 +273,7 @@ for chunk in response:
             <div class="section">
                 <h2>📝 测试建议</h2>
                 <ul style="padding-left: 20px; line-height: 2;">
-                    <li><strong>推荐使用：</strong> claude-sonnet-4.5, gpt-5, claude-opus-4.1, gpt-4.1</li>
-                    <li><strong>避免使用：</strong> gemini-2.5-flash, gemini-2.5-pro（目前不可用）</li>
+                    <li><strong>模型选择：</strong> 上方列表根据 Notion 工作区实时查询的结果生成，也可以通过 <code>GET /v1/models</code> 获取</li>
                     <li><strong>流式响应：</strong> 建议使用流式模式以获得更好的用户体验</li>
                     <li><strong>超时设置：</strong> 建议设置 180 秒以上的超时时间</li>
                 </ul>
@@ -334,5 +286,63 @@ for chunk in response:
         </div>
     </div>
 </body>
-</html>`, appName, appName, appVersion, port, port, port, port, port, appName, appVersion)
-}
\ No newline at end of file
+</html>`, appName, appName, appVersion, port, available, len(models), modelCardsHTML(models, available), port, port, port, port, appName, appVersion)
+}
+
+// DocsModel 文档页面展示的模型
+type DocsModel struct {
+	ID          string
+	NotionModel string
+	DisplayName string
+	// Available 工作区能否使用该模型，nil 表示尚未查询到
+	Available *bool
+}
+
+// modelCardsHTML 生成模型列表，按可用、未知、不可用分组
+func modelCardsHTML(models []DocsModel, available int) string {
+	var ok, unknown, unavailable []DocsModel
+	for _, m := range models {
+		switch {
+		case m.Available == nil:
+			unknown = append(unknown, m)
+		case *m.Available:
+			ok = append(ok, m)
+		default:
+			unavailable = append(unavailable, m)
+		}
+	}
+
+	var b strings.Builder
+	if len(ok) > 0 {
+		fmt.Fprintf(&b, "                <h3>✅ 可用模型 (%d/%d)</h3>\n", available, len(models))
+		writeModelCards(&b, ok, "success", "✓ 可用")
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(&b, "                <h3>❔ 尚未确认 (%d/%d)</h3>\n", len(unknown), len(models))
+		b.WriteString("                <div class=\"info-box\"><strong>注意：</strong> 尚未从 Notion 查询到工作区的可用模型，以下模型来自模型表配置。</div>\n")
+		writeModelCards(&b, unknown, "unknown", "? 未确认")
+	}
+	if len(unavailable) > 0 {
+		fmt.Fprintf(&b, "                <h3>⚠️ 暂不可用 (%d/%d)</h3>\n", len(unavailable), len(models))
+		b.WriteString("                <div class=\"info-box\"><strong>注意：</strong> 以下模型当前 Notion 工作区没有返回或已禁用。</div>\n")
+		writeModelCards(&b, unavailable, "warning", "⚠ 暂不可用")
+	}
+	return b.String()
+}
+
+func writeModelCards(b *strings.Builder, models []DocsModel, class, status string) {
+	b.WriteString("                <div class=\"model-grid\">\n")
+	for _, m := range models {
+		desc := "Notion 代号: " + html.EscapeString(m.NotionModel)
+		if m.DisplayName != "" {
+			desc = html.EscapeString(m.DisplayName) + "<br>" + desc
+		}
+		fmt.Fprintf(b, `                    <div class="model-card %s">
+                        <div class="model-name">%s</div>
+                        <span class="model-status status-%s">%s</span>
+                        <div class="model-desc">%s</div>
+                    </div>
+`, class, html.EscapeString(m.ID), class, status, desc)
+	}
+	b.WriteString("                </div>\n")
+}
@@ -20,11 +20,11 @@ func CreateSSEData(data interface{}) []byte {
 
 // ChatCompletionChunk OpenAI 聊天补全响应块结构
 type ChatCompletionChunk struct {
-	ID      string                 `json:"id"`
-	Object  string                 `json:"object"`
-	Created int64                  `json:"created"`
-	Model   string                 `json:"model"`
-	Choices []CompletionChoice     `json:"choices"`
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
 }
 
 // CompletionChoice 选择结构
@@ -37,7 +37,7 @@ type CompletionChoice struct {
 // CreateChatCompletionChunk 创建聊天补全响应块
 func CreateChatCompletionChunk(requestID, model string, content *string, finishReason *string, role *string) ChatCompletionChunk {
 	delta := make(map[string]interface{})
-	
+
 	if role != nil {
 		delta["role"] = *role
 	}
@@ -45,6 +45,11 @@ func CreateChatCompletionChunk(requestID, model string, content *string, finishR
 		delta["content"] = *content
 	}
 
+	return CreateChatCompletionDeltaChunk(requestID, model, delta, finishReason)
+}
+
+// CreateChatCompletionDeltaChunk 使用任意 delta 字段（如 reasoning_content）创建聊天补全响应块
+func CreateChatCompletionDeltaChunk(requestID, model string, delta map[string]interface{}, finishReason *string) ChatCompletionChunk {
 	return ChatCompletionChunk{
 		ID:      requestID,
 		Object:  "chat.completion.chunk",
@@ -69,6 +74,7 @@ type ErrorResponse struct {
 type ErrorDetail struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
 }
 
 // CreateErrorSSE 创建错误 SSE 响应
@@ -85,4 +91,4 @@ func CreateErrorSSE(message string) []byte {
 // StringPtr 返回字符串指针
 func StringPtr(s string) *string {
 	return &s
-}
\ No newline at end of file
+}
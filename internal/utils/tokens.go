@@ -0,0 +1,27 @@
+package utils
+
+import "unicode"
+
+// EstimateTokens 粗略估算文本的 token 数。
+// Notion 不返回 token 用量，这里按 CJK 字符每个约 1 个 token、其余字符约 4 个 1 个 token 估算。
+func EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	cjk := 0
+	other := 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+
+	tokens := cjk + (other+3)/4
+	if tokens == 0 {
+		tokens = 1
+	}
+	return tokens
+}
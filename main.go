@@ -1,12 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"notion-2api-go/internal/auth"
 	"notion-2api-go/internal/config"
+	"notion-2api-go/internal/conversations"
+	"notion-2api-go/internal/logging"
+	"notion-2api-go/internal/metrics"
 	"notion-2api-go/internal/providers"
+	"notion-2api-go/internal/ratelimit"
+	"notion-2api-go/internal/threads"
 	"notion-2api-go/internal/utils"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -14,34 +30,83 @@ import (
 
 var provider providers.BaseProvider
 
-func main() {
-	// 设置日志格式
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-	log.SetOutput(os.Stdout)
-	log.SetLevel(log.InfoLevel)
+// keyStore 客户端 API Key 存储
+var keyStore *auth.Store
+
+// limiter 全局、按 IP 和按 API Key 的限流器
+var limiter = ratelimit.New()
 
+// startedAt 进程启动时间
+var startedAt = time.Now()
+
+func main() {
 	// 加载配置
 	cfg := config.LoadConfig()
+
+	// 设置日志格式、级别和脱敏规则
+	secrets := make([]string, 0, len(cfg.Accounts))
+	for _, account := range cfg.Accounts {
+		secrets = append(secrets, account.Cookie)
+	}
+	if err := logging.Setup(logging.Options{
+		Format:  cfg.LogFormat,
+		Level:   cfg.LogLevel,
+		Capture: cfg.LogDebugCapture,
+		Secrets: secrets,
+	}); err != nil {
+		log.Fatalf("配置错误: %v", err)
+	}
 	log.Infof("应用启动中... %s v%s", cfg.AppName, cfg.AppVersion)
 	log.Info("服务已配置为 Notion AI 代理模式。")
 	log.Infof("服务将在 http://localhost:%d 上可用", cfg.NginxPort)
 
+	// 设置 Gin 模式，回放模式下 Provider 内部也会创建 Gin 路由
+	gin.SetMode(gin.ReleaseMode)
+
 	// 初始化 Provider
-	var err error
-	provider, err = providers.NewNotionAIProvider(cfg)
+	notion, err := providers.NewNotionAIProvider(cfg)
 	if err != nil {
 		log.Fatalf("初始化 Notion Provider 失败: %v", err)
 	}
 
-	// 设置 Gin 模式
-	gin.SetMode(gin.ReleaseMode)
+	// 打开对话与 Notion 线程的对应关系存储
+	if cfg.ConversationMode != "off" {
+		store, err := conversations.Open(cfg.ConversationsFile, time.Duration(cfg.ConversationTTL)*time.Second)
+		if err != nil {
+			log.Fatalf("打开对话存储失败: %v", err)
+		}
+		defer store.Close()
+		notion.SetConversationStore(store)
+		log.Infof("多轮对话复用 Notion 线程: 模式 %s，已有 %d 个对话", cfg.ConversationMode, store.Len())
+	}
+
+	// 打开待归档线程的记录，API Key 可以单独设置保留时间，所以总是启用
+	tracker, err := threads.Open(cfg.ThreadsFile)
+	if err != nil {
+		log.Fatalf("打开线程记录失败: %v", err)
+	}
+	defer tracker.Close()
+	notion.SetThreadTracker(tracker)
+	if cfg.ThreadRetention >= 0 {
+		log.Infof("Notion 线程保留 %d 秒后归档，待归档 %d 个", cfg.ThreadRetention, tracker.Len())
+	}
+	provider = notion
+
+	// 打开客户端 API Key 存储
+	keyStore, err = auth.Open(cfg.APIKeysFile)
+	if err != nil {
+		log.Fatalf("打开 API Key 存储失败: %v", err)
+	}
+	defer keyStore.Close()
+	if !authRequired(cfg) {
+		log.Warn("未设置 API_MASTER_KEY 且没有客户端 API Key，所有接口均不需要认证。")
+	}
 
 	// 创建 Gin 路由
 	r := gin.New()
-	r.Use(gin.Logger())
+	r.Use(logging.Middleware())
 	r.Use(gin.Recovery())
+	r.Use(metrics.Middleware())
 
 	// 根路径
 	r.GET("/", func(c *gin.Context) {
@@ -50,73 +115,226 @@ func main() {
 		})
 	})
 
+	// 存活检查：进程能处理请求即返回 200
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"status":         "ok",
+			"uptime_seconds": int(time.Since(startedAt).Seconds()),
+		})
+	})
+
+	// 就绪检查：没有任何可用的 Notion 账号时返回 503，编排系统应停止向本实例转发流量
+	r.GET("/readyz", func(c *gin.Context) {
+		report := provider.Readiness()
+		status := 200
+		if !report.Ready {
+			status = 503
+		}
+		c.JSON(status, report)
+	})
+
 	// 文档页面
 	r.GET("/docs", func(c *gin.Context) {
-		log.Infof("访问文档页面 - 来源: %s, User-Agent: %s", c.ClientIP(), c.Request.UserAgent())
+		logging.FromContext(c.Request.Context()).Infof("访问文档页面 - 来源: %s, User-Agent: %s", c.ClientIP(), c.Request.UserAgent())
 		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(200, utils.GetDocsHTML(cfg.AppName, cfg.AppVersion, cfg.NginxPort))
+		var models []utils.DocsModel
+		for _, model := range provider.ListModels() {
+			models = append(models, utils.DocsModel{
+				ID:          model.ID,
+				NotionModel: model.NotionModel,
+				DisplayName: model.DisplayName,
+				Available:   model.Available,
+			})
+		}
+		c.String(200, utils.GetDocsHTML(cfg.AppName, cfg.AppVersion, cfg.NginxPort, models))
 	})
 
 	// API 路由
 	api := r.Group("/v1")
 	{
 		// OpenAI 兼容 - 聊天补全
-		api.POST("/chat/completions", authMiddleware(cfg), chatCompletionsHandler)
+		api.POST("/chat/completions", authMiddleware(cfg, auth.EndpointChatCompletions), limitMiddleware(cfg, false), quotaMiddleware(false), chatCompletionsHandler)
 
 		// Anthropic 兼容 - Messages API (Claude CLI 使用)
-		api.POST("/messages", authMiddlewareAnthropic(cfg), messagesHandler)
+		api.POST("/messages", authMiddlewareAnthropic(cfg, auth.EndpointMessages), limitMiddleware(cfg, true), quotaMiddleware(true), messagesHandler)
+
+		// 模型列表和单个模型，OpenAI 与 Anthropic 客户端共用，按 anthropic-version 请求头区分认证方式和响应格式；
+		// 只限流，不计入 Key 的请求配额
+		modelsAuth := byProtocol(authMiddleware(cfg, auth.EndpointModels), authMiddlewareAnthropic(cfg, auth.EndpointModels))
+		modelsLimit := byProtocol(limitMiddleware(cfg, false), limitMiddleware(cfg, true))
+		api.GET("/models", modelsAuth, modelsLimit, listModelsHandler)
+		api.GET("/models/:id", modelsAuth, modelsLimit, getModelHandler)
+	}
 
-		// 模型列表
-		api.GET("/models", authMiddleware(cfg), listModelsHandler)
+	// 管理接口 - 客户端 API Key 管理
+	auth.RegisterAdminRoutes(r.Group("/admin", adminMiddleware(cfg)), keyStore)
+
+	// Prometheus 指标
+	if cfg.MetricsEnabled {
+		if cfg.MetricsToken == "" {
+			log.Warn("未设置 METRICS_TOKEN，不开放 /metrics。")
+		} else {
+			r.GET("/metrics", metricsMiddleware(cfg), metrics.Handler())
+		}
 	}
 
 	// 启动服务器
-	port := fmt.Sprintf(":%d", cfg.NginxPort)
-	log.Infof("服务器启动在端口 %s", port)
-	if err := r.Run(port); err != nil {
-		log.Fatalf("启动服务器失败: %v", err)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.NginxPort),
+		Handler: r,
+	}
+	go func() {
+		log.Infof("服务器启动在端口 %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("启动服务器失败: %v", err)
+		}
+	}()
+
+	// 模型配置文件热加载：文件变化或收到 SIGHUP 时重新加载，不影响已建立的连接
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	if cfg.ModelsFile != "" {
+		watcher := config.NewModelsWatcher(cfg.ModelsFile, cfg.DefaultModel)
+		if cfg.ModelsReloadInterval > 0 {
+			go watcher.Watch(time.Duration(cfg.ModelsReloadInterval)*time.Second, stopWatch)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				log.Info("收到 SIGHUP，重新加载模型配置")
+				watcher.Reload()
+			}
+		}()
+	}
+
+	// 等待退出信号，再次收到信号时按默认行为立即退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	shutdown(srv, time.Duration(cfg.ShutdownTimeout)*time.Second)
+
+	// 停止后台任务后再关闭各存储，保证最后一次写入不会丢失
+	notion.Stop()
+}
+
+// abortGrace 中止推理后等待处理函数写完错误事件的时间
+const abortGrace = 5 * time.Second
+
+// shutdown 停止接收新请求，等待进行中的请求（包括流式响应）完成。
+// 超过 drainTimeout 仍未完成时中止剩余的 Notion 推理，流式响应发送错误事件后结束。
+func shutdown(srv *http.Server, drainTimeout time.Duration) {
+	log.Infof("收到退出信号，停止接收新请求，最多等待 %s 让进行中的请求完成", drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err == nil {
+		log.Info("所有请求已完成，服务已退出")
+		return
+	}
+
+	log.Warn("等待超时，中止进行中的 Notion 推理")
+	provider.AbortInFlight()
+
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), abortGrace)
+	defer graceCancel()
+	if err := srv.Shutdown(graceCtx); err != nil {
+		log.Errorf("仍有请求未结束，强制关闭连接: %v", err)
+		srv.Close()
+		return
+	}
+	log.Info("进行中的推理已中止，服务已退出")
+}
+
+// authKeyContextKey 通过认证的客户端 API Key 在 gin 上下文中的键
+const authKeyContextKey = "auth_key"
+
+// abortWithError 按接口格式（OpenAI 或 Anthropic）返回错误并中止请求
+func abortWithError(c *gin.Context, anthropic bool, status int, errType, message string) {
+	if anthropic {
+		c.AbortWithStatusJSON(status, gin.H{
+			"type":  "error",
+			"error": map[string]string{"type": errType, "message": message},
+		})
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": gin.H{"message": message, "type": errType},
+	})
+}
+
+// masterKeyEnabled API_MASTER_KEY 为空或 "1" 时视为未设置主密钥
+func masterKeyEnabled(cfg *config.Settings) bool {
+	return cfg.APIMasterKey != "" && cfg.APIMasterKey != "1"
+}
+
+// authRequired 未设置主密钥且没有任何客户端 API Key 时不做认证（兼容旧配置）
+func authRequired(cfg *config.Settings) bool {
+	return masterKeyEnabled(cfg) || keyStore.Len() > 0
+}
+
+// checkAPIKey 校验 API Key 和接口权限，失败时返回状态码、错误类型和错误信息。
+// 主密钥拥有全部权限；客户端 Key 通过后存入上下文，供限流、配额、模型权限检查和用量统计使用。
+func checkAPIKey(c *gin.Context, cfg *config.Settings, endpoint, apiKey string) (int, string, string) {
+	if masterKeyEnabled(cfg) && subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.APIMasterKey)) == 1 {
+		return 0, "", ""
+	}
+
+	key, err := keyStore.Authenticate(apiKey)
+	if err != nil {
+		return 403, "authentication_error", err.Error()
+	}
+	if !key.AllowsEndpoint(endpoint) {
+		return 403, "permission_error", fmt.Sprintf("API Key 无权访问 %s 接口", endpoint)
+	}
+
+	c.Set(authKeyContextKey, key)
+	if len(key.Models) > 0 {
+		c.Set(providers.ModelPermissionKey, key.AllowsModel)
+	}
+	if key.ThreadRetention != nil {
+		c.Set(providers.ThreadRetentionKey, time.Duration(*key.ThreadRetention)*time.Second)
+	}
+	return 0, "", ""
+}
+
+// recordKeyUsage 请求结束后把 provider 估算的 token 用量计入客户端 Key
+func recordKeyUsage(c *gin.Context) {
+	if value, ok := c.Get(authKeyContextKey); ok {
+		keyStore.RecordTokens(value.(*auth.Key).ID, int64(c.GetInt(providers.UsageTokensKey)))
 	}
 }
 
 // authMiddleware API 认证中间件 (OpenAI 格式 Bearer Token)
-func authMiddleware(cfg *config.Settings) gin.HandlerFunc {
+func authMiddleware(cfg *config.Settings, endpoint string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if cfg.APIMasterKey != "" && cfg.APIMasterKey != "1" {
+		if authRequired(cfg) {
 			authorization := c.GetHeader("Authorization")
 			if authorization == "" || !strings.Contains(strings.ToLower(authorization), "bearer") {
-				c.JSON(401, gin.H{
-					"error": "需要 Bearer Token 认证。",
-				})
-				c.Abort()
+				abortWithError(c, false, 401, "authentication_error", "需要 Bearer Token 认证。")
 				return
 			}
 
 			parts := strings.Split(authorization, " ")
 			if len(parts) != 2 {
-				c.JSON(401, gin.H{
-					"error": "无效的认证格式。",
-				})
-				c.Abort()
+				abortWithError(c, false, 401, "authentication_error", "无效的认证格式。")
 				return
 			}
 
-			token := parts[1]
-			if token != cfg.APIMasterKey {
-				c.JSON(403, gin.H{
-					"error": "无效的 API Key。",
-				})
-				c.Abort()
+			if status, errType, message := checkAPIKey(c, cfg, endpoint, parts[1]); status != 0 {
+				abortWithError(c, false, status, errType, message)
 				return
 			}
 		}
 		c.Next()
+		recordKeyUsage(c)
 	}
 }
 
 // authMiddlewareAnthropic API 认证中间件 (Anthropic 格式 x-api-key)
-func authMiddlewareAnthropic(cfg *config.Settings) gin.HandlerFunc {
+func authMiddlewareAnthropic(cfg *config.Settings, endpoint string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if cfg.APIMasterKey != "" && cfg.APIMasterKey != "1" {
+		if authRequired(cfg) {
 			// Anthropic 使用 x-api-key 头
 			apiKey := c.GetHeader("x-api-key")
 			// 也支持 Authorization: Bearer 格式
@@ -129,24 +347,139 @@ func authMiddlewareAnthropic(cfg *config.Settings) gin.HandlerFunc {
 			}
 
 			if apiKey == "" {
-				c.JSON(401, gin.H{
-					"type":  "error",
-					"error": map[string]string{"type": "authentication_error", "message": "需要 API Key 认证"},
-				})
-				c.Abort()
+				abortWithError(c, true, 401, "authentication_error", "需要 API Key 认证")
 				return
 			}
 
-			if apiKey != cfg.APIMasterKey {
-				c.JSON(403, gin.H{
-					"type":  "error",
-					"error": map[string]string{"type": "authentication_error", "message": "无效的 API Key"},
-				})
-				c.Abort()
+			if status, errType, message := checkAPIKey(c, cfg, endpoint, apiKey); status != 0 {
+				abortWithError(c, true, status, errType, message)
 				return
 			}
 		}
 		c.Next()
+		recordKeyUsage(c)
+	}
+}
+
+// byProtocol 按请求格式选择 OpenAI 或 Anthropic 风格的中间件，用于两种客户端共用的接口
+func byProtocol(openai, anthropic gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if providers.IsAnthropicRequest(c) {
+			anthropic(c)
+			return
+		}
+		openai(c)
+	}
+}
+
+// limitMiddleware 限流检查，需放在认证中间件之后，依次检查全局、客户端 IP 和 API Key 的限流规则
+func limitMiddleware(cfg *config.Settings, anthropic bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checks := []ratelimit.Check{
+			{Scope: "global", Rule: ratelimit.Rule{RequestsPerMinute: cfg.RateLimitGlobalRPM, MaxConcurrent: cfg.RateLimitGlobalConcurrency}},
+			{Scope: "ip", Key: c.ClientIP(), Rule: ratelimit.Rule{RequestsPerMinute: cfg.RateLimitIPRPM, MaxConcurrent: cfg.RateLimitIPConcurrency}},
+		}
+		var key *auth.Key
+		if value, ok := c.Get(authKeyContextKey); ok {
+			key = value.(*auth.Key)
+			rule := ratelimit.Rule{RequestsPerMinute: cfg.RateLimitKeyRPM, MaxConcurrent: cfg.RateLimitKeyConcurrency}
+			if key.RequestsPerMinute > 0 {
+				rule.RequestsPerMinute = key.RequestsPerMinute
+			}
+			if key.MaxConcurrent > 0 {
+				rule.MaxConcurrent = key.MaxConcurrent
+			}
+			checks = append(checks, ratelimit.Check{Scope: "key", Key: key.ID, Rule: rule})
+		}
+
+		decision, release := limiter.Acquire(checks)
+		setRateLimitHeaders(c, decision)
+		if !decision.Allowed {
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			scope := map[string]string{"global": "全局", "ip": "IP", "key": "API Key"}[decision.Scope]
+			message := fmt.Sprintf("请求过于频繁 (限流范围: %s, %d 次/分钟)，请在 %d 秒后重试", scope, decision.Limit, retryAfter)
+			if decision.Concurrent {
+				message = fmt.Sprintf("同时进行中的请求过多 (限流范围: %s, 最多 %d 个)，请在 %d 秒后重试", scope, decision.Limit, retryAfter)
+			}
+			logging.FromContext(c.Request.Context()).Warnf("请求被限流 (%s): %s", decision.Scope, c.ClientIP())
+			abortWithError(c, anthropic, 429, "rate_limit_error", message)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// quotaMiddleware 检查并计入 API Key 的请求配额，需放在限流中间件之后，被限流的请求不占用配额。
+// 只用于聊天补全和 Messages 接口，查询模型列表不计入配额。
+func quotaMiddleware(anthropic bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(authKeyContextKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		if err := keyStore.Admit(value.(*auth.Key).ID); err != nil {
+			if _, ok := err.(*auth.QuotaError); ok {
+				errType := "insufficient_quota"
+				if anthropic {
+					errType = "rate_limit_error"
+				}
+				abortWithError(c, anthropic, 429, errType, err.Error())
+			} else {
+				abortWithError(c, anthropic, 403, "authentication_error", err.Error())
+			}
+			return
+		}
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders 写出 x-ratelimit-* 响应头，被拒绝时附带 Retry-After
+func setRateLimitHeaders(c *gin.Context, decision ratelimit.Decision) {
+	if decision.Limit > 0 && !decision.Concurrent {
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(decision.Limit))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(decision.Remaining))
+		c.Header("x-ratelimit-reset-requests", decision.Reset.Round(time.Millisecond).String())
+	}
+	if !decision.Allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+	}
+}
+
+// adminMiddleware 管理接口认证，只接受主密钥
+func adminMiddleware(cfg *config.Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !masterKeyEnabled(cfg) {
+			abortWithError(c, false, 403, "permission_error", "未设置 API_MASTER_KEY，管理接口不可用")
+			return
+		}
+
+		apiKey := c.GetHeader("x-api-key")
+		if authorization := c.GetHeader("Authorization"); apiKey == "" && len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+			apiKey = authorization[7:]
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.APIMasterKey)) != 1 {
+			abortWithError(c, false, 403, "permission_error", "管理接口需要使用 API_MASTER_KEY 认证")
+			return
+		}
+		c.Next()
+	}
+}
+
+// metricsMiddleware 校验 METRICS_TOKEN，与 API Key 相互独立，方便只给监控系统抓取权限
+func metricsMiddleware(cfg *config.Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := ""
+		if authorization := c.GetHeader("Authorization"); len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+			token = authorization[7:]
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			abortWithError(c, false, 401, "authentication_error", "访问 /metrics 需要有效的 METRICS_TOKEN")
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -160,8 +493,11 @@ func chatCompletionsHandler(c *gin.Context) {
 		return
 	}
 
+	model := requestModel(requestData)
+	c.Set(metrics.ModelContextKey, metricsModel(model))
+
 	if err := provider.ChatCompletion(c, requestData); err != nil {
-		log.Errorf("处理聊天请求时发生错误: %v", err)
+		logging.FromContext(c.Request.Context()).Errorf("处理聊天请求时发生错误: %v", err)
 		// 错误已在 provider 中处理并发送给客户端
 	}
 }
@@ -169,10 +505,15 @@ func chatCompletionsHandler(c *gin.Context) {
 // listModelsHandler 处理模型列表请求
 func listModelsHandler(c *gin.Context) {
 	if err := provider.GetModels(c); err != nil {
-		c.JSON(500, gin.H{
-			"error": fmt.Sprintf("获取模型列表失败: %v", err),
-		})
-		return
+		logging.FromContext(c.Request.Context()).Warnf("获取模型列表失败: %v", err)
+		// 错误已在 provider 中处理并发送给客户端
+	}
+}
+
+// getModelHandler 处理单个模型查询请求
+func getModelHandler(c *gin.Context) {
+	if err := provider.GetModel(c, c.Param("id")); err != nil {
+		logging.FromContext(c.Request.Context()).Warnf("查询模型失败: %v", err)
 	}
 }
 
@@ -187,12 +528,31 @@ func messagesHandler(c *gin.Context) {
 		return
 	}
 
+	model := requestModel(requestData)
+	c.Set(metrics.ModelContextKey, metricsModel(model))
+
 	// 转换 Anthropic 格式到 OpenAI 格式
 	convertedRequest := convertAnthropicToOpenAI(requestData)
 
 	if err := provider.ChatCompletionAnthropic(c, convertedRequest, requestData); err != nil {
-		log.Errorf("处理 Anthropic 消息请求时发生错误: %v", err)
+		logging.FromContext(c.Request.Context()).Errorf("处理 Anthropic 消息请求时发生错误: %v", err)
+	}
+}
+
+// requestModel 返回请求使用的模型，未指定时为默认模型
+func requestModel(requestData map[string]interface{}) string {
+	if model, ok := requestData["model"].(string); ok && model != "" {
+		return model
 	}
+	return config.Config.DefaultModel
+}
+
+// metricsModel 指标中使用的模型名，别名和匹配别名规则的模型名归并到模型 ID，未知模型统一记为 other，避免客户端随意传值导致标签数量膨胀
+func metricsModel(model string) string {
+	if spec, _, ok := config.Models().Resolve(model); ok {
+		return spec.ID
+	}
+	return "other"
 }
 
 // convertAnthropicToOpenAI 将 Anthropic 请求格式转换为 OpenAI 格式
@@ -207,12 +567,23 @@ func convertAnthropicToOpenAI(anthropicReq map[string]interface{}) map[string]in
 	// 转换消息格式
 	var messages []interface{}
 
+	// Anthropic 的 system 是顶层字段，可能是字符串或 text 块数组
+	if system := anthropicSystemPrompt(anthropicReq["system"]); system != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": system,
+		})
+	}
+
 	// 转换 messages
 	if anthropicMessages, ok := anthropicReq["messages"].([]interface{}); ok {
 		for _, msg := range anthropicMessages {
 			if msgMap, ok := msg.(map[string]interface{}); ok {
 				role, _ := msgMap["role"].(string)
 				content := ""
+				var imageParts []interface{}
+				var toolCalls []interface{}
+				var toolResults []interface{}
 
 				// Anthropic content 可能是字符串或数组
 				switch c := msgMap["content"].(type) {
@@ -222,10 +593,25 @@ func convertAnthropicToOpenAI(anthropicReq map[string]interface{}) map[string]in
 					// 处理 content blocks
 					for _, block := range c {
 						if blockMap, ok := block.(map[string]interface{}); ok {
-							if blockMap["type"] == "text" {
+							switch blockMap["type"] {
+							case "text":
 								if text, ok := blockMap["text"].(string); ok {
 									content += text
 								}
+							case "image":
+								// 图片转换为 OpenAI 的 image_url 部分，由 provider 上传到 Notion
+								if url := anthropicImageURL(blockMap["source"]); url != "" {
+									imageParts = append(imageParts, map[string]interface{}{
+										"type":      "image_url",
+										"image_url": map[string]interface{}{"url": url},
+									})
+								}
+							case "tool_use":
+								// assistant 发起的工具调用转换为 OpenAI 的 tool_calls
+								toolCalls = append(toolCalls, anthropicToolCall(blockMap))
+							case "tool_result":
+								// 工具结果转换为 OpenAI 的 tool 消息
+								toolResults = append(toolResults, anthropicToolResult(blockMap))
 							}
 						}
 					}
@@ -234,10 +620,24 @@ func convertAnthropicToOpenAI(anthropicReq map[string]interface{}) map[string]in
 				// 清理控制字符
 				content = strings.ReplaceAll(content, "\x01", "")
 
-				messages = append(messages, map[string]interface{}{
+				// Anthropic 的工具结果位于 user 消息开头，需排在该轮正文之前
+				messages = append(messages, toolResults...)
+				if len(toolResults) > 0 && content == "" && len(imageParts) == 0 {
+					continue
+				}
+
+				message := map[string]interface{}{
 					"role":    role,
 					"content": content,
-				})
+				}
+				if len(imageParts) > 0 {
+					parts := []interface{}{map[string]interface{}{"type": "text", "text": content}}
+					message["content"] = append(parts, imageParts...)
+				}
+				if len(toolCalls) > 0 {
+					message["tool_calls"] = toolCalls
+				}
+				messages = append(messages, message)
 			}
 		}
 	}
@@ -249,10 +649,151 @@ func convertAnthropicToOpenAI(anthropicReq map[string]interface{}) map[string]in
 		openaiReq["stream"] = stream
 	}
 
+	// metadata.user_id 对应 OpenAI 的 user，CONVERSATION_MODE=user 时用于区分对话
+	if metadata, ok := anthropicReq["metadata"].(map[string]interface{}); ok {
+		if userID, ok := metadata["user_id"].(string); ok && userID != "" {
+			openaiReq["user"] = userID
+		}
+	}
+
 	// 转换 max_tokens
 	if maxTokens, ok := anthropicReq["max_tokens"].(float64); ok {
 		openaiReq["max_tokens"] = int(maxTokens)
 	}
 
+	// 转换工具定义：只转换客户端自定义工具，web_search、computer 等服务端工具由 Anthropic 执行，无法转发
+	if tools, ok := anthropicReq["tools"].([]interface{}); ok {
+		var openaiTools []interface{}
+		for _, tool := range tools {
+			if toolMap, ok := tool.(map[string]interface{}); ok {
+				if toolType, _ := toolMap["type"].(string); toolType != "" && toolType != "custom" {
+					log.Warnf("忽略不支持的 Anthropic 工具 %v (type: %s)", toolMap["name"], toolType)
+					continue
+				}
+				openaiTools = append(openaiTools, map[string]interface{}{
+					"type": "function",
+					"function": map[string]interface{}{
+						"name":        toolMap["name"],
+						"description": toolMap["description"],
+						"parameters":  toolMap["input_schema"],
+					},
+				})
+			}
+		}
+		openaiReq["tools"] = openaiTools
+	}
+
+	// 转换 tool_choice: auto / any / tool / none
+	if choice, ok := anthropicReq["tool_choice"].(map[string]interface{}); ok {
+		switch choice["type"] {
+		case "auto":
+			openaiReq["tool_choice"] = "auto"
+		case "any":
+			openaiReq["tool_choice"] = "required"
+		case "none":
+			openaiReq["tool_choice"] = "none"
+		case "tool":
+			openaiReq["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": choice["name"]},
+			}
+		}
+		if disable, ok := choice["disable_parallel_tool_use"].(bool); ok {
+			openaiReq["parallel_tool_calls"] = !disable
+		}
+	}
+
 	return openaiReq
-}
\ No newline at end of file
+}
+
+// anthropicToolCall 将 tool_use 内容块转换为 OpenAI 的 tool_call
+func anthropicToolCall(block map[string]interface{}) map[string]interface{} {
+	arguments := "{}"
+	if input, ok := block["input"]; ok && input != nil {
+		if data, err := json.Marshal(input); err == nil {
+			arguments = string(data)
+		}
+	}
+	return map[string]interface{}{
+		"id":   block["id"],
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":      block["name"],
+			"arguments": arguments,
+		},
+	}
+}
+
+// anthropicToolResult 将 tool_result 内容块转换为 OpenAI 的 tool 消息，
+// content 可能是字符串或内容块数组，图片等非文本块替换为占位说明，让模型知道结果中有内容被省略
+func anthropicToolResult(block map[string]interface{}) map[string]interface{} {
+	var content string
+	switch c := block["content"].(type) {
+	case string:
+		content = c
+	case []interface{}:
+		var parts []string
+		for _, item := range c {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if itemType, _ := itemMap["type"].(string); itemType != "text" {
+				parts = append(parts, fmt.Sprintf("[%s 内容已省略]", itemType))
+				continue
+			}
+			if text, ok := itemMap["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		content = strings.Join(parts, "\n")
+	}
+	if isError, _ := block["is_error"].(bool); isError {
+		content = "Error: " + content
+	}
+	return map[string]interface{}{
+		"role":         "tool",
+		"tool_call_id": block["tool_use_id"],
+		"content":      content,
+	}
+}
+
+// anthropicSystemPrompt 提取 Anthropic 请求中的 system 字段文本
+func anthropicSystemPrompt(system interface{}) string {
+	switch s := system.(type) {
+	case string:
+		return s
+	case []interface{}:
+		var parts []string
+		for _, block := range s {
+			if blockMap, ok := block.(map[string]interface{}); ok && blockMap["type"] == "text" {
+				if text, ok := blockMap["text"].(string); ok && text != "" {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// anthropicImageURL 将 Anthropic 图片块的 source 转换为 data: URL 或 http(s) URL
+func anthropicImageURL(source interface{}) string {
+	sourceMap, ok := source.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	switch sourceMap["type"] {
+	case "base64":
+		mediaType, _ := sourceMap["media_type"].(string)
+		data, _ := sourceMap["data"].(string)
+		if mediaType == "" || data == "" {
+			return ""
+		}
+		return fmt.Sprintf("data:%s;base64,%s", mediaType, data)
+	case "url":
+		url, _ := sourceMap["url"].(string)
+		return url
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertAnthropicTools(t *testing.T) {
+	req := map[string]interface{}{
+		"tools": []interface{}{
+			map[string]interface{}{"name": "get_weather", "description": "查询天气", "input_schema": map[string]interface{}{"type": "object"}},
+			map[string]interface{}{"type": "custom", "name": "lookup", "input_schema": map[string]interface{}{"type": "object"}},
+			map[string]interface{}{"type": "web_search_20250305", "name": "web_search"},
+			map[string]interface{}{"type": "computer_20250124", "name": "computer"},
+		},
+	}
+	tools, _ := convertAnthropicToOpenAI(req)["tools"].([]interface{})
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.(map[string]interface{})["function"].(map[string]interface{})["name"].(string))
+	}
+	if want := []string{"get_weather", "lookup"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("converted tools = %v, want %v", names, want)
+	}
+}
+
+func TestAnthropicToolResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		block map[string]interface{}
+		want  string
+	}{
+		{"字符串", map[string]interface{}{"content": "晴"}, "晴"},
+		{"文本块", map[string]interface{}{"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "第一段"},
+			map[string]interface{}{"type": "text", "text": "第二段"},
+		}}, "第一段\n第二段"},
+		{"图片块", map[string]interface{}{"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "截图如下"},
+			map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64"}},
+		}}, "截图如下\n[image 内容已省略]"},
+		{"错误", map[string]interface{}{"content": "超时", "is_error": true}, "Error: 超时"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.block["tool_use_id"] = "toolu_1"
+			got := anthropicToolResult(tt.block)
+			if got["content"] != tt.want || got["tool_call_id"] != "toolu_1" || got["role"] != "tool" {
+				t.Errorf("anthropicToolResult() = %v, want content %q", got, tt.want)
+			}
+		})
+	}
+}